@@ -1,20 +1,52 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"minivault/src/api"
+	"minivault/src/config"
 	"minivault/src/service"
 )
 
+// Build metadata, set with e.g.
+// -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "dev"
+	buildTime = "dev"
+)
+
 // @title MiniVault API
 // @version 1.0
 // @description A lightweight local REST API that simulates MiniVault's prompt-response functionality.
 // @host localhost:8080
 // @BasePath /
+// @securityDefinitions.apikey AdminToken
+// @in header
+// @name Authorization
 func main() {
+	// An optional config file overrides the environment variables below
+	configPath := flag.String("config", os.Getenv("CONFIG_PATH"), "YAML or JSON config file")
+	flag.Parse()
+	if *configPath != "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			log.Fatalf("Invalid config %s:\n%v", *configPath, err)
+		}
+		if err := cfg.Apply(); err != nil {
+			log.Fatalf("Failed to apply config: %v", err)
+		}
+	}
+
 	// Get configuration from environment
 	llmType := os.Getenv("LLM_TYPE")
 	if llmType == "" {
@@ -28,11 +60,38 @@ func main() {
 	}
 	defer logger.Close()
 
-	// Initialize generator service
+	// Initialize generator service. A backend that can't be created stops
+	// startup unless serving the stub in its place was asked for.
 	generator := service.NewGeneratorService(llmType)
+	if err := generator.BackendError(); err != nil {
+		if fallback, _ := strconv.ParseBool(os.Getenv("FALLBACK_TO_STUB")); !fallback {
+			log.Fatalf("Failed to initialize %s backend: %v (set FALLBACK_TO_STUB=true to serve the stub instead)", llmType, err)
+		}
+		log.Printf("WARNING: %s backend unavailable, serving the stub instead: %v", llmType, err)
+	}
+
+	// Optionally check the model answers a known prompt as expected
+	if prompt := os.Getenv("WARMUP_PROMPT"); prompt != "" {
+		probe := service.WarmupProbe{Prompt: prompt, Expect: os.Getenv("WARMUP_EXPECT")}
+		if value := os.Getenv("WARMUP_TIMEOUT"); value != "" {
+			if probe.Timeout, err = time.ParseDuration(value); err != nil {
+				log.Fatalf("Invalid WARMUP_TIMEOUT %q: %v", value, err)
+			}
+		}
+		if err := probe.Run(context.Background(), generator); err != nil {
+			if strict, _ := strconv.ParseBool(os.Getenv("WARMUP_STRICT")); strict {
+				log.Fatalf("Warmup failed: %v", err)
+			}
+			log.Printf("Warmup failed, serving anyway: %v", err)
+		} else {
+			fmt.Println("Warmup passed")
+		}
+	}
 
-	// Initialize handler
-	handler := api.NewHandler(generator, logger)
+	// Initialize handler, with the optional response cache after the warmup
+	// so the warmup always reaches the backend
+	handler := api.NewHandler(service.CacheFromEnv(generator), logger)
+	handler.SetBuildInfo(api.BuildInfo{Version: version, Commit: commit, BuildTime: buildTime})
 
 	// Setup router
 	router := api.SetupRouter(handler)
@@ -43,11 +102,44 @@ func main() {
 		port = "8080"
 	}
 
-	fmt.Printf("Starting MiniVault API server on :%s...\n", port)
+	fmt.Printf("Starting MiniVault %s (%s) API server on :%s...\n", version, commit, port)
 	fmt.Printf("Using LLM type: %s\n", llmType)
 
 	fmt.Printf("Swagger documentation available at http://localhost:%s/swagger/index.html\n", port)
-	if err := router.Run(":" + port); err != nil {
+
+	ln, err := net.Listen("tcp", ":"+port)
+	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+
+	// Drain in-flight requests on SIGINT/SIGTERM; the deferred logger.Close
+	// runs only once Serve has returned
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Optionally check the backend in the background, backing off while it's down
+	if value := os.Getenv("HEALTH_POLL_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			log.Fatalf("Invalid HEALTH_POLL_INTERVAL %q: must be a positive duration", value)
+		}
+		var maxInterval time.Duration
+		if value := os.Getenv("HEALTH_POLL_MAX_INTERVAL"); value != "" {
+			if maxInterval, err = time.ParseDuration(value); err != nil {
+				log.Fatalf("Invalid HEALTH_POLL_MAX_INTERVAL %q: %v", value, err)
+			}
+		}
+		poller := service.NewHealthPoller(generator, interval, maxInterval)
+		handler.SetHealthPoller(poller)
+		go poller.Run(ctx)
+	}
+	grace := api.DefaultShutdownGracePeriod
+	if value := os.Getenv("SHUTDOWN_GRACE_PERIOD"); value != "" {
+		if grace, err = time.ParseDuration(value); err != nil {
+			log.Fatalf("Invalid SHUTDOWN_GRACE_PERIOD %q: %v", value, err)
+		}
+	}
+	if err := api.Serve(ctx, ln, router, grace); err != nil {
+		log.Printf("Server stopped: %v", err)
+	}
 }
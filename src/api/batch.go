@@ -0,0 +1,161 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"minivault/src/llm"
+	"minivault/src/service"
+	"minivault/src/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// MaxBatchPrompts caps the number of prompts in one batch request
+	MaxBatchPrompts = 100
+
+	// DefaultBatchConcurrency is used when BATCH_CONCURRENCY is unset
+	DefaultBatchConcurrency = 4
+)
+
+// @Summary Generate text for several prompts
+// @Description Generate a response for each prompt with the same settings. Prompts run
+// @Description concurrently, up to BATCH_CONCURRENCY at a time, and results are returned in
+// @Description prompt order. A failed prompt is reported in its result and doesn't fail the batch.
+// @Tags generation
+// @Accept json
+// @Produce json
+// @Param request body types.BatchRequest true "Prompts for text generation"
+// @Success 200 {object} types.BatchResponse
+// @Failure 400 {object} map[string]string
+// @Router /generate/batch [post]
+func (h *Handler) HandleGenerateBatch(c *gin.Context) {
+	h.metrics.observeRequest(false)
+	var req types.BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logError("", err, false, logDetails(c))
+		writeError(c, ErrorCodeInvalidRequest, "Invalid request format")
+		return
+	}
+
+	prompt := strings.Join(req.Prompts, "\n")
+	if len(req.Prompts) == 0 || len(req.Prompts) > MaxBatchPrompts {
+		err := fmt.Errorf("prompts must hold between 1 and %d prompts", MaxBatchPrompts)
+		h.logError(prompt, err, false, logDetails(c))
+		writeError(c, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	if !h.checkModel(c, req.Model, prompt, false) {
+		return
+	}
+
+	opts := h.generator.EffectiveOptions(requestOptions(types.Request{
+		Model:       req.Model,
+		System:      req.System,
+		Stop:        req.Stop,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxTokens,
+	}))
+
+	// A fixed set of workers pulls prompt indexes, so at most
+	// batchConcurrency generations hit the backend at once
+	results := make([]types.BatchResult, len(req.Prompts))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(max(h.batchConcurrency, 1), len(req.Prompts)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = h.generateBatchItem(c, req.Prompts[i], batchItemOptions(opts, req.Seed, i))
+			}
+		}()
+	}
+	for i := range req.Prompts {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	tokens := 0
+	for _, result := range results {
+		tokens += result.TotalTokens
+	}
+	h.consumeTokens(c, tokens)
+
+	c.JSON(200, types.BatchResponse{Results: results})
+}
+
+// batchItemOptions returns the options for prompt i of a batch, seeded
+// with baseSeed+i when the batch has a seed
+func batchItemOptions(opts llm.Options, baseSeed *int, i int) llm.Options {
+	if baseSeed != nil {
+		seed := *baseSeed + i
+		opts.Seed = &seed
+	}
+	return opts
+}
+
+// generateBatchItem generates and logs one prompt of a batch, turning any
+// failure into the result's error
+func (h *Handler) generateBatchItem(c *gin.Context, prompt string, opts llm.Options) types.BatchResult {
+	details := logDetails(c)
+	details.Stop = opts.Stop
+	details.Model = h.modelFor(opts)
+
+	fail := func(err error, failure types.ErrorResponse) types.BatchResult {
+		h.logError(prompt, err, false, details)
+		return types.BatchResult{Error: failure.Error, Code: failure.Code}
+	}
+
+	if failure, err := h.checkPrompt(prompt, opts.Model, &details); err != nil {
+		return fail(err, failure)
+	}
+
+	model := details.Model
+	h.metrics.promptSizeBytes.WithLabelValues(model).Observe(float64(len(prompt)))
+	trace := &service.DecisionTrace{}
+	transfer := &llm.Transfer{}
+	// Each prompt gets its own deadline rather than sharing one across the
+	// batch
+	details.Timeout = h.timeouts.For(model)
+	ctx, cancel := contextWithTimeout(c.Request.Context(), details.Timeout)
+	defer cancel()
+	ctx = llm.WithTransfer(service.WithDecisionTrace(ctx, trace), transfer)
+	generationStart := time.Now()
+	result, err := h.generator.Generate(ctx, prompt, opts)
+	details.Duration = time.Since(generationStart)
+	h.metrics.observeGeneration(model, false, details.Duration)
+	details.Decisions = trace.Decisions()
+	details.Source = trace.Source()
+	details.Cache = trace.Cache()
+	details.BackendRequestBytes = transfer.RequestBytes()
+	details.BackendResponseBytes = transfer.ResponseBytes()
+	if err != nil {
+		return fail(err, generationFailure(ctx, err))
+	}
+
+	h.metrics.observeResponse(model, len(result.Response), h.tokenizer.CountTokens(result.Response))
+	details.CollapsedLines = result.CollapsedLines
+	details.Usage = result.Usage
+	if result.TimedOut {
+		details.FinishReason = service.FinishReasonTimeout
+	}
+
+	usage := h.usage(prompt, result)
+	h.publish(prompt, result.Response, model, false, details)
+	h.logger.LogInteraction(prompt, result.Response, false, details) // logging failures don't fail the prompt
+
+	return types.BatchResult{
+		Response:       result.Response,
+		FinishReason:   details.FinishReason,
+		PromptTokens:   usage.PromptTokens,
+		ResponseTokens: usage.CompletionTokens,
+		TotalTokens:    usage.PromptTokens + usage.CompletionTokens,
+	}
+}
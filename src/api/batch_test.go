@@ -0,0 +1,146 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"minivault/src/llm"
+	"minivault/src/service"
+	"minivault/src/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHandleGenerateBatch(t *testing.T) {
+	handler, mockGen, mockLogger := setupTestHandler()
+	mockGen.On("Generate", mock.Anything, "first", mock.Anything).Return(&llm.Result{Response: "one"}, nil)
+	mockGen.On("Generate", mock.Anything, "second", mock.Anything).Return(nil, errors.New("backend down"))
+	mockGen.On("Generate", mock.Anything, "third", mock.Anything).Return(&llm.Result{Response: "three"}, nil)
+	mockLogger.On("LogInteraction", "first", "one", false, mock.Anything).Return(nil).Once()
+	mockLogger.On("LogInteraction", "third", "three", false, mock.Anything).Return(nil).Once()
+	mockLogger.On("LogError", "second", mock.Anything, false, mock.Anything).Return(nil).Once()
+	mockLogger.On("LogError", "", mock.Anything, false, mock.Anything).Return(nil).Once()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/generate/batch", bytes.NewBufferString(`{"prompts":["first","second","","third"]}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	handler.HandleGenerateBatch(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response types.BatchResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	if assert.Len(t, response.Results, 4) {
+		assert.Equal(t, "one", response.Results[0].Response)
+		assert.Equal(t, "Failed to generate response", response.Results[1].Error)
+		assert.Equal(t, "prompt cannot be empty", response.Results[2].Error)
+		assert.Equal(t, "three", response.Results[3].Response)
+		assert.Empty(t, response.Results[3].Error)
+	}
+	mockGen.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
+
+func TestHandleGenerateBatch_Invalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantError string
+	}{
+		{name: "No prompts", body: `{"prompts":[]}`, wantError: "prompts must hold between 1 and 100 prompts"},
+		{name: "Model not allowed", body: `{"prompts":["first"],"model":"other"}`, wantError: `model "other" is not allowed`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, _, mockLogger := setupTestHandler()
+			mockLogger.On("LogError", mock.Anything, mock.Anything, false, mock.Anything).Return(nil)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/generate/batch", bytes.NewBufferString(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler.HandleGenerateBatch(c)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			var response map[string]string
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.wantError, response["error"])
+		})
+	}
+}
+
+func TestHandleGenerateBatch_Concurrency(t *testing.T) {
+	t.Setenv("BATCH_CONCURRENCY", "2")
+	handler, mockGen, mockLogger := setupTestHandler()
+
+	var running, peak atomic.Int32
+	mockGen.On("Generate", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			select {
+			case <-time.After(20 * time.Millisecond):
+			case <-args.Get(0).(context.Context).Done():
+			}
+			running.Add(-1)
+		}).Return(&llm.Result{Response: "ok"}, nil)
+	mockLogger.On("LogInteraction", mock.Anything, "ok", false, mock.Anything).Return(nil)
+
+	body, _ := json.Marshal(types.BatchRequest{Prompts: []string{"a", "b", "c", "d", "e", "f"}})
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/generate/batch", bytes.NewBuffer(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	handler.HandleGenerateBatch(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int32(2), peak.Load())
+	mockLogger.AssertNumberOfCalls(t, "LogInteraction", 6)
+}
+
+func TestHandleGenerateBatch_Seed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockLogger := new(MockLogger)
+	mockLogger.On("LogInteraction", mock.Anything, mock.Anything, false, mock.Anything).Return(nil)
+	handler := NewHandler(service.NewGeneratorService("stub"), mockLogger)
+
+	run := func() []types.BatchResult {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/generate/batch", bytes.NewBufferString(`{"prompts":["same","same","other"],"seed":7}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		handler.HandleGenerateBatch(c)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response types.BatchResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Results
+	}
+
+	// Each prompt gets the base seed plus its index, so a rerun matches item for item
+	first := run()
+	if assert.Len(t, first, 3) {
+		assert.True(t, strings.HasPrefix(first[0].Response, "[seed: 7] "), first[0].Response)
+		assert.True(t, strings.HasPrefix(first[1].Response, "[seed: 8] "), first[1].Response)
+		assert.True(t, strings.HasPrefix(first[2].Response, "[seed: 9] "), first[2].Response)
+	}
+	assert.Equal(t, first, run())
+}
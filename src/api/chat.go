@@ -0,0 +1,156 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"minivault/src/llm"
+	"minivault/src/service"
+	"minivault/src/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// chatRoles are the message roles a chat may contain
+var chatRoles = map[string]bool{"system": true, "user": true, "assistant": true}
+
+// @Summary Chat
+// @Description Answer the last message of a conversation. Send the whole history, oldest first, ending with a user message.
+// @Tags generation
+// @Accept json
+// @Produce json
+// @Param request body types.ChatRequest true "Conversation so far"
+// @Success 200 {object} types.ChatResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Failure 504 {object} map[string]string
+// @Router /chat [post]
+func (h *Handler) HandleChat(c *gin.Context) {
+	h.metrics.observeRequest(false)
+	var req types.ChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logError("", err, false, logDetails(c))
+		writeError(c, ErrorCodeInvalidRequest, "Invalid request format")
+		return
+	}
+
+	prompt := chatTranscript(req.Messages)
+	if err := validateChat(req.Messages); err != nil {
+		h.logError(prompt, err, false, logDetails(c))
+		writeError(c, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	var trimmed int
+	if h.chatHistoryTokens > 0 {
+		req.Messages, trimmed = trimHistory(req.Messages, h.chatHistoryTokens, h.tokenizer)
+		prompt = chatTranscript(req.Messages)
+	}
+
+	if !h.checkModel(c, req.Model, prompt, false) {
+		return
+	}
+
+	opts := h.generator.EffectiveOptions(llm.Options{
+		Model:       req.Model,
+		Stop:        req.Stop,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxTokens,
+		System:      req.System,
+	})
+	details := logDetails(c)
+	details.Stop = opts.Stop
+	details.Model = h.modelFor(opts)
+	details.TrimmedTurns = trimmed
+
+	if !h.screenPrompt(c, prompt, false, &details) {
+		return
+	}
+
+	model := h.modelFor(opts)
+	h.metrics.promptSizeBytes.WithLabelValues(model).Observe(float64(len(prompt)))
+	trace := &service.DecisionTrace{}
+	transfer := &llm.Transfer{}
+	ctx := llm.WithTransfer(service.WithDecisionTrace(c.Request.Context(), trace), transfer)
+	generationStart := time.Now()
+	result, err := h.generator.Chat(ctx, req.Messages, opts)
+	details.Duration = time.Since(generationStart)
+	h.metrics.observeGeneration(model, false, details.Duration)
+	details.Decisions = trace.Decisions()
+	details.Source = trace.Source()
+	details.BackendRequestBytes = transfer.RequestBytes()
+	details.BackendResponseBytes = transfer.ResponseBytes()
+	if err != nil {
+		h.logError(prompt, err, false, details)
+		abortWithError(c, generationFailure(c.Request.Context(), err))
+		return
+	}
+
+	h.metrics.observeResponse(model, len(result.Response), h.tokenizer.CountTokens(result.Response))
+	details.Usage = result.Usage
+	usage := h.usage(prompt, result)
+	h.consumeTokens(c, usage.PromptTokens+usage.CompletionTokens)
+	h.publish(prompt, result.Response, model, false, details)
+	h.logger.LogInteraction(prompt, result.Response, false, details)
+
+	c.JSON(200, types.ChatResponse{Message: types.Message{Role: "assistant", Content: result.Response}})
+}
+
+// validateChat checks the roles of a conversation and that it ends with
+// the user's turn
+func validateChat(messages []types.Message) error {
+	if len(messages) == 0 {
+		return fmt.Errorf("messages cannot be empty")
+	}
+	for _, message := range messages {
+		if !chatRoles[message.Role] {
+			return fmt.Errorf("unknown message role %q (available: system, user, assistant)", message.Role)
+		}
+	}
+	if last := messages[len(messages)-1]; last.Role != "user" || last.Content == "" {
+		return fmt.Errorf("the last message must be a non-empty user message")
+	}
+	return nil
+}
+
+// trimHistory drops the oldest messages of a conversation until their
+// content fits budget tokens, returning what's left and how many were
+// dropped. System messages and the final user message are always kept, so
+// a conversation can still end up over budget.
+func trimHistory(messages []types.Message, budget int, tokenizer service.Tokenizer) ([]types.Message, int) {
+	tokens := make([]int, len(messages))
+	total := 0
+	for i, message := range messages {
+		tokens[i] = tokenizer.CountTokens(message.Content)
+		total += tokens[i]
+	}
+
+	kept := make([]types.Message, 0, len(messages))
+	dropped := 0
+	for i, message := range messages {
+		if total > budget && message.Role != "system" && i < len(messages)-1 {
+			total -= tokens[i]
+			dropped++
+			continue
+		}
+		kept = append(kept, message)
+	}
+	return kept, dropped
+}
+
+// chatTranscript renders a conversation as "role: content" lines, which is
+// what gets logged and screened as the prompt
+func chatTranscript(messages []types.Message) string {
+	var b strings.Builder
+	for i, message := range messages {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(message.Role + ": " + message.Content)
+	}
+	return b.String()
+}
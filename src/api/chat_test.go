@@ -0,0 +1,155 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"minivault/src/llm"
+	"minivault/src/service"
+	"minivault/src/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHandleChat_Success(t *testing.T) {
+	handler, mockGen, mockLogger := setupTestHandler()
+
+	messages := []types.Message{
+		{Role: "user", Content: "Hi"},
+		{Role: "assistant", Content: "Hello!"},
+		{Role: "user", Content: "What's the capital of France?"},
+	}
+	transcript := "user: Hi\nassistant: Hello!\nuser: What's the capital of France?"
+	mockGen.On("Chat", mock.Anything, messages, mock.Anything).Return(&llm.Result{Response: "Paris."}, nil)
+	mockLogger.On("LogInteraction", transcript, "Paris.", false, mock.Anything).Return(nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	jsonBody, _ := json.Marshal(types.ChatRequest{Messages: messages})
+	c.Request = httptest.NewRequest("POST", "/chat", bytes.NewBuffer(jsonBody))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	handler.HandleChat(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response types.ChatResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, types.Message{Role: "assistant", Content: "Paris."}, response.Message)
+	mockGen.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
+
+func TestHandleChat_InvalidMessages(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantError string
+	}{
+		{name: "Missing messages", body: `{}`, wantError: "Invalid request format"},
+		{name: "Empty messages", body: `{"messages":[]}`, wantError: "messages cannot be empty"},
+		{name: "Missing role", body: `{"messages":[{"content":"Hi"}]}`, wantError: "Invalid request format"},
+		{name: "Unknown role", body: `{"messages":[{"role":"tool","content":"Hi"}]}`, wantError: `unknown message role "tool" (available: system, user, assistant)`},
+		{name: "Ends with assistant", body: `{"messages":[{"role":"user","content":"Hi"},{"role":"assistant","content":"Hello!"}]}`, wantError: "the last message must be a non-empty user message"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mockGen, mockLogger := setupTestHandler()
+			mockLogger.On("LogError", mock.Anything, mock.Anything, false, mock.Anything).Return(nil)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/chat", bytes.NewBufferString(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler.HandleChat(c)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			var response map[string]string
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.wantError, response["error"])
+			mockGen.AssertNotCalled(t, "Chat", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestHandleChat_Error(t *testing.T) {
+	handler, mockGen, mockLogger := setupTestHandler()
+
+	messages := []types.Message{{Role: "user", Content: "Hi"}}
+	mockGen.On("Chat", mock.Anything, messages, mock.Anything).Return(nil, errors.New("backend down"))
+	mockLogger.On("LogError", "user: Hi", mock.Anything, false, mock.Anything).Return(nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	jsonBody, _ := json.Marshal(types.ChatRequest{Messages: messages})
+	c.Request = httptest.NewRequest("POST", "/chat", bytes.NewBuffer(jsonBody))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	handler.HandleChat(c)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	mockGen.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
+
+func TestHandleChat_TrimsHistory(t *testing.T) {
+	handler, mockGen, mockLogger := setupTestHandler()
+	handler.chatHistoryTokens = 6
+	handler.tokenizer = service.TokenizerFunc(func(text string) int { return len(strings.Fields(text)) })
+
+	messages := []types.Message{
+		{Role: "system", Content: "Be brief"},
+		{Role: "user", Content: "one two three"},
+		{Role: "assistant", Content: "four five"},
+		{Role: "user", Content: "six seven"},
+	}
+	// Dropping the oldest turn brings the 9 words down to 6; the system prompt stays
+	sent := []types.Message{messages[0], messages[2], messages[3]}
+	var details service.LogDetails
+	mockGen.On("Chat", mock.Anything, sent, mock.Anything).Return(&llm.Result{Response: "eight"}, nil)
+	mockLogger.On("LogInteraction", "system: Be brief\nassistant: four five\nuser: six seven", "eight", false, mock.Anything).
+		Run(func(args mock.Arguments) {
+			details = args.Get(3).(service.LogDetails)
+		}).
+		Return(nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	jsonBody, _ := json.Marshal(types.ChatRequest{Messages: messages})
+	c.Request = httptest.NewRequest("POST", "/chat", bytes.NewBuffer(jsonBody))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	handler.HandleChat(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, details.TrimmedTurns)
+	mockGen.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
+
+func TestTrimHistory(t *testing.T) {
+	words := service.TokenizerFunc(func(text string) int { return len(strings.Fields(text)) })
+	messages := []types.Message{
+		{Role: "system", Content: "a b c"},
+		{Role: "user", Content: "d e"},
+		{Role: "assistant", Content: "f"},
+		{Role: "user", Content: "g h i"},
+	}
+
+	kept, dropped := trimHistory(messages, 100, words)
+	assert.Equal(t, messages, kept)
+	assert.Zero(t, dropped)
+
+	// Over budget even when trimmed: the system prompt and final question are kept
+	kept, dropped = trimHistory(messages, 2, words)
+	assert.Equal(t, []types.Message{messages[0], messages[3]}, kept)
+	assert.Equal(t, 2, dropped)
+}
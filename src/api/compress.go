@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultCompressionMinSize is used when COMPRESSION_MIN_SIZE is unset;
+// smaller responses aren't worth the CPU or the gzip header
+const DefaultCompressionMinSize = 1024
+
+// compressor is the part of gzip.Writer and flate.Writer the middleware uses
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Compress gzips, or failing that deflates, responses for clients that
+// accept it. The body is held back until it reaches minSize bytes, so
+// smaller responses go out uncompressed. It's meant for buffered JSON
+// responses; streaming routes shouldn't use it.
+func Compress(minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding, minSize: minSize}
+		c.Writer = w
+		defer w.finish()
+		c.Next()
+	}
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// or "" when the client accepts neither
+func negotiateEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		accepted[name] = true
+	}
+	switch {
+	case accepted["gzip"] || accepted["*"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressWriter buffers the start of a response and compresses it once
+// it grows past minSize
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	minSize  int
+
+	buffer     bytes.Buffer
+	compressor compressor // set once compressing
+	committed  bool       // the response is going out as is
+}
+
+// Write implements io.Writer
+func (w *compressWriter) Write(p []byte) (int, error) {
+	switch {
+	case w.compressor != nil:
+		return w.compressor.Write(p)
+	case w.committed:
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buffer.Write(p)
+	if w.buffer.Len() < w.minSize {
+		return len(p), nil
+	}
+	if !w.compressible() {
+		return len(p), w.commit()
+	}
+	if err := w.startCompressing(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteString implements io.StringWriter, which gin uses for some renders
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what has been written so far. Anything still buffered goes
+// out uncompressed, since the response can no longer be held back.
+func (w *compressWriter) Flush() {
+	if w.compressor != nil {
+		w.compressor.Flush()
+	} else if !w.committed {
+		w.commit()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// compressible reports whether the handler left the encoding to us
+func (w *compressWriter) compressible() bool {
+	status := w.ResponseWriter.Status()
+	return status != http.StatusNoContent && status != http.StatusNotModified &&
+		w.Header().Get("Content-Encoding") == ""
+}
+
+// startCompressing switches to the compressed encoding and writes the
+// buffered start of the body through it
+func (w *compressWriter) startCompressing() error {
+	header := w.Header()
+	header.Set("Content-Encoding", w.encoding)
+	header.Del("Content-Length")
+	if w.encoding == "gzip" {
+		w.compressor = gzip.NewWriter(w.ResponseWriter)
+	} else {
+		w.compressor, _ = flate.NewWriter(w.ResponseWriter, flate.DefaultCompression)
+	}
+	_, err := w.compressor.Write(w.buffer.Bytes())
+	w.buffer.Reset()
+	return err
+}
+
+// commit sends the buffered body uncompressed
+func (w *compressWriter) commit() error {
+	w.committed = true
+	_, err := w.ResponseWriter.Write(w.buffer.Bytes())
+	w.buffer.Reset()
+	return err
+}
+
+// finish completes the response once the handler returns
+func (w *compressWriter) finish() {
+	if w.compressor != nil {
+		w.compressor.Close()
+	} else if !w.committed && w.buffer.Len() > 0 {
+		w.commit()
+	}
+}
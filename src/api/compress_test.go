@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"minivault/src/llm"
+	"minivault/src/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("compressible ", 200)
+	router := gin.New()
+	router.Use(Compress(DefaultCompressionMinSize))
+	router.GET("/large", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"response": large}) })
+	router.GET("/small", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"response": "short"}) })
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{name: "Gzip", path: "/large", acceptEncoding: "gzip, deflate", wantEncoding: "gzip"},
+		{name: "Deflate", path: "/large", acceptEncoding: "deflate", wantEncoding: "deflate"},
+		{name: "Gzip refused", path: "/large", acceptEncoding: "gzip;q=0, deflate", wantEncoding: "deflate"},
+		{name: "Not accepted", path: "/large", acceptEncoding: "br"},
+		{name: "No header", path: "/large"},
+		{name: "Below threshold", path: "/small", acceptEncoding: "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.wantEncoding, w.Header().Get("Content-Encoding"))
+
+			var body io.Reader = w.Body
+			switch tt.wantEncoding {
+			case "gzip":
+				reader, err := gzip.NewReader(w.Body)
+				assert.NoError(t, err)
+				body = reader
+			case "deflate":
+				body = flate.NewReader(w.Body)
+			}
+			var response map[string]string
+			assert.NoError(t, json.NewDecoder(body).Decode(&response))
+			assert.NotEmpty(t, response["response"])
+		})
+	}
+}
+
+func TestCompress_SkipsStreaming(t *testing.T) {
+	handler, mockGen, mockLogger := setupTestHandler()
+	large := strings.Repeat("token ", 500)
+	mockGen.On("Generate", mock.Anything, "test prompt", mock.Anything).Return(&llm.Result{Response: large}, nil)
+	mockGen.On("GenerateStream", mock.Anything, "test prompt", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			io.WriteString(args.Get(3).(io.Writer), large)
+		}).Return(nil)
+	mockLogger.On("LogInteraction", "test prompt", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	router := SetupRouter(handler)
+
+	post := func(path string) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(types.Request{Prompt: "test prompt"})
+		req := httptest.NewRequest("POST", path, bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	generate := post("/generate")
+	assert.Equal(t, http.StatusOK, generate.Code)
+	assert.Equal(t, "gzip", generate.Header().Get("Content-Encoding"))
+	assert.Contains(t, generate.Header().Values("Vary"), "Accept-Encoding")
+
+	stream := post("/generate/stream")
+	assert.Equal(t, http.StatusOK, stream.Code)
+	assert.Empty(t, stream.Header().Get("Content-Encoding"))
+	assert.Contains(t, stream.Body.String(), large)
+}
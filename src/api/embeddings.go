@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"minivault/src/service"
+	"minivault/src/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MaxEmbeddingInputs caps the batch size of one embeddings request
+const MaxEmbeddingInputs = 256
+
+// @Summary Create embeddings
+// @Description Embed a batch of texts with the configured model. The vectors are returned in input order.
+// @Tags generation
+// @Accept json
+// @Produce json
+// @Param request body types.EmbeddingsRequest true "Texts to embed"
+// @Success 200 {object} types.EmbeddingsResponse
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 501 {object} map[string]string
+// @Failure 504 {object} map[string]string
+// @Router /embeddings [post]
+func (h *Handler) HandleEmbeddings(c *gin.Context) {
+	var req types.EmbeddingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.LogError("", err, false, logDetails(c))
+		writeError(c, ErrorCodeInvalidRequest, "Invalid request format")
+		return
+	}
+
+	prompt := strings.Join(req.Input, "\n")
+	if len(req.Input) == 0 || len(req.Input) > MaxEmbeddingInputs {
+		err := fmt.Errorf("input must hold between 1 and %d texts", MaxEmbeddingInputs)
+		h.logger.LogError(prompt, err, false, logDetails(c))
+		writeError(c, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	vectors, err := h.generator.Embeddings(c.Request.Context(), req.Input)
+	if err != nil {
+		details := logDetails(c)
+		details.Model = h.generator.Model()
+		h.logger.LogError(prompt, err, false, details)
+		if errors.Is(err, service.ErrEmbeddingsUnsupported) {
+			writeError(c, ErrorCodeUnsupported, fmt.Sprintf("Embeddings are not supported by the %s backend", h.generator.Backend()))
+			return
+		}
+		failure := generationFailure(c.Request.Context(), err)
+		if failure.Code == ErrorCodeGenerationFailed {
+			failure.Error = "Failed to create embeddings"
+		}
+		abortWithError(c, failure)
+		return
+	}
+
+	h.consumeTokens(c, h.tokenizer.CountTokens(prompt))
+	c.JSON(200, types.EmbeddingsResponse{Embeddings: vectors, Model: h.generator.Model()})
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"minivault/src/service"
+	"minivault/src/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHandleEmbeddings(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		setupMocks func(*MockGenerator, *MockLogger)
+		wantCode   int
+		wantError  string
+	}{
+		{
+			name: "Batch",
+			body: `{"input":["first","second"]}`,
+			setupMocks: func(g *MockGenerator, _ *MockLogger) {
+				g.On("Embeddings", mock.Anything, []string{"first", "second"}).Return([][]float64{{0.1, 0.2}, {0.3, 0.4}}, nil)
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "Empty input",
+			body: `{"input":[]}`,
+			setupMocks: func(_ *MockGenerator, l *MockLogger) {
+				l.On("LogError", "", mock.Anything, false, mock.Anything).Return(nil)
+			},
+			wantCode:  http.StatusBadRequest,
+			wantError: "input must hold between 1 and 256 texts",
+		},
+		{
+			name: "Unsupported backend",
+			body: `{"input":["first"]}`,
+			setupMocks: func(g *MockGenerator, l *MockLogger) {
+				g.On("Embeddings", mock.Anything, []string{"first"}).Return(nil, service.ErrEmbeddingsUnsupported)
+				l.On("LogError", "first", service.ErrEmbeddingsUnsupported, false, mock.Anything).Return(nil)
+			},
+			wantCode:  http.StatusNotImplemented,
+			wantError: "Embeddings are not supported by the ollama backend",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mockGen, mockLogger := setupTestHandler()
+			tt.setupMocks(mockGen, mockLogger)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/embeddings", bytes.NewBufferString(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			handler.HandleEmbeddings(c)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantError != "" {
+				var response map[string]string
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, tt.wantError, response["error"])
+			} else {
+				var response types.EmbeddingsResponse
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, [][]float64{{0.1, 0.2}, {0.3, 0.4}}, response.Embeddings)
+			}
+			mockGen.AssertExpectations(t)
+			mockLogger.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"minivault/src/llm"
+	"minivault/src/service"
+	"minivault/src/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes answered in the code field of error responses. Each always
+// comes with the same HTTP status, listed in errorStatus.
+const (
+	ErrorCodeInvalidRequest        = "invalid_request"
+	ErrorCodeModelNotAllowed       = "model_not_allowed"
+	ErrorCodeContextLengthExceeded = "context_length_exceeded"
+	ErrorCodeUnauthorized          = "unauthorized"
+	ErrorCodeForbidden             = "forbidden"
+	ErrorCodePromptRejected        = "prompt_rejected"
+	ErrorCodeRequestTimeout        = "request_timeout"
+	ErrorCodeQuotaExceeded         = "quota_exceeded"
+	ErrorCodePromptTooLong         = "prompt_too_long"
+	ErrorCodeInternal              = "internal_error"
+	ErrorCodeGenerationFailed      = "generation_failed"
+	ErrorCodeUnsupported           = "unsupported"
+	ErrorCodeBackendUnavailable    = "backend_unavailable"
+	ErrorCodeQueueTimeout          = "queue_timeout"
+	ErrorCodePromptFetchFailed     = "prompt_fetch_failed"
+	ErrorCodeTimeout               = "timeout"
+	ErrorCodeStreamStalled         = "stream_stalled"
+)
+
+var errorStatus = map[string]int{
+	ErrorCodeInvalidRequest:        http.StatusBadRequest,
+	ErrorCodeModelNotAllowed:       http.StatusBadRequest,
+	ErrorCodeContextLengthExceeded: http.StatusBadRequest,
+	ErrorCodeUnauthorized:          http.StatusUnauthorized,
+	ErrorCodeForbidden:             http.StatusForbidden,
+	ErrorCodePromptRejected:        http.StatusForbidden,
+	ErrorCodeRequestTimeout:        http.StatusRequestTimeout,
+	ErrorCodeQuotaExceeded:         http.StatusTooManyRequests,
+	ErrorCodePromptTooLong:         http.StatusRequestEntityTooLarge,
+	ErrorCodeInternal:              http.StatusInternalServerError,
+	ErrorCodeGenerationFailed:      http.StatusInternalServerError,
+	ErrorCodeUnsupported:           http.StatusNotImplemented,
+	ErrorCodeBackendUnavailable:    http.StatusBadGateway,
+	ErrorCodeQueueTimeout:          http.StatusServiceUnavailable,
+	ErrorCodePromptFetchFailed:     http.StatusBadGateway,
+	ErrorCodeTimeout:               http.StatusGatewayTimeout,
+	ErrorCodeStreamStalled:         http.StatusGatewayTimeout,
+}
+
+// errorResponse builds the error response for code
+func errorResponse(code, message string) types.ErrorResponse {
+	return types.ErrorResponse{Error: message, Code: code}
+}
+
+// abortWithError answers with the error response and its code's status
+func abortWithError(c *gin.Context, response types.ErrorResponse) {
+	c.AbortWithStatusJSON(errorStatus[response.Code], response)
+}
+
+// writeError answers with an error response for code
+func writeError(c *gin.Context, code, message string) {
+	abortWithError(c, errorResponse(code, message))
+}
+
+// generationFailure builds the error response for a failed generation:
+// context_length_exceeded with the window size, when known, if the prompt
+// didn't fit; stream_stalled or timeout when the stream stalled or the
+// request deadline passed, since backends don't reliably wrap the context
+// error; backend_unavailable when the backend couldn't be reached; and
+// generation_failed otherwise
+func generationFailure(ctx context.Context, err error) types.ErrorResponse {
+	var contextErr *llm.ContextLengthError
+	if errors.As(err, &contextErr) {
+		response := errorResponse(ErrorCodeContextLengthExceeded, contextErr.Error())
+		if contextErr.Limit > 0 {
+			response.Details = map[string]any{"limit": contextErr.Limit}
+		}
+		return response
+	}
+	if errors.Is(err, service.ErrStreamStalled) {
+		return errorResponse(ErrorCodeStreamStalled, "Generation stalled")
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errorResponse(ErrorCodeTimeout, "Generation timed out")
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return errorResponse(ErrorCodeBackendUnavailable, "LLM backend is unavailable")
+	}
+	return errorResponse(ErrorCodeGenerationFailed, "Failed to generate response")
+}
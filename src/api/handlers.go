@@ -1,93 +1,611 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"minivault/src/llm"
 	"minivault/src/service"
 	"minivault/src/types"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
 )
 
 // Handler handles HTTP requests
 type Handler struct {
 	generator service.Generator
 	logger    service.Logger
+
+	// streamBufferThreshold is the largest stream, in bytes, sent with
+	// Content-Length when a client asks for buffering via X-Stream-Buffer
+	streamBufferThreshold int
+
+	// Optional prompt injection screening; nil when disabled
+	injection     *service.InjectionDetector
+	injectionMode string
+
+	// Optional prompt_url support; nil when no hosts are allowed
+	promptFetcher *service.PromptFetcher
+
+	// Optional broker that completed interactions are published to
+	publisher service.Publisher
+
+	// Models a request may select instead of the default
+	allowedModels map[string]bool
+
+	// Prompt size limits, from MAX_PROMPT_LENGTH and MAX_PROMPT_TOKENS; 0 is unlimited
+	maxPromptLength int // characters
+	maxPromptTokens int // tokens, counted with the tokenizer below
+
+	// Token budget for a chat's history, from CHAT_HISTORY_TOKENS; 0 keeps it all
+	chatHistoryTokens int
+
+	// Tokens each API key may use per minute, from TOKENS_PER_MINUTE; nil is unlimited
+	quota *TokenQuota
+
+	// Generations run at once, from MAX_CONCURRENT_GENERATIONS, and the
+	// order waiting requests get a slot in; a nil scheduler is unlimited
+	scheduler  *Scheduler
+	priorities *Priorities
+
+	// Background backend checks reported by /health/detailed, if main started them
+	healthPoller *service.HealthPoller
+
+	// Longest a request may run once it has a slot, from GENERATION_TIMEOUT; 0 is unlimited
+	generationTimeout time.Duration
+
+	// Most batch prompts generated at once, from BATCH_CONCURRENCY
+	batchConcurrency int
+
+	// Deadlines for one-piece responses and for each batch prompt, from
+	// REQUEST_TIMEOUT, MODEL_TIMEOUT_MULTIPLIERS and the ADAPTIVE_TIMEOUT_*
+	// settings
+	timeouts *Timeouts
+
+	// Reconnection delay suggested to SSE clients, from SSE_RETRY
+	sseRetry time.Duration
+
+	// Prometheus collectors, and the tokenizer behind the token histogram
+	metrics   *Metrics
+	tokenizer service.Tokenizer
+
+	// Build metadata served by /version
+	build BuildInfo
 }
 
+const (
+	// DefaultStreamBufferThreshold is used when STREAM_BUFFER_THRESHOLD is unset
+	DefaultStreamBufferThreshold = 4096
+
+	// DefaultPromptURLMaxBytes and DefaultPromptURLTimeout bound prompt_url fetches
+	DefaultPromptURLMaxBytes = 1 << 20
+	DefaultPromptURLTimeout  = 10 * time.Second
+
+	// DefaultLogsLimit and MaxLogsLimit bound the entries /logs returns
+	DefaultLogsLimit = 50
+	MaxLogsLimit     = 1000
+)
+
 // NewHandler creates a new Handler instance
 func NewHandler(generator service.Generator, logger service.Logger) *Handler {
-	return &Handler{
-		generator: generator,
-		logger:    logger,
+	h := &Handler{
+		generator:             generator,
+		logger:                logger,
+		streamBufferThreshold: getEnvInt("STREAM_BUFFER_THRESHOLD", DefaultStreamBufferThreshold),
+		maxPromptLength:       getEnvInt("MAX_PROMPT_LENGTH", 0),
+		maxPromptTokens:       getEnvInt("MAX_PROMPT_TOKENS", 0),
+		chatHistoryTokens:     getEnvInt("CHAT_HISTORY_TOKENS", 0),
+		batchConcurrency:      getEnvInt("BATCH_CONCURRENCY", DefaultBatchConcurrency),
+		timeouts: &Timeouts{
+			Base:         getEnvDuration("REQUEST_TIMEOUT", DefaultRequestTimeout),
+			Multipliers:  parseTimeoutMultipliers(os.Getenv("MODEL_TIMEOUT_MULTIPLIERS")),
+			DefaultModel: generator.Model,
+			PerInFlight:  getEnvFloat("ADAPTIVE_TIMEOUT_PER_REQUEST", 0),
+			MaxAdaptive:  getEnvDuration("ADAPTIVE_TIMEOUT_MAX", 0),
+		},
+		sseRetry:  getEnvDuration("SSE_RETRY", service.DefaultSSERetry),
+		metrics:   defaultMetrics,
+		tokenizer: service.TokenizerFromEnv(),
+		build:     DefaultBuildInfo,
+	}
+
+	if mode := os.Getenv("INJECTION_DETECTION"); mode != "" {
+		detector, err := loadInjectionDetector()
+		if err != nil {
+			log.Printf("Prompt injection detection disabled: %v", err)
+		} else {
+			h.injection = detector
+			h.injectionMode = mode
+		}
+	}
+
+	if limit := getEnvInt("TOKENS_PER_MINUTE", 0); limit > 0 {
+		h.quota = NewTokenQuota(limit, time.Minute)
+	}
+
+	if limit := getEnvInt("MAX_CONCURRENT_GENERATIONS", 0); limit > 0 {
+		h.scheduler = NewScheduler(limit)
+		h.scheduler.MaxWait = getEnvDuration("QUEUE_WAIT_TIMEOUT", 0)
+	}
+	h.generationTimeout = getEnvDuration("GENERATION_TIMEOUT", 0)
+	tiers := parsePriorityTiers(os.Getenv("PRIORITY_TIERS"))
+	h.priorities = &Priorities{
+		Tiers:       tiers,
+		KeyTiers:    parseAPIKeyTiers(os.Getenv("API_KEY_TIERS"), tiers),
+		BypassTiers: parseBypassTiers(os.Getenv("QUEUE_BYPASS_TIERS"), tiers),
+	}
+
+	if hosts := os.Getenv("PROMPT_URL_HOSTS"); hosts != "" {
+		h.promptFetcher = service.NewPromptFetcher(
+			strings.Split(hosts, ","),
+			int64(getEnvInt("PROMPT_URL_MAX_BYTES", DefaultPromptURLMaxBytes)),
+			getEnvDuration("PROMPT_URL_TIMEOUT", DefaultPromptURLTimeout),
+		)
+	}
+
+	if models := os.Getenv("MODEL_ALLOWLIST"); models != "" {
+		h.allowedModels = make(map[string]bool)
+		for _, model := range strings.Split(models, ",") {
+			if model = strings.TrimSpace(model); model != "" {
+				h.allowedModels[model] = true
+			}
+		}
+	}
+
+	if url := os.Getenv("NATS_URL"); url != "" {
+		publisher, err := service.NewNATSPublisher(url, getEnv("NATS_SUBJECT", service.DefaultNATSSubject))
+		if err != nil {
+			log.Printf("Interaction publishing disabled: %v", err)
+		} else {
+			h.publisher = publisher
+		}
+	}
+
+	return h
+}
+
+// logError logs a failed generation request and counts it in the metrics
+func (h *Handler) logError(prompt string, err error, streaming bool, details service.LogDetails) {
+	h.metrics.observeError(streaming)
+	h.logger.LogError(prompt, err, streaming, details)
+}
+
+// checkModel rejects a per-request model that isn't the server default or
+// on MODEL_ALLOWLIST with 400. It returns false when a response has already
+// been written.
+func (h *Handler) checkModel(c *gin.Context, model, prompt string, streaming bool) bool {
+	err := h.modelNotAllowed(model)
+	if err == nil {
+		return true
+	}
+	h.logError(prompt, err, streaming, logDetails(c))
+	writeError(c, ErrorCodeModelNotAllowed, err.Error())
+	return false
+}
+
+// modelNotAllowed reports a per-request model that isn't the server
+// default or on MODEL_ALLOWLIST
+func (h *Handler) modelNotAllowed(model string) error {
+	if model == "" || model == h.generator.Model() || h.allowedModels[model] {
+		return nil
+	}
+	return fmt.Errorf("model %q is not allowed", model)
+}
+
+// promptTooLong reports a prompt over MAX_PROMPT_LENGTH characters or
+// MAX_PROMPT_TOKENS tokens
+func (h *Handler) promptTooLong(prompt string) error {
+	if h.maxPromptLength > 0 {
+		if length := utf8.RuneCountInString(prompt); length > h.maxPromptLength {
+			return fmt.Errorf("prompt is %d characters, over the limit of %d", length, h.maxPromptLength)
+		}
+	}
+	if h.maxPromptTokens > 0 {
+		if tokens := h.tokenizer.CountTokens(prompt); tokens > h.maxPromptTokens {
+			return fmt.Errorf("prompt is about %d tokens, over the limit of %d", tokens, h.maxPromptTokens)
+		}
+	}
+	return nil
+}
+
+// checkPrompt runs the checks every generated prompt goes through, over
+// HTTP and WebSocket alike: it must be non-empty and within the length
+// limits, its model must be allowed, and the injection detector screens it,
+// flagging details when it looks suspicious. When the prompt is rejected it
+// returns the error to log and the response to send.
+func (h *Handler) checkPrompt(prompt, model string, details *service.LogDetails) (types.ErrorResponse, error) {
+	if prompt == "" { // a prompt_url can resolve to an empty prompt
+		err := fmt.Errorf("prompt cannot be empty")
+		return errorResponse(ErrorCodeInvalidRequest, err.Error()), err
+	}
+	if err := h.promptTooLong(prompt); err != nil {
+		return errorResponse(ErrorCodePromptTooLong, err.Error()), err
+	}
+	if err := h.modelNotAllowed(model); err != nil {
+		return errorResponse(ErrorCodeModelNotAllowed, err.Error()), err
+	}
+	if err := h.injectionRejected(prompt, details); err != nil {
+		return errorResponse(ErrorCodePromptRejected, "Prompt rejected as a suspected injection"), err
+	}
+	return types.ErrorResponse{}, nil
+}
+
+// checkRequestPrompt answers an HTTP request whose prompt checkPrompt
+// rejects. It returns false when a response has already been written.
+func (h *Handler) checkRequestPrompt(c *gin.Context, prompt, model string, streaming bool, details *service.LogDetails) bool {
+	failure, err := h.checkPrompt(prompt, model, details)
+	if err == nil {
+		return true
+	}
+	h.logError(prompt, err, streaming, *details)
+	abortWithError(c, failure)
+	return false
+}
+
+// modelFor returns the model that serves a request
+func (h *Handler) modelFor(opts llm.Options) string {
+	if opts.Model != "" {
+		return opts.Model
+	}
+	return h.generator.Model()
+}
+
+// publish sends a completed interaction to the broker, if one is
+// configured. It runs in the background so a slow or unavailable broker
+// never delays the response.
+func (h *Handler) publish(prompt, response, model string, streaming bool, details service.LogDetails) {
+	if h.publisher == nil {
+		return
+	}
+	interaction := service.Interaction{
+		Timestamp: time.Now(),
+		Prompt:    prompt,
+		Response:  response,
+		Streaming: streaming,
+		Model:     model,
+		Source:    details.Source,
+		Tags:      details.Tags,
+	}
+	go func() {
+		if err := h.publisher.Publish(interaction); err != nil {
+			log.Printf("failed to publish interaction: %v", err)
+		}
+	}()
+}
+
+// bindRequest decodes a generation request, answering "Malformed JSON"
+// when the body doesn't parse and "prompt is required" when it has neither
+// a prompt nor a prompt_url. It returns false when a response has already
+// been written.
+func (h *Handler) bindRequest(c *gin.Context, req *types.Request, streaming bool) bool {
+	err := c.ShouldBindJSON(req)
+	if err == nil {
+		return true
+	}
+	h.logError(req.Prompt, err, streaming, logDetails(c))
+	var invalid validator.ValidationErrors
+	if errors.As(err, &invalid) {
+		writeError(c, ErrorCodeInvalidRequest, "prompt is required")
+	} else {
+		writeError(c, ErrorCodeInvalidRequest, "Malformed JSON")
 	}
+	return false
+}
+
+// resolvePrompt replaces the request's prompt with the content of its
+// prompt_url, if it has one. Disabled or disallowed hosts are answered with
+// 403. It returns false when a response has already been written.
+func (h *Handler) resolvePrompt(c *gin.Context, req *types.Request, streaming bool) bool {
+	if req.PromptURL == "" {
+		return true
+	}
+	if req.Prompt != "" {
+		err := fmt.Errorf("set either prompt or prompt_url, not both")
+		h.logError(req.Prompt, err, streaming, logDetails(c))
+		writeError(c, ErrorCodeInvalidRequest, err.Error())
+		return false
+	}
+	if h.promptFetcher == nil {
+		h.logError(req.Prompt, service.ErrPromptHostNotAllowed, streaming, logDetails(c))
+		writeError(c, ErrorCodeForbidden, "prompt_url is not enabled")
+		return false
+	}
+
+	prompt, err := h.promptFetcher.Fetch(c.Request.Context(), req.PromptURL)
+	if errors.Is(err, service.ErrPromptHostNotAllowed) {
+		h.logError(req.Prompt, err, streaming, logDetails(c))
+		writeError(c, ErrorCodeForbidden, "prompt_url host is not allowed")
+		return false
+	}
+	if err != nil {
+		h.logError(req.Prompt, err, streaming, logDetails(c))
+		writeError(c, ErrorCodePromptFetchFailed, "Failed to fetch prompt_url")
+		return false
+	}
+	req.Prompt = prompt
+	return true
+}
+
+// loadInjectionDetector builds the detector from INJECTION_PATTERNS_FILE and
+// INJECTION_THRESHOLD, using the default patterns when no file is set
+func loadInjectionDetector() (*service.InjectionDetector, error) {
+	patterns := service.DefaultInjectionPatterns
+	if path := os.Getenv("INJECTION_PATTERNS_FILE"); path != "" {
+		loaded, err := service.LoadInjectionPatterns(path)
+		if err != nil {
+			return nil, err
+		}
+		patterns = loaded
+	}
+	return service.NewInjectionDetector(patterns, getEnvInt("INJECTION_THRESHOLD", 1))
+}
+
+// screenPrompt runs the injection detector over a prompt. Suspicious prompts
+// are flagged in details, and in reject mode answered with 403. It returns
+// false when a response has already been written.
+func (h *Handler) screenPrompt(c *gin.Context, prompt string, streaming bool, details *service.LogDetails) bool {
+	err := h.injectionRejected(prompt, details)
+	if err == nil {
+		return true
+	}
+	h.logError(prompt, err, streaming, *details)
+	writeError(c, ErrorCodePromptRejected, "Prompt rejected as a suspected injection")
+	return false
+}
+
+// injectionRejected runs the injection detector over a prompt, flagging
+// suspicious ones in details, and returns an error for those reject mode
+// turns away
+func (h *Handler) injectionRejected(prompt string, details *service.LogDetails) error {
+	if h.injection == nil || !h.injection.Suspicious(prompt) {
+		return nil
+	}
+	details.InjectionSuspected = true
+	if h.injectionMode == service.InjectionModeReject {
+		return fmt.Errorf("prompt injection suspected")
+	}
+	return nil
+}
+
+// logDetails collects the request-scoped log fields set by middleware
+func logDetails(c *gin.Context) service.LogDetails {
+	var details service.LogDetails
+	if tags, ok := c.Get(logTagsKey); ok {
+		details.Tags = tags.(map[string]string)
+	}
+	details.APIKeyHash = c.GetString(apiKeyHashKey)
+	details.RequestID = c.GetString(requestIDKey)
+	details.Timeout = c.GetDuration(requestTimeoutKey)
+	return details
+}
+
+// requestOptions maps the optional generation settings of a request
+func requestOptions(req types.Request) llm.Options {
+	return llm.Options{
+		Model:       req.Model,
+		Tools:       req.Tools,
+		Stop:        req.Stop,
+		System:      req.System,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxTokens,
+	}
+}
+
+// debugInfo echoes how the server understood a request
+type debugInfo struct {
+	Prompt  string      `json:"prompt"`  // effective prompt sent to the backend
+	Model   string      `json:"model"`   // model that serves the request
+	Options llm.Options `json:"options"` // effective generation options
+}
+
+// debugResponse is a generate response with the debug echo attached
+type debugResponse struct {
+	types.Response
+	Debug debugInfo `json:"debug"`
+}
+
+// isTruthy reports whether a query or header value enables a flag
+func isTruthy(value string) bool {
+	enabled, _ := strconv.ParseBool(value)
+	return enabled
 }
 
 // @Summary Generate text
-// @Description Generate text from a prompt
+// @Description Generate text from a prompt. Pass debug=true (or an X-Debug header) to echo the
+// @Description effective prompt, model and options, and dry=true to return the echo without generating.
 // @Tags generation
 // @Accept json
 // @Produce json
 // @Param request body types.Request true "Prompt for text generation"
+// @Param debug query bool false "Include a debug echo of the parsed request"
+// @Param dry query bool false "Return the debug echo without running generation"
+// @Produce application/yaml
+// @Produce text/csv
 // @Success 200 {object} types.Response
 // @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Failure 504 {object} map[string]string
 // @Router /generate [post]
 func (h *Handler) HandleGenerate(c *gin.Context) {
+	h.metrics.observeRequest(false)
 	var req types.Request
-	if err := c.BindJSON(&req); err != nil {
-		h.logger.LogError(req.Prompt, err, false)
-		c.JSON(400, gin.H{"error": "Invalid request format"})
+	if !h.bindRequest(c, &req, false) {
 		return
 	}
 
-	if req.Prompt == "" {
-		err := fmt.Errorf("prompt cannot be empty")
-		h.logger.LogError(req.Prompt, err, false)
-		c.JSON(400, gin.H{"error": err.Error()})
+	if !h.resolvePrompt(c, &req, false) {
+		return
+	}
+
+	if req.OutputFormat != "" && !service.ValidOutputFormat(req.OutputFormat) {
+		err := fmt.Errorf("unsupported output_format %q", req.OutputFormat)
+		h.logError(req.Prompt, err, false, logDetails(c))
+		writeError(c, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+
+	opts := h.generator.EffectiveOptions(requestOptions(req))
+	details := logDetails(c)
+	details.Stop = opts.Stop
+	details.Model = h.modelFor(opts)
+
+	if !h.checkRequestPrompt(c, req.Prompt, req.Model, false, &details) {
+		return
+	}
+
+	dryRun := isTruthy(c.Query("dry"))
+	var debug *debugInfo
+	if dryRun || isTruthy(c.Query("debug")) || isTruthy(c.GetHeader("X-Debug")) {
+		debug = &debugInfo{
+			Prompt:  h.generator.EffectivePrompt(req.Prompt),
+			Model:   h.modelFor(opts),
+			Options: opts,
+		}
+	}
+	if dryRun {
+		c.JSON(200, debugResponse{Debug: *debug})
 		return
 	}
 
 	// Generate response
-	responseText, err := h.generator.Generate(c.Request.Context(), req.Prompt)
+	model := h.modelFor(opts)
+	h.metrics.promptSizeBytes.WithLabelValues(model).Observe(float64(len(req.Prompt)))
+	trace := &service.DecisionTrace{}
+	transfer := &llm.Transfer{}
+	ctx := llm.WithTransfer(service.WithDecisionTrace(c.Request.Context(), trace), transfer)
+	generationStart := time.Now()
+	result, err := h.generator.Generate(ctx, req.Prompt, opts)
+	details.Duration = time.Since(generationStart)
+	h.metrics.observeGeneration(model, false, details.Duration)
+	details.Decisions = trace.Decisions()
+	details.Source = trace.Source()
+	details.Cache = trace.Cache()
+	details.BackendRequestBytes = transfer.RequestBytes()
+	details.BackendResponseBytes = transfer.ResponseBytes()
 	if err != nil {
-		h.logger.LogError(req.Prompt, err, false)
-		c.JSON(500, gin.H{"error": "Failed to generate response"})
+		h.logError(req.Prompt, err, false, details)
+		abortWithError(c, generationFailure(c.Request.Context(), err))
 		return
 	}
 
+	h.metrics.observeResponse(model, len(result.Response), h.tokenizer.CountTokens(result.Response))
+	details.CollapsedLines = result.CollapsedLines
+	details.Usage = result.Usage
+	if result.TimedOut {
+		details.FinishReason = service.FinishReasonTimeout
+	}
+
+	response := types.Response{
+		Response:      result.Response,
+		ToolCalls:     result.ToolCalls,
+		EncodingIssue: result.EncodingIssue,
+		EmptyResponse: result.EmptyResponse,
+		JSONUnwrapped: result.JSONUnwrapped,
+		FinishReason:  details.FinishReason,
+	}
+	usage := h.usage(req.Prompt, result)
+	response.PromptTokens = usage.PromptTokens
+	response.ResponseTokens = usage.CompletionTokens
+	response.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	h.consumeTokens(c, response.TotalTokens)
+	if req.IncludeRaw {
+		response.RawResponse = &result.RawResponse
+	}
+
+	h.publish(req.Prompt, result.Response, model, false, details)
+
 	// Log the interaction
-	if err := h.logger.LogInteraction(req.Prompt, responseText, false); err != nil {
+	if err := h.logger.LogInteraction(req.Prompt, result.Response, false, details); err != nil {
 		// Don't fail the request if logging fails
-		c.JSON(200, types.Response{Response: responseText})
+		h.respond(c, response, req.OutputFormat, debug)
 		return
 	}
 
 	// Return response
-	c.JSON(200, types.Response{Response: responseText})
+	h.respond(c, response, req.OutputFormat, debug)
+}
+
+// usage returns the token usage the backend reported for result, or else
+// counts the prompt as sent and the response with the tokenizer
+func (h *Handler) usage(prompt string, result *llm.Result) llm.Usage {
+	if result.Usage != nil {
+		return *result.Usage
+	}
+	return llm.Usage{
+		PromptTokens:     h.tokenizer.CountTokens(h.generator.EffectivePrompt(prompt)),
+		CompletionTokens: h.tokenizer.CountTokens(result.Response),
+	}
+}
+
+// respond writes a generate response, attaching the debug echo when requested.
+// With an output format the converted text is sent as the body with its own
+// Content-Type; responses that can't be converted are sent as usual, flagged.
+func (h *Handler) respond(c *gin.Context, response types.Response, format string, debug *debugInfo) {
+	if format != "" {
+		converted, contentType, err := service.ConvertOutput(response.Response, format)
+		if err != nil {
+			response.FormatIssue = true
+		} else if debug == nil {
+			c.Data(200, contentType, []byte(converted))
+			return
+		} else {
+			response.Response = converted
+		}
+	}
+
+	if debug != nil {
+		c.JSON(200, debugResponse{Response: response, Debug: *debug})
+		return
+	}
+	c.JSON(200, response)
 }
 
 // @Summary Generate text with streaming
-// @Description Generate text from a prompt with streaming response
+// @Description Generate text from a prompt with streaming response. Send Accept: text/event-stream to receive Server-Sent Events instead of newline-delimited JSON.
 // @Tags generation
 // @Accept json
 // @Produce json
+// @Produce text/event-stream
 // @Param request body types.Request true "Prompt for text generation"
+// @Param X-Stream-Buffer header bool false "Send short responses with Content-Length instead of chunked"
+// @Param offsets query bool false "Include each token's byte offset in the response text"
 // @Success 200 {string} string "Streamed response as newline-delimited JSON"
 // @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Failure 504 {object} map[string]string
 // @Router /generate/stream [post]
 func (h *Handler) HandleGenerateStream(c *gin.Context) {
+	h.metrics.observeRequest(true)
+	start := time.Now()
 	var req types.Request
-	if err := c.BindJSON(&req); err != nil {
-		h.logger.LogError(req.Prompt, err, true)
-		c.JSON(400, gin.H{"error": "Invalid request format"})
+	if !h.bindRequest(c, &req, true) {
 		return
 	}
 
-	if req.Prompt == "" {
-		err := fmt.Errorf("prompt cannot be empty")
-		h.logger.LogError(req.Prompt, err, true)
-		c.JSON(400, gin.H{"error": err.Error()})
+	if !h.resolvePrompt(c, &req, true) {
+		return
+	}
+
+	opts := h.generator.EffectiveOptions(requestOptions(req))
+	details := logDetails(c)
+	details.Stop = opts.Stop
+	details.Model = h.modelFor(opts)
+
+	if !h.checkRequestPrompt(c, req.Prompt, req.Model, true, &details) {
 		return
 	}
 
@@ -95,23 +613,359 @@ func (h *Handler) HandleGenerateStream(c *gin.Context) {
 	fullResponse := make(chan string, 1)
 	responseBuilder := ""
 
-	// Create chunked writer
-	writer := service.NewChunkedWriter(c.Writer, func(text string) {
+	// Create chunked writer, timing the first token
+	onWrite := func(text string) {
+		if details.TTFT == 0 {
+			details.TTFT = time.Since(start)
+		}
 		responseBuilder += text
-	})
+	}
+	newWriter := service.NewChunkedWriter
+	sse := acceptsEventStream(c)
+	if sse {
+		newWriter = service.NewSSEWriter
+	}
+	writer := newWriter(c.Writer, onWrite)
+	if sse {
+		writer.SetRetry(h.sseRetry)
+	}
+	details.StreamDowngraded = writer.Downgraded()
+	if isTruthy(c.GetHeader("X-Stream-Buffer")) && !writer.Downgraded() {
+		writer.BufferUpTo(h.streamBufferThreshold)
+	}
+	if isTruthy(c.Query("offsets")) {
+		writer.IncludeOffsets()
+	}
 
 	// Stream the response
-	if err := h.generator.GenerateStream(c.Request.Context(), req.Prompt, writer); err != nil {
-		h.logger.LogError(req.Prompt, err, true)
-		c.JSON(500, gin.H{"error": "Failed to generate response"})
+	model := h.modelFor(opts)
+	h.metrics.promptSizeBytes.WithLabelValues(model).Observe(float64(len(req.Prompt)))
+	trace := &service.DecisionTrace{}
+	if lastID, err := strconv.ParseInt(c.GetHeader("Last-Event-ID"), 10, 64); sse && err == nil && lastID > 0 {
+		writer.ResumeAfter(lastID, trace)
+	}
+	transfer := &llm.Transfer{}
+	usage := &llm.UsageReport{}
+	ctx := llm.WithUsageReport(llm.WithTransfer(service.WithDecisionTrace(c.Request.Context(), trace), transfer), usage)
+	generationStart := time.Now()
+	err := h.generator.GenerateStream(ctx, req.Prompt, opts, writer)
+	details.Duration = time.Since(generationStart)
+	h.metrics.observeGeneration(model, true, details.Duration)
+	details.Decisions = trace.Decisions()
+	details.Source = trace.Source()
+	details.Cache = trace.Cache()
+	details.BackendRequestBytes = transfer.RequestBytes()
+	details.BackendResponseBytes = transfer.ResponseBytes()
+	details.Usage = usage.Usage()
+	if errors.Is(err, service.ErrBlockedContent) {
+		// Keep what was sent before the match; the marker tells the client why
+		// the stream ended early
+		details.BlockedMidstream = true
+		if writeErr := writer.WriteBlocked(); writeErr != nil {
+			log.Printf("failed to write stream blocked record: %v", writeErr)
+		}
+		if err := writer.Finish(); err != nil {
+			log.Printf("failed to write buffered stream: %v", err)
+		}
+		h.logger.LogInteraction(req.Prompt, responseBuilder, true, details)
+		return
+	}
+	if err != nil {
+		clientGone := errors.Is(c.Request.Context().Err(), context.Canceled)
+		if errors.Is(err, service.ErrStreamStalled) {
+			details.FinishReason = service.FinishReasonStall
+		} else if clientGone {
+			details.FinishReason = service.FinishReasonCancelled
+		}
+		h.logError(req.Prompt, err, true, details)
+		if clientGone {
+			return // nobody left to tell
+		}
+		failure := generationFailure(c.Request.Context(), err)
+		if c.Writer.Written() {
+			// The 200 header and some tokens are already on the wire, so a
+			// JSON error response is no longer possible; signal in-stream
+			if writeErr := writer.WriteError(failure.Code, failure.Error); writeErr != nil {
+				log.Printf("failed to write stream error record: %v", writeErr)
+			}
+			return
+		}
+		// Nothing sent yet: drop the stream headers so the error is a
+		// regular JSON response
+		writer.Discard()
+		abortWithError(c, failure)
 		return
 	}
 
+	h.metrics.observeResponse(model, len(responseBuilder), h.tokenizer.CountTokens(responseBuilder))
+	h.consumeTokens(c, h.tokenizer.CountTokens(req.Prompt)+h.tokenizer.CountTokens(responseBuilder))
+
+	if err := writer.WriteDone(); err != nil {
+		log.Printf("failed to write stream done event: %v", err)
+	}
+	// Send anything still buffered for short responses
+	if err := writer.Finish(); err != nil {
+		log.Printf("failed to write buffered stream: %v", err)
+	}
+
+	h.publish(req.Prompt, responseBuilder, model, true, details)
+
 	// Log the complete interaction
-	if err := h.logger.LogInteraction(req.Prompt, responseBuilder, true); err != nil {
+	if err := h.logger.LogInteraction(req.Prompt, responseBuilder, true, details); err != nil {
 		// Don't fail the request if logging fails
 		return
 	}
 
 	fullResponse <- responseBuilder
 }
+
+// acceptsEventStream reports whether the client asked for Server-Sent Events
+func acceptsEventStream(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+}
+
+// @Summary Recent errors
+// @Description List the most recently logged errors, newest first. Prompts are reported as SHA-256 hashes.
+// @Tags admin
+// @Produce json
+// @Security AdminToken
+// @Success 200 {array} service.RecentError
+// @Failure 401 {object} map[string]string
+// @Router /admin/errors [get]
+func (h *Handler) HandleRecentErrors(c *gin.Context) {
+	c.JSON(200, h.logger.RecentErrors())
+}
+
+// @Summary Recent log entries
+// @Description Read back the most recent entries from the interaction log, newest first. Pass success=false to list only failed requests.
+// @Tags admin
+// @Produce json
+// @Security AdminToken
+// @Param limit query int false "Maximum entries to return (default 50, at most 1000)"
+// @Param success query bool false "Set to false to return only errors"
+// @Success 200 {array} service.LogEntry
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 501 {object} map[string]string
+// @Router /logs [get]
+func (h *Handler) HandleLogs(c *gin.Context) {
+	limit := DefaultLogsLimit
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			writeError(c, ErrorCodeInvalidRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, MaxLogsLimit)
+	}
+	onlyErrors := false
+	if raw := c.Query("success"); raw != "" {
+		success, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeError(c, ErrorCodeInvalidRequest, "success must be true or false")
+			return
+		}
+		onlyErrors = !success
+	}
+
+	entries, err := h.logger.ReadRecent(limit, onlyErrors)
+	if errors.Is(err, service.ErrNoLogFile) {
+		writeError(c, ErrorCodeUnsupported, "Logs are not written to a file (LOG_OUTPUT)")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to read log entries: %v", err)
+		writeError(c, ErrorCodeInternal, "Failed to read logs")
+		return
+	}
+	c.JSON(200, entries)
+}
+
+// @Summary Reload content lists
+// @Description Re-read the stream blocklist file without restarting. Streams already running keep the list they started with.
+// @Tags admin
+// @Produce json
+// @Security AdminToken
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/reload-lists [post]
+func (h *Handler) HandleReloadLists(c *gin.Context) {
+	if err := h.generator.ReloadLists(); err != nil {
+		h.logger.LogError("", fmt.Errorf("failed to reload lists: %v", err), false, logDetails(c))
+		writeError(c, ErrorCodeInternal, "Failed to reload lists")
+		return
+	}
+	c.JSON(200, gin.H{"status": "reloaded"})
+}
+
+// @Summary Rotate the log file
+// @Description Rename the interaction log with a timestamp suffix and continue in a fresh file, e.g. before shipping it elsewhere. Returns the rotated file's name.
+// @Tags admin
+// @Produce json
+// @Security AdminToken
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 501 {object} map[string]string
+// @Router /admin/rotate-logs [post]
+func (h *Handler) HandleRotateLogs(c *gin.Context) {
+	rotated, err := h.logger.Rotate()
+	if errors.Is(err, service.ErrNoLogFile) {
+		writeError(c, ErrorCodeUnsupported, "Logs are not written to a file (LOG_OUTPUT)")
+		return
+	}
+	if err != nil && rotated == "" {
+		log.Printf("failed to rotate log file: %v", err)
+		writeError(c, ErrorCodeInternal, "Failed to rotate logs")
+		return
+	}
+	if err != nil {
+		// The rotation happened; only deleting old files failed
+		log.Printf("failed to prune rotated log files: %v", err)
+	}
+	c.JSON(200, gin.H{"status": "rotated", "file": filepath.Base(rotated)})
+}
+
+// HealthResponse reports whether the server and its backend are usable
+type HealthResponse struct {
+	Status string `json:"status"`           // "ok" or "unavailable"
+	LLM    string `json:"llm"`              // active backend type
+	Failed string `json:"failed,omitempty"` // dependency that failed the check
+	Error  string `json:"error,omitempty"`  // why it failed
+}
+
+// @Summary Health check
+// @Description Report whether the configured LLM backend is reachable, for liveness/readiness probes
+// @Tags health
+// @Produce json
+// @Success 200 {object} HealthResponse
+// @Failure 503 {object} HealthResponse
+// @Router /health [get]
+func (h *Handler) HandleHealth(c *gin.Context) {
+	backend := h.generator.Backend()
+	if err := h.generator.Ping(c.Request.Context()); err != nil {
+		c.JSON(503, HealthResponse{Status: "unavailable", LLM: backend, Failed: backend, Error: err.Error()})
+		return
+	}
+	c.JSON(200, HealthResponse{Status: "ok", LLM: backend})
+}
+
+// DetailedHealthResponse reports each dependency and whether the stub is
+// serving in place of the configured backend
+type DetailedHealthResponse struct {
+	Status       string             `json:"status"`   // "ok", "degraded" (serving the stub fallback) or "unavailable"
+	LLMType      string             `json:"llm_type"` // configured backend type
+	Model        string             `json:"model"`    // configured model
+	Fallback     bool               `json:"fallback"` // whether the stub is serving instead
+	Dependencies []DependencyHealth `json:"dependencies"`
+	Poller       *PollerHealth      `json:"poller,omitempty"` // the background poller's latest check, when HEALTH_POLL_INTERVAL is set
+}
+
+// PollerHealth is the background health poller's latest check
+type PollerHealth struct {
+	Status              string    `json:"status"`               // "ok", "unavailable", or "pending" before the first check
+	CheckedAt           time.Time `json:"checked_at,omitempty"` // when the check finished
+	Error               string    `json:"error,omitempty"`      // why it failed
+	ConsecutiveFailures int       `json:"consecutive_failures"` // failed checks since the last success
+	IntervalMS          int64     `json:"interval_ms"`          // wait before the next check, widened while failing
+}
+
+// SetHealthPoller makes /health/detailed report poller's latest check
+func (h *Handler) SetHealthPoller(poller *service.HealthPoller) {
+	h.healthPoller = poller
+}
+
+// DependencyHealth is the result of checking one dependency
+type DependencyHealth struct {
+	Name      string  `json:"name"`                 // backend type, e.g. "ollama"
+	Status    string  `json:"status"`               // "ok" or "unavailable"
+	LatencyMS float64 `json:"latency_ms,omitempty"` // how long the ping took
+	Error     string  `json:"error,omitempty"`      // why it is unavailable
+}
+
+// @Summary Detailed health check
+// @Description Report each dependency with its ping latency, and whether the stub fallback is serving. Anything but the configured backend serving answers 503, so orchestrators can restart a degraded instance.
+// @Tags health
+// @Produce json
+// @Success 200 {object} DetailedHealthResponse
+// @Failure 503 {object} DetailedHealthResponse
+// @Router /health/detailed [get]
+func (h *Handler) HandleHealthDetailed(c *gin.Context) {
+	primary, model := h.generator.Primary()
+	response := DetailedHealthResponse{Status: "ok", LLMType: primary, Model: model}
+
+	// A backend that couldn't be created was never pinged; the stub took its place
+	if err := h.generator.BackendError(); err != nil {
+		response.Status = "degraded"
+		response.Fallback = true
+		response.Dependencies = append(response.Dependencies, DependencyHealth{Name: primary, Status: "unavailable", Error: err.Error()})
+	}
+
+	start := time.Now()
+	err := h.generator.Ping(c.Request.Context())
+	active := DependencyHealth{Name: h.generator.Backend(), Status: "ok", LatencyMS: float64(time.Since(start)) / float64(time.Millisecond)}
+	if err != nil {
+		response.Status = "unavailable"
+		active.Status = "unavailable"
+		active.Error = err.Error()
+	}
+	response.Dependencies = append(response.Dependencies, active)
+
+	if h.healthPoller != nil {
+		polled := h.healthPoller.Status()
+		response.Poller = &PollerHealth{
+			Status:              "ok",
+			CheckedAt:           polled.Checked,
+			ConsecutiveFailures: polled.ConsecutiveFailures,
+			IntervalMS:          polled.Interval.Milliseconds(),
+		}
+		switch {
+		case polled.Checked.IsZero():
+			response.Poller.Status = "pending"
+		case polled.Err != nil:
+			response.Poller.Status = "unavailable"
+			response.Poller.Error = polled.Err.Error()
+		}
+	}
+
+	status := 200
+	if response.Status != "ok" {
+		status = 503
+	}
+	c.JSON(status, response)
+}
+
+// Model listing statuses reported by /models
+const (
+	ModelsStatusOK          = "ok"
+	ModelsStatusUnsupported = "unsupported" // the backend can't list models, e.g. an old Ollama
+)
+
+// ModelsResponse lists the models available on the backend
+type ModelsResponse struct {
+	Models []llm.ModelInfo `json:"models"`
+	Status string          `json:"status"`
+}
+
+// @Summary List models
+// @Description List the models available on the active LLM backend
+// @Tags models
+// @Produce json
+// @Success 200 {object} ModelsResponse
+// @Failure 502 {object} map[string]string
+// @Router /models [get]
+func (h *Handler) HandleListModels(c *gin.Context) {
+	models, err := h.generator.ListModels(c.Request.Context())
+	if errors.Is(err, llm.ErrModelListingUnsupported) {
+		c.JSON(200, ModelsResponse{Models: []llm.ModelInfo{}, Status: ModelsStatusUnsupported})
+		return
+	}
+	if err != nil {
+		h.logger.LogError("", fmt.Errorf("failed to list models: %v", err), false, logDetails(c))
+		writeError(c, ErrorCodeBackendUnavailable, "Failed to list models")
+		return
+	}
+	c.JSON(200, ModelsResponse{Models: models, Status: ModelsStatusOK})
+}
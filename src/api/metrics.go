@@ -0,0 +1,90 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Size buckets from 64 bytes to 1MiB
+	sizeBuckets = prometheus.ExponentialBuckets(64, 4, 8)
+
+	// Latency buckets from 50ms to about 100s
+	latencyBuckets = prometheus.ExponentialBuckets(0.05, 2, 12)
+
+	// Token buckets from 1 to 16384 tokens
+	tokenBuckets = prometheus.ExponentialBuckets(1, 4, 8)
+)
+
+// Metrics holds the Prometheus collectors the handlers record to
+type Metrics struct {
+	requests          *prometheus.CounterVec   // generation requests by streaming
+	errors            *prometheus.CounterVec   // failed generation requests by streaming
+	promptSizeBytes   *prometheus.HistogramVec // prompt sizes per model
+	responseSizeBytes *prometheus.HistogramVec // response sizes per model
+	generationSeconds *prometheus.HistogramVec // generation latency per model and streaming
+	responseTokens    *prometheus.HistogramVec // response token counts per model
+}
+
+// defaultMetrics is registered with the default registry served on /metrics
+var defaultMetrics = NewMetrics(prometheus.DefaultRegisterer)
+
+// NewMetrics creates the collectors and registers them with reg. Tests
+// pass a fresh prometheus.NewRegistry() so counts start from zero.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "minivault_requests_total",
+			Help: "Generation requests received.",
+		}, []string{"streaming"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "minivault_errors_total",
+			Help: "Generation requests that failed.",
+		}, []string{"streaming"}),
+		promptSizeBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "minivault_prompt_size_bytes",
+			Help:    "Size of generation prompts in bytes.",
+			Buckets: sizeBuckets,
+		}, []string{"model"}),
+		responseSizeBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "minivault_response_size_bytes",
+			Help:    "Size of generated responses in bytes.",
+			Buckets: sizeBuckets,
+		}, []string{"model"}),
+		generationSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "minivault_generation_duration_seconds",
+			Help:    "Time spent generating, including failed generations.",
+			Buckets: latencyBuckets,
+		}, []string{"model", "streaming"}),
+		responseTokens: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "minivault_response_tokens",
+			Help:    "Tokens in generated responses, counted with TOKENIZER.",
+			Buckets: tokenBuckets,
+		}, []string{"model"}),
+	}
+	reg.MustRegister(m.requests, m.errors, m.promptSizeBytes, m.responseSizeBytes, m.generationSeconds, m.responseTokens)
+	return m
+}
+
+// observeRequest counts a generation request
+func (m *Metrics) observeRequest(streaming bool) {
+	m.requests.WithLabelValues(strconv.FormatBool(streaming)).Inc()
+}
+
+// observeError counts a failed generation request
+func (m *Metrics) observeError(streaming bool) {
+	m.errors.WithLabelValues(strconv.FormatBool(streaming)).Inc()
+}
+
+// observeGeneration records how long a generation took
+func (m *Metrics) observeGeneration(model string, streaming bool, duration time.Duration) {
+	m.generationSeconds.WithLabelValues(model, strconv.FormatBool(streaming)).Observe(duration.Seconds())
+}
+
+// observeResponse records the size of a successful response
+func (m *Metrics) observeResponse(model string, size, tokens int) {
+	m.responseSizeBytes.WithLabelValues(model).Observe(float64(size))
+	m.responseTokens.WithLabelValues(model).Observe(float64(tokens))
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"minivault/src/llm"
+	"minivault/src/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// histogramSnapshot returns the sample count and sum of a histogram series
+func histogramSnapshot(t *testing.T, vec *prometheus.HistogramVec, model string) (uint64, float64) {
+	var metric dto.Metric
+	assert.NoError(t, vec.WithLabelValues(model).(prometheus.Histogram).Write(&metric))
+	return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+}
+
+func TestHandleGenerate_SizeHistograms(t *testing.T) {
+	handler, mockGen, mockLogger := setupTestHandler()
+	handler.metrics = NewMetrics(prometheus.NewRegistry())
+
+	// Setup expectations
+	prompt := "how large is this prompt?"
+	mockGen.On("Generate", mock.Anything, prompt, mock.Anything).Return(&llm.Result{Response: "twenty-five bytes"}, nil)
+	mockLogger.On("LogInteraction", prompt, "twenty-five bytes", false, mock.Anything).Return(nil)
+
+	// Create test request
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	jsonBody, _ := json.Marshal(types.Request{Prompt: prompt})
+	c.Request = httptest.NewRequest("POST", "/generate", bytes.NewBuffer(jsonBody))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	// Execute handler
+	handler.HandleGenerate(c)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Each histogram observed exactly this request's sizes
+	count, sum := histogramSnapshot(t, handler.metrics.promptSizeBytes, "test-model")
+	assert.Equal(t, uint64(1), count)
+	assert.Equal(t, float64(len(prompt)), sum)
+
+	count, sum = histogramSnapshot(t, handler.metrics.responseSizeBytes, "test-model")
+	assert.Equal(t, uint64(1), count)
+	assert.Equal(t, float64(len("twenty-five bytes")), sum)
+
+	count, sum = histogramSnapshot(t, handler.metrics.responseTokens, "test-model")
+	assert.Equal(t, uint64(1), count)
+	assert.Equal(t, float64(handler.tokenizer.CountTokens("twenty-five bytes")), sum)
+}
+
+func TestHandlers_RequestCounters(t *testing.T) {
+	handler, mockGen, mockLogger := setupTestHandler()
+	handler.metrics = NewMetrics(prometheus.NewRegistry())
+	mockGen.On("Generate", mock.Anything, "good prompt", mock.Anything).Return(&llm.Result{Response: "ok"}, nil)
+	mockGen.On("Generate", mock.Anything, "bad prompt", mock.Anything).Return(nil, errors.New("backend down"))
+	mockGen.On("GenerateStream", mock.Anything, "good prompt", mock.Anything, mock.Anything).Return(nil)
+	mockLogger.On("LogInteraction", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockLogger.On("LogError", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	serve := func(path, prompt string, handle gin.HandlerFunc) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		jsonBody, _ := json.Marshal(types.Request{Prompt: prompt})
+		c.Request = httptest.NewRequest("POST", path, bytes.NewBuffer(jsonBody))
+		c.Request.Header.Set("Content-Type", "application/json")
+		handle(c)
+	}
+	serve("/generate", "good prompt", handler.HandleGenerate)
+	serve("/generate", "bad prompt", handler.HandleGenerate)
+	serve("/generate", "", handler.HandleGenerate)
+	serve("/generate/stream", "good prompt", handler.HandleGenerateStream)
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(handler.metrics.requests.WithLabelValues("false")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(handler.metrics.requests.WithLabelValues("true")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(handler.metrics.errors.WithLabelValues("false")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(handler.metrics.errors.WithLabelValues("true")))
+
+	// Latency is observed for every generation attempted, failed or not
+	var metric dto.Metric
+	assert.NoError(t, handler.metrics.generationSeconds.WithLabelValues("test-model", "false").(prometheus.Histogram).Write(&metric))
+	assert.Equal(t, uint64(2), metric.GetHistogram().GetSampleCount())
+}
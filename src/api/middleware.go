@@ -0,0 +1,301 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultLogTagPrefix is the header prefix collected into log entry tags
+	DefaultLogTagPrefix = "X-Log-Tag-"
+
+	// DefaultMaxLogTags and DefaultMaxLogTagBytes cap the number and total
+	// size (keys plus values) of caller supplied tags
+	DefaultMaxLogTags     = 16
+	DefaultMaxLogTagBytes = 2048
+
+	// DefaultBodyReadTimeout bounds how long a client may take to send the body
+	DefaultBodyReadTimeout = 30 * time.Second
+
+	// DefaultRequestTimeout bounds how long a request may take once received,
+	// so a hung backend can't hold a handler forever
+	DefaultRequestTimeout = 60 * time.Second
+
+	// DefaultCORSOrigins, DefaultCORSMethods and DefaultCORSHeaders are
+	// permissive for local development; "*" headers allows whatever the
+	// preflight asks for
+	DefaultCORSOrigins = "*"
+	DefaultCORSMethods = "GET, POST, OPTIONS"
+	DefaultCORSHeaders = "*"
+
+	// corsMaxAge is how long, in seconds, browsers may cache a preflight
+	corsMaxAge = "600"
+
+	// logTagsKey is the gin context key holding the collected log tags
+	logTagsKey = "log_tags"
+
+	// apiKeyHashKey is the gin context key holding the caller's hashed API key
+	apiKeyHashKey = "api_key_hash"
+
+	// requestTimeoutKey is the gin context key holding the request's deadline
+	requestTimeoutKey = "request_timeout"
+
+	// RequestIDHeader carries the request ID in both directions
+	RequestIDHeader = "X-Request-ID"
+
+	// requestIDKey is the gin context key holding the request ID
+	requestIDKey = "request_id"
+
+	// maxRequestIDLength bounds caller supplied request IDs
+	maxRequestIDLength = 128
+
+	// maxLogTagLength bounds each tag key and value
+	maxLogTagLength = 128
+)
+
+// LogTags collects headers starting with prefix into a tag map that is
+// attached to the request's log entry. The header name after the prefix is
+// lowercased and used as the tag key, and keys and values are truncated to
+// maxLogTagLength bytes. Requests with more than maxTags tags, or whose tags
+// total more than maxBytes, are rejected with 400 so headers can't bloat
+// the log.
+func LogTags(prefix string, maxTags, maxBytes int) gin.HandlerFunc {
+	lowerPrefix := strings.ToLower(prefix)
+	return func(c *gin.Context) {
+		var tags map[string]string
+		size := 0
+		for name, values := range c.Request.Header {
+			lowerName := strings.ToLower(name)
+			if !strings.HasPrefix(lowerName, lowerPrefix) || len(values) == 0 {
+				continue
+			}
+			key := truncate(strings.TrimPrefix(lowerName, lowerPrefix), maxLogTagLength)
+			if key == "" {
+				continue
+			}
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+			value := truncate(values[0], maxLogTagLength)
+			tags[key] = value
+			size += len(key) + len(value)
+		}
+		if len(tags) > maxTags {
+			writeError(c, ErrorCodeInvalidRequest, fmt.Sprintf("Too many log tags (max %d)", maxTags))
+			return
+		}
+		if size > maxBytes {
+			writeError(c, ErrorCodeInvalidRequest, fmt.Sprintf("Log tags too large (max %d bytes)", maxBytes))
+			return
+		}
+		if tags != nil {
+			c.Set(logTagsKey, tags)
+		}
+		c.Next()
+	}
+}
+
+// BodyReadTimeout reads the request body up front and aborts with 408 if
+// it isn't fully received within timeout, so slow clients can't tie up a
+// handler. This is separate from any generation deadline. A timeout of zero
+// disables the check, as does a writer that isn't backed by a connection.
+func BodyReadTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body := c.Request.Body
+		if timeout <= 0 || body == nil || body == http.NoBody {
+			c.Next()
+			return
+		}
+
+		// The deadline is set on the connection so a stalled read fails at
+		// the deadline, wherever the client stopped sending
+		controller := http.NewResponseController(c.Writer)
+		if err := controller.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			c.Next()
+			return
+		}
+		data, err := io.ReadAll(body)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// The deadline stays, so the server gives up draining the rest
+			// of the body and the 408 goes out now
+			writeError(c, ErrorCodeRequestTimeout, "Request body read timed out")
+			return
+		}
+		if err != nil {
+			writeError(c, ErrorCodeInvalidRequest, "Failed to read request body")
+			return
+		}
+		// Later reads, such as the server noticing the client hang up,
+		// mustn't hit the deadline
+		controller.SetReadDeadline(time.Time{})
+		c.Request.Body = io.NopCloser(bytes.NewReader(data))
+		c.Next()
+	}
+}
+
+// RequestTimeout gives the request context the deadline timeouts sets for
+// the model the request asks for, and records it in the log entry.
+// Handlers pass the context to the backend, so generation is cancelled
+// when the deadline passes and the handler answers 504. It suits routes
+// that answer in one piece; streams are bounded by STREAM_IDLE_TIMEOUT
+// instead, and batches time each prompt.
+func RequestTimeout(timeouts *Timeouts) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := timeouts.For(requestModel(c))
+		if timeout > 0 {
+			c.Set(requestTimeoutKey, timeout)
+		}
+		ctx, cancel := contextWithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// contextWithTimeout is context.WithTimeout, except that a timeout of zero
+// leaves ctx without a deadline
+func contextWithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// AdminAuth requires an "Authorization: Bearer <token>" header matching
+// token, answering 401 otherwise
+func AdminAuth(token string) gin.HandlerFunc {
+	expected := []byte("Bearer " + token)
+	return func(c *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+			writeError(c, ErrorCodeUnauthorized, "Unauthorized")
+			return
+		}
+		c.Next()
+	}
+}
+
+// CORS adds Access-Control-Allow-* headers for the allowed origins, given
+// as a comma-separated list or "*" for any, and answers preflight OPTIONS
+// requests with 204. It runs before the handlers so streamed responses
+// carry the headers on their first chunk.
+func CORS(origins, methods, headers string) gin.HandlerFunc {
+	allowAny := false
+	allowed := make(map[string]bool)
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			allowAny = true
+		} else if origin != "" {
+			allowed[origin] = true
+		}
+	}
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		switch {
+		case allowAny:
+			header.Set("Access-Control-Allow-Origin", "*")
+		case allowed[origin]:
+			header.Set("Access-Control-Allow-Origin", origin)
+			header.Add("Vary", "Origin")
+		default:
+			// Without the headers the browser blocks the response
+			c.Next()
+			return
+		}
+		header.Set("Access-Control-Expose-Headers", RequestIDHeader)
+
+		if c.Request.Method != http.MethodOptions || c.GetHeader("Access-Control-Request-Method") == "" {
+			c.Next()
+			return
+		}
+		header.Set("Access-Control-Allow-Methods", methods)
+		if headers == "*" {
+			// Echo the request so headers the "*" wildcard doesn't cover,
+			// such as Authorization, are allowed too
+			header.Set("Access-Control-Allow-Headers", c.GetHeader("Access-Control-Request-Headers"))
+		} else {
+			header.Set("Access-Control-Allow-Headers", headers)
+		}
+		header.Set("Access-Control-Max-Age", corsMaxAge)
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// APIKeyAuth requires an X-API-Key header matching one of keys, answering
+// 401 otherwise. The SHA-256 of the key used is attached to the request's
+// log entry so calls can be audited without logging the key itself. With
+// no keys every request is rejected; callers leave the middleware out to
+// disable auth.
+func APIKeyAuth(keys []string) gin.HandlerFunc {
+	// Compare hashes so the lookup time doesn't depend on how much of a
+	// guessed key matches
+	allowed := make(map[[sha256.Size]byte]bool, len(keys))
+	for _, key := range keys {
+		allowed[sha256.Sum256([]byte(key))] = true
+	}
+	return func(c *gin.Context) {
+		sum := sha256.Sum256([]byte(c.GetHeader("X-API-Key")))
+		if !allowed[sum] {
+			writeError(c, ErrorCodeUnauthorized, "Missing or invalid API key")
+			return
+		}
+		c.Set(apiKeyHashKey, hex.EncodeToString(sum[:]))
+		c.Next()
+	}
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// RequestID tags each request with the caller's X-Request-ID, or a new UUID
+// when it's missing or unusable, and echoes it in the response so client and
+// server logs can be correlated. The ID becomes the log entry's id.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// validRequestID accepts short IDs of printable ASCII without spaces, so a
+// caller can't inject odd bytes into the logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
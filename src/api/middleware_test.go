@@ -0,0 +1,475 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"minivault/src/llm"
+	"minivault/src/service"
+	"minivault/src/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestLogTags_AttachedToLogEntry(t *testing.T) {
+	handler, mockGen, mockLogger := setupTestHandler()
+
+	// Setup expectations
+	expectedPrompt := "test prompt"
+	mockGen.On("Generate", mock.Anything, expectedPrompt, mock.Anything).Return(&llm.Result{Response: "test response"}, nil)
+	mockLogger.On("LogInteraction", expectedPrompt, "test response", false, mock.MatchedBy(func(details service.LogDetails) bool {
+		return assert.ObjectsAreEqual(map[string]string{"team": "payments", "env": "staging"}, details.Tags)
+	})).Return(nil)
+
+	router := gin.New()
+	router.Use(LogTags(DefaultLogTagPrefix, DefaultMaxLogTags, DefaultMaxLogTagBytes))
+	router.POST("/generate", handler.HandleGenerate)
+
+	// Create test request with tag headers
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(types.Request{Prompt: expectedPrompt})
+	req := httptest.NewRequest("POST", "/generate", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Log-Tag-Team", "payments")
+	req.Header.Set("x-log-tag-env", "staging")
+	req.Header.Set("X-Other", "ignored")
+
+	router.ServeHTTP(w, req)
+
+	// Assert response and logged tags
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockGen.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
+
+func TestLogTags_Truncated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var tags map[string]string
+
+	router := gin.New()
+	router.Use(LogTags(DefaultLogTagPrefix, DefaultMaxLogTags, DefaultMaxLogTagBytes))
+	router.GET("/", func(c *gin.Context) {
+		tags = logDetails(c).Tags
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Log-Tag-Long", strings.Repeat("a", maxLogTagLength*2))
+
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Len(t, tags["long"], maxLogTagLength)
+}
+
+func TestLogTags_Caps(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		tags     int
+		value    string
+		wantCode int
+	}{
+		{name: "Within caps", tags: 4, value: "value", wantCode: http.StatusOK},
+		{name: "Too many tags", tags: 5, value: "value", wantCode: http.StatusBadRequest},
+		{name: "Too large", tags: 2, value: strings.Repeat("a", 60), wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(LogTags(DefaultLogTagPrefix, 4, 100))
+			router.GET("/", func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			for i := 0; i < tt.tags; i++ {
+				req.Header.Set(fmt.Sprintf("X-Log-Tag-Key%d", i), tt.value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func TestBodyReadTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var received string
+	router := gin.New()
+	router.Use(BodyReadTimeout(200 * time.Millisecond))
+	router.POST("/", func(c *gin.Context) {
+		data, _ := io.ReadAll(c.Request.Body)
+		received = string(data)
+		c.Status(http.StatusOK)
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	t.Run("Fast body is passed through", func(t *testing.T) {
+		resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{"prompt":"hi"}`))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, `{"prompt":"hi"}`, received)
+	})
+
+	t.Run("Stalled body times out", func(t *testing.T) {
+		received = ""
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		// Promise a longer body than is sent, then stall
+		start := time.Now()
+		fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{\"prompt\":")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusRequestTimeout, resp.StatusCode)
+		assert.Less(t, time.Since(start), time.Second, "408 should be sent at the deadline")
+		var response types.ErrorResponse
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+		assert.Equal(t, ErrorCodeRequestTimeout, response.Code)
+		assert.Empty(t, received)
+	})
+}
+
+func TestRequestTimeout(t *testing.T) {
+	handler, mockGen, mockLogger := setupTestHandler()
+
+	// A hung backend only returns once the request context is cancelled
+	mockGen.On("Generate", mock.Anything, "test prompt", mock.Anything).
+		Return(nil, context.DeadlineExceeded).
+		Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		})
+	mockLogger.On("LogError", "test prompt", context.DeadlineExceeded, false, mock.Anything).Return(nil)
+
+	router := gin.New()
+	router.Use(RequestTimeout(&Timeouts{Base: 50 * time.Millisecond}))
+	router.POST("/generate", handler.HandleGenerate)
+
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(types.Request{Prompt: "test prompt"})
+	req := httptest.NewRequest("POST", "/generate", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), "Generation timed out")
+	assert.Less(t, time.Since(start), time.Second)
+	mockGen.AssertExpectations(t)
+	mockLogger.AssertExpectations(t)
+}
+
+func TestRequestTimeout_Routes(t *testing.T) {
+	t.Setenv("REQUEST_TIMEOUT", "50ms")
+	t.Setenv("BATCH_CONCURRENCY", "1")
+	handler, mockGen, mockLogger := setupTestHandler()
+	router := SetupRouter(handler)
+	mockGen.On("Model").Return("test-model")
+	mockLogger.On("LogInteraction", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	t.Run("Streams outlive the request timeout", func(t *testing.T) {
+		mockGen.On("GenerateStream", mock.Anything, "slow stream", mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) {
+				_, hasDeadline := args.Get(0).(context.Context).Deadline()
+				assert.False(t, hasDeadline)
+				args.Get(3).(io.Writer).Write([]byte("Once"))
+				time.Sleep(100 * time.Millisecond)
+				args.Get(3).(io.Writer).Write([]byte(" upon"))
+			}).
+			Return(nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/generate/stream", bytes.NewBufferString(`{"prompt":"slow stream"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "{\"token\":\"Once\"}\n{\"token\":\" upon\"}\n", w.Body.String())
+	})
+
+	t.Run("Batch prompts each get the timeout", func(t *testing.T) {
+		// Run one at a time, the prompts together take longer than one timeout
+		mockGen.On("Generate", mock.Anything, mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) {
+				deadline, hasDeadline := args.Get(0).(context.Context).Deadline()
+				assert.True(t, hasDeadline)
+				assert.LessOrEqual(t, time.Until(deadline), 50*time.Millisecond)
+				time.Sleep(30 * time.Millisecond)
+			}).
+			Return(&llm.Result{Response: "ok"}, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/generate/batch", bytes.NewBufferString(`{"prompts":["one","two","three"]}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response types.BatchResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		for _, result := range response.Results {
+			assert.Equal(t, "ok", result.Response)
+			assert.Empty(t, result.Error)
+		}
+	})
+}
+
+func TestAdminAuth(t *testing.T) {
+	handler, _, mockLogger := setupTestHandler()
+	mockLogger.On("RecentErrors").Return([]service.RecentError{{Error: "boom"}})
+
+	router := gin.New()
+	admin := router.Group("/admin", AdminAuth("secret"))
+	admin.GET("/errors", handler.HandleRecentErrors)
+
+	tests := []struct {
+		name     string
+		header   string
+		wantCode int
+	}{
+		{name: "Missing token", wantCode: http.StatusUnauthorized},
+		{name: "Wrong token", header: "Bearer wrong", wantCode: http.StatusUnauthorized},
+		{name: "Valid token", header: "Bearer secret", wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/errors", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode == http.StatusOK {
+				var recent []service.RecentError
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &recent))
+				assert.Equal(t, "boom", recent[0].Error)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	handler, mockGen, mockLogger := setupTestHandler()
+	mockGen.On("Generate", mock.Anything, "test prompt", mock.Anything).Return(&llm.Result{Response: "test response"}, nil)
+	keyHash := sha256.Sum256([]byte("key-two"))
+	mockLogger.On("LogInteraction", "test prompt", "test response", false, mock.MatchedBy(func(details service.LogDetails) bool {
+		return details.APIKeyHash == hex.EncodeToString(keyHash[:])
+	})).Return(nil)
+
+	router := gin.New()
+	router.Use(APIKeyAuth([]string{"key-one", "key-two"}))
+	router.POST("/generate", handler.HandleGenerate)
+
+	tests := []struct {
+		name     string
+		key      string
+		wantCode int
+	}{
+		{name: "Missing key", wantCode: http.StatusUnauthorized},
+		{name: "Wrong key", key: "key-three", wantCode: http.StatusUnauthorized},
+		{name: "Valid key", key: "key-two", wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(types.Request{Prompt: "test prompt"})
+			req := httptest.NewRequest("POST", "/generate", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+	mockLogger.AssertExpectations(t)
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	keys, enabled := loadAPIKeys()
+	assert.False(t, enabled)
+	assert.Empty(t, keys)
+
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("# ops team\nkey-three\n\n  key-four \n"), 0644))
+	t.Setenv("API_KEYS", "key-one, key-two,")
+	t.Setenv("API_KEYS_FILE", path)
+	keys, enabled = loadAPIKeys()
+	assert.True(t, enabled)
+	assert.Equal(t, []string{"key-one", "key-two", "key-three", "key-four"}, keys)
+
+	// A missing file keeps auth on rather than opening the server up
+	t.Setenv("API_KEYS", "")
+	t.Setenv("API_KEYS_FILE", filepath.Join(t.TempDir(), "missing.txt"))
+	keys, enabled = loadAPIKeys()
+	assert.True(t, enabled)
+	assert.Empty(t, keys)
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+	router := SetupRouter(handler)
+
+	req := httptest.NewRequest("OPTIONS", "/generate", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type,x-api-key")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, DefaultCORSMethods, w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type,x-api-key", w.Header().Get("Access-Control-Allow-Headers"))
+}
+
+func TestCORS_AllowedOrigins(t *testing.T) {
+	router := gin.New()
+	router.Use(CORS("https://app.example.com, https://admin.example.com", "POST", "Content-Type"))
+	router.POST("/generate", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name       string
+		origin     string
+		method     string
+		wantCode   int
+		wantOrigin string
+	}{
+		{name: "Allowed origin", origin: "https://admin.example.com", method: "POST", wantCode: http.StatusOK, wantOrigin: "https://admin.example.com"},
+		{name: "Other origin", origin: "https://evil.example.com", method: "POST", wantCode: http.StatusOK},
+		{name: "Same origin", method: "POST", wantCode: http.StatusOK},
+		{name: "Preflight", origin: "https://app.example.com", method: "OPTIONS", wantCode: http.StatusNoContent, wantOrigin: "https://app.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/generate", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.method == "OPTIONS" {
+				req.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantOrigin, w.Header().Get("Access-Control-Allow-Origin"))
+			if tt.wantOrigin != "" {
+				assert.Equal(t, "Origin", w.Header().Get("Vary"))
+			}
+			if tt.method == "OPTIONS" {
+				assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+			}
+		})
+	}
+}
+
+func TestCORS_Streaming(t *testing.T) {
+	handler, mockGen, mockLogger := setupTestHandler()
+	release := make(chan struct{})
+	mockGen.On("GenerateStream", mock.Anything, "test prompt", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			args.Get(3).(io.Writer).Write([]byte("Hello"))
+			<-release
+		}).
+		Return(nil)
+	mockLogger.On("LogInteraction", "test prompt", "Hello", true, mock.Anything).Return(nil)
+
+	router := gin.New()
+	router.Use(CORS(DefaultCORSOrigins, DefaultCORSMethods, DefaultCORSHeaders))
+	router.POST("/generate/stream", handler.HandleGenerateStream)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	defer close(release)
+
+	body, _ := json.Marshal(types.Request{Prompt: "test prompt"})
+	req, _ := http.NewRequest("POST", server.URL+"/generate/stream", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "http://localhost:3000")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	// The headers arrived with the first chunk, while the stream is still open
+	assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, RequestIDHeader, resp.Header.Get("Access-Control-Expose-Headers"))
+	chunk := make([]byte, 64)
+	n, err := resp.Body.Read(chunk)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"token\":\"Hello\"}\n", string(chunk[:n]))
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		wantEcho bool
+	}{
+		{name: "Caller ID", header: "client-42", wantEcho: true},
+		{name: "Missing", header: ""},
+		{name: "Contains spaces", header: "bad id"},
+		{name: "Too long", header: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mockGen, mockLogger := setupTestHandler()
+			var loggedID string
+			mockGen.On("Generate", mock.Anything, "test prompt", mock.Anything).Return(&llm.Result{Response: "test response"}, nil)
+			mockLogger.On("LogInteraction", "test prompt", "test response", false, mock.Anything).
+				Run(func(args mock.Arguments) { loggedID = args.Get(3).(service.LogDetails).RequestID }).
+				Return(nil)
+
+			router := gin.New()
+			router.Use(RequestID())
+			router.POST("/generate", handler.HandleGenerate)
+
+			w := httptest.NewRecorder()
+			body, _ := json.Marshal(types.Request{Prompt: "test prompt"})
+			req := httptest.NewRequest("POST", "/generate", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			id := w.Header().Get(RequestIDHeader)
+			if tt.wantEcho {
+				assert.Equal(t, tt.header, id)
+			} else {
+				assert.Len(t, id, 36) // a fresh UUID
+			}
+			assert.Equal(t, id, loggedID)
+		})
+	}
+}
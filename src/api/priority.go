@@ -0,0 +1,293 @@
+package api
+
+import (
+	"container/heap"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PriorityHeader lets a caller raise or lower a request's priority, up to
+// its tier's ceiling
+const PriorityHeader = "X-Priority"
+
+// BypassHeader lets callers in a QUEUE_BYPASS_TIERS tier skip the
+// concurrency queue by setting it to true
+const BypassHeader = "X-Max-Priority"
+
+// Tier is the priority an API key's requests get: Default unless the
+// request asks for another, which is capped at Ceiling
+type Tier struct {
+	Default int
+	Ceiling int
+}
+
+// Priorities maps API keys to tiers, from PRIORITY_TIERS and API_KEY_TIERS.
+// Keys without a tier, and requests without a key, get priority 0.
+type Priorities struct {
+	Tiers       map[string]Tier   // by name
+	KeyTiers    map[string]string // tier name by SHA-256 of the API key
+	BypassTiers map[string]bool   // tiers that may skip the queue, from QUEUE_BYPASS_TIERS
+}
+
+// Bypass reports whether a request skips the concurrency queue: it must
+// set X-Max-Priority to true and its key's tier must be allowed to. The
+// header is ignored for everyone else.
+func (p *Priorities) Bypass(c *gin.Context) bool {
+	if p == nil {
+		return false
+	}
+	tier, ok := p.KeyTiers[c.GetString(apiKeyHashKey)]
+	if !ok || !p.BypassTiers[tier] {
+		return false
+	}
+	bypass, _ := strconv.ParseBool(c.GetHeader(BypassHeader))
+	return bypass
+}
+
+// For returns the priority of a request: its key's tier default, or the
+// X-Priority header capped at the tier's ceiling
+func (p *Priorities) For(c *gin.Context) (int, error) {
+	var tier Tier
+	if p != nil {
+		tier = p.Tiers[p.KeyTiers[c.GetString(apiKeyHashKey)]]
+	}
+	raw := c.GetHeader(PriorityHeader)
+	if raw == "" {
+		return tier.Default, nil
+	}
+	priority, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", PriorityHeader)
+	}
+	return min(priority, tier.Ceiling), nil
+}
+
+// parsePriorityTiers parses comma-separated name=default:ceiling entries,
+// e.g. "free=0:1,premium=10:20". Malformed entries, and ceilings below the
+// default, are logged and skipped.
+func parsePriorityTiers(raw string) map[string]Tier {
+	tiers := make(map[string]Tier)
+	for _, entry := range strings.Split(raw, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, levels, ok := strings.Cut(entry, "=")
+		rawDefault, rawCeiling, hasCeiling := strings.Cut(levels, ":")
+		defaultPriority, defaultErr := strconv.Atoi(strings.TrimSpace(rawDefault))
+		ceiling, ceilingErr := strconv.Atoi(strings.TrimSpace(rawCeiling))
+		if !ok || !hasCeiling || defaultErr != nil || ceilingErr != nil || ceiling < defaultPriority || strings.TrimSpace(name) == "" {
+			log.Printf("Ignoring PRIORITY_TIERS entry %q: not name=default:ceiling", entry)
+			continue
+		}
+		tiers[strings.TrimSpace(name)] = Tier{Default: defaultPriority, Ceiling: ceiling}
+	}
+	return tiers
+}
+
+// parseAPIKeyTiers parses comma-separated key=tier pairs into tier names by
+// key hash, matching what APIKeyAuth records. Entries naming an unknown
+// tier are logged and skipped.
+func parseAPIKeyTiers(raw string, tiers map[string]Tier) map[string]string {
+	keyTiers := make(map[string]string)
+	for i, pair := range strings.Split(raw, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, name, _ := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if _, ok := tiers[name]; !ok || strings.TrimSpace(key) == "" {
+			// Don't log the entry itself, it holds a key
+			log.Printf("Ignoring API_KEY_TIERS entry %d: not key=tier with a tier from PRIORITY_TIERS", i+1)
+			continue
+		}
+		sum := sha256.Sum256([]byte(strings.TrimSpace(key)))
+		keyTiers[hex.EncodeToString(sum[:])] = name
+	}
+	return keyTiers
+}
+
+// parseBypassTiers parses a comma-separated list of tier names. Names
+// missing from tiers are logged and skipped.
+func parseBypassTiers(raw string, tiers map[string]Tier) map[string]bool {
+	bypass := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, ok := tiers[name]; !ok {
+			log.Printf("Ignoring QUEUE_BYPASS_TIERS entry %q: not a tier from PRIORITY_TIERS", name)
+			continue
+		}
+		bypass[name] = true
+	}
+	return bypass
+}
+
+// ErrQueueTimeout is returned by Acquire when a request waited
+// QUEUE_WAIT_TIMEOUT without getting a slot
+var ErrQueueTimeout = errors.New("timed out waiting for a generation slot")
+
+// Scheduler caps the generations running at once, from
+// MAX_CONCURRENT_GENERATIONS. Requests beyond the cap wait, and a freed slot
+// goes to the highest priority waiting, oldest first among equals.
+type Scheduler struct {
+	limit int
+
+	// MaxWait is the longest a request may wait for a slot; 0 waits as
+	// long as the request lives
+	MaxWait time.Duration
+
+	mu      sync.Mutex
+	running int
+	waiting waitQueue
+	arrived uint64 // orders waiters of equal priority
+}
+
+// NewScheduler creates a scheduler running at most limit requests at once
+func NewScheduler(limit int) *Scheduler {
+	return &Scheduler{limit: limit}
+}
+
+// Acquire waits for a slot, returning ErrQueueTimeout after MaxWait, or
+// ctx's error if it ends first. Each successful Acquire must be followed by
+// a Release.
+func (s *Scheduler) Acquire(ctx context.Context, priority int) error {
+	s.mu.Lock()
+	if s.running < s.limit && s.waiting.Len() == 0 {
+		s.running++
+		s.mu.Unlock()
+		return nil
+	}
+	if s.MaxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, s.MaxWait, ErrQueueTimeout)
+		defer cancel()
+	}
+	s.arrived++
+	w := &waiter{priority: priority, arrived: s.arrived, ready: make(chan struct{})}
+	heap.Push(&s.waiting, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if w.index < 0 {
+			// Granted a slot while giving up; pass it on
+			s.handOff()
+		} else {
+			heap.Remove(&s.waiting, w.index)
+		}
+		return context.Cause(ctx)
+	}
+}
+
+// Release frees a slot, handing it to the next waiter if there is one
+func (s *Scheduler) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handOff()
+}
+
+// handOff gives a running request's slot to the next waiter, or frees it.
+// The caller must hold s.mu.
+func (s *Scheduler) handOff() {
+	if s.waiting.Len() == 0 {
+		s.running--
+		return
+	}
+	w := heap.Pop(&s.waiting).(*waiter)
+	close(w.ready)
+}
+
+// Waiting returns the number of requests waiting for a slot
+func (s *Scheduler) Waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.waiting.Len()
+}
+
+// waiter is a request waiting for a slot
+type waiter struct {
+	priority int
+	arrived  uint64
+	ready    chan struct{} // closed when the slot is granted
+	index    int           // position in the queue, -1 once popped
+}
+
+// waitQueue is a heap of waiters, highest priority first
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].arrived < q[j].arrived
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue) Pop() any {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
+
+// Schedule runs each request once scheduler grants it a slot, at the
+// priority its API key's tier gives it, answering 503 when it waits longer
+// than the scheduler's MaxWait. Requests priorities lets bypass the queue
+// run straight away without taking a slot. Once running, the request gets
+// generationTimeout (GENERATION_TIMEOUT), so time spent queued doesn't eat
+// into it; 0 adds no deadline. A nil scheduler runs requests straight away.
+func Schedule(scheduler *Scheduler, priorities *Priorities, generationTimeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if scheduler != nil && !priorities.Bypass(c) {
+			priority, err := priorities.For(c)
+			if err != nil {
+				writeError(c, ErrorCodeInvalidRequest, err.Error())
+				return
+			}
+			if err := scheduler.Acquire(c.Request.Context(), priority); err != nil {
+				if errors.Is(err, ErrQueueTimeout) {
+					writeError(c, ErrorCodeQueueTimeout, "Timed out waiting for a generation slot")
+					return
+				}
+				abortWithError(c, generationFailure(c.Request.Context(), err))
+				return
+			}
+			defer scheduler.Release()
+		}
+
+		ctx, cancel := contextWithTimeout(c.Request.Context(), generationTimeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
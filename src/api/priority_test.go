@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePriorityTiers(t *testing.T) {
+	assert.Equal(t, map[string]Tier{"free": {Default: 0, Ceiling: 1}, "premium": {Default: 10, Ceiling: 20}},
+		parsePriorityTiers(" free=0:1, premium = 10:20 ,bad,low=5:1,nocap=3,=1:2,x=a:b"))
+	assert.Empty(t, parsePriorityTiers(""))
+}
+
+func TestParseAPIKeyTiers(t *testing.T) {
+	tiers := map[string]Tier{"premium": {Default: 10, Ceiling: 20}}
+	sum := sha256.Sum256([]byte("secret"))
+	assert.Equal(t, map[string]string{hex.EncodeToString(sum[:]): "premium"},
+		parseAPIKeyTiers("secret=premium, other=unknown,=premium,bare", tiers))
+}
+
+func TestParseBypassTiers(t *testing.T) {
+	tiers := map[string]Tier{"internal": {}, "premium": {}}
+	assert.Equal(t, map[string]bool{"internal": true}, parseBypassTiers(" internal, unknown,,", tiers))
+}
+
+func TestPriorities_For(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	priorities := &Priorities{
+		Tiers:    map[string]Tier{"premium": {Default: 10, Ceiling: 20}},
+		KeyTiers: map[string]string{"premium-hash": "premium"},
+	}
+
+	tests := []struct {
+		name    string
+		keyHash string
+		header  string
+		want    int
+		wantErr bool
+	}{
+		{name: "Tier default", keyHash: "premium-hash", want: 10},
+		{name: "Header within the ceiling", keyHash: "premium-hash", header: "15", want: 15},
+		{name: "Header capped at the ceiling", keyHash: "premium-hash", header: "99", want: 20},
+		{name: "Header may lower priority", keyHash: "premium-hash", header: "-5", want: -5},
+		{name: "Keys without a tier stay at 0", keyHash: "other-hash", header: "5", want: 0},
+		{name: "Malformed header", keyHash: "premium-hash", header: "high", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("POST", "/generate", nil)
+			c.Set(apiKeyHashKey, tt.keyHash)
+			if tt.header != "" {
+				c.Request.Header.Set(PriorityHeader, tt.header)
+			}
+			got, err := priorities.For(c)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSchedule_PremiumFirst(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tiers := map[string]Tier{"free": {Default: 0, Ceiling: 0}, "premium": {Default: 10, Ceiling: 10}}
+	priorities := &Priorities{Tiers: tiers, KeyTiers: parseAPIKeyTiers("free-key=free,premium-key=premium", tiers)}
+	scheduler := NewScheduler(1)
+
+	busy := make(chan struct{})
+	release := make(chan struct{})
+	served := make(chan string, 3)
+	router := gin.New()
+	router.POST("/generate", APIKeyAuth([]string{"busy-key", "free-key", "premium-key"}), Schedule(scheduler, priorities, 0), func(c *gin.Context) {
+		key := c.GetHeader("X-API-Key")
+		if key == "busy-key" {
+			close(busy)
+			<-release
+		}
+		served <- key
+		c.Status(http.StatusOK)
+	})
+	send := func(key string) {
+		req := httptest.NewRequest("POST", "/generate", nil)
+		req.Header.Set("X-API-Key", key)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Occupy the only slot, then queue a free request ahead of a premium one
+	go send("busy-key")
+	<-busy
+	go send("free-key")
+	assert.Eventually(t, func() bool { return scheduler.Waiting() == 1 }, time.Second, time.Millisecond)
+	go send("premium-key")
+	assert.Eventually(t, func() bool { return scheduler.Waiting() == 2 }, time.Second, time.Millisecond)
+
+	close(release)
+	assert.Equal(t, "busy-key", <-served)
+	assert.Equal(t, "premium-key", <-served, "the premium request overtakes the free one")
+	assert.Equal(t, "free-key", <-served)
+}
+
+func TestSchedule_Bypass(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tiers := map[string]Tier{"free": {}, "internal": {}}
+	priorities := &Priorities{
+		Tiers:       tiers,
+		KeyTiers:    parseAPIKeyTiers("free-key=free,internal-key=internal", tiers),
+		BypassTiers: parseBypassTiers("internal", tiers),
+	}
+	scheduler := NewScheduler(1)
+
+	router := gin.New()
+	router.POST("/generate", APIKeyAuth([]string{"free-key", "internal-key"}), Schedule(scheduler, priorities, 0), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	send := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/generate", nil)
+		req.Header.Set("X-API-Key", key)
+		req.Header.Set(BypassHeader, "true")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Hold the only slot
+	assert.NoError(t, scheduler.Acquire(context.Background(), 0))
+
+	// An authorized bypass is served without queueing
+	assert.Equal(t, http.StatusOK, send("internal-key").Code)
+	assert.Zero(t, scheduler.Waiting())
+
+	// while the header is ignored for other tiers, which queue
+	done := make(chan int)
+	go func() { done <- send("free-key").Code }()
+	assert.Eventually(t, func() bool { return scheduler.Waiting() == 1 }, time.Second, time.Millisecond)
+
+	scheduler.Release()
+	assert.Equal(t, http.StatusOK, <-done)
+}
+
+func TestScheduler_Cancel(t *testing.T) {
+	scheduler := NewScheduler(1)
+	assert.NoError(t, scheduler.Acquire(context.Background(), 0))
+
+	// A waiter that gives up leaves the queue
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, scheduler.Acquire(ctx, 5), context.DeadlineExceeded)
+	assert.Zero(t, scheduler.Waiting())
+
+	// and the slot is still passed on when the holder is done
+	scheduler.Release()
+	assert.NoError(t, scheduler.Acquire(context.Background(), 0))
+	scheduler.Release()
+}
+
+func TestSchedule_Timeouts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	scheduler := NewScheduler(1)
+	scheduler.MaxWait = 20 * time.Millisecond
+
+	router := gin.New()
+	router.POST("/generate", Schedule(scheduler, nil, 50*time.Millisecond), func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		abortWithError(c, generationFailure(c.Request.Context(), c.Request.Context().Err()))
+	})
+	send := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/generate", nil))
+		return w
+	}
+
+	t.Run("Timed out while queued", func(t *testing.T) {
+		assert.NoError(t, scheduler.Acquire(context.Background(), 0))
+		defer scheduler.Release()
+
+		w := send()
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), ErrorCodeQueueTimeout)
+		assert.Zero(t, scheduler.Waiting())
+	})
+
+	t.Run("Timed out while running", func(t *testing.T) {
+		// Queue briefly, then check the generation deadline only started
+		// once the slot was handed over
+		assert.NoError(t, scheduler.Acquire(context.Background(), 0))
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- send() }()
+		assert.Eventually(t, func() bool { return scheduler.Waiting() == 1 }, time.Second, time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+		granted := time.Now()
+		scheduler.Release()
+
+		w := <-done
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"timeout"`)
+		assert.GreaterOrEqual(t, time.Since(granted), 50*time.Millisecond)
+	})
+}
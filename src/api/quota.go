@@ -0,0 +1,110 @@
+package api
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenQuotaRemainingHeader reports how many tokens the caller's API key
+// may still use in the current window
+const TokenQuotaRemainingHeader = "X-Token-Quota-Remaining"
+
+// TokenQuota limits how many tokens each API key may use in a sliding
+// window, from TOKENS_PER_MINUTE. Requests are admitted while the key has
+// budget left and charged for the tokens they used once they finish, so the
+// request that crosses the limit completes and the next one is refused.
+type TokenQuota struct {
+	Limit  int           // tokens per window
+	Window time.Duration // how far back usage counts
+
+	now     func() time.Time
+	mu      sync.Mutex
+	charges map[string][]tokenCharge // by API key hash, oldest first
+}
+
+// tokenCharge is the tokens one request used
+type tokenCharge struct {
+	at     time.Time
+	tokens int
+}
+
+// NewTokenQuota creates a quota of limit tokens per window for each key
+func NewTokenQuota(limit int, window time.Duration) *TokenQuota {
+	return &TokenQuota{Limit: limit, Window: window, now: time.Now, charges: make(map[string][]tokenCharge)}
+}
+
+// Remaining returns the tokens key may still use, which is negative once
+// the last request went over
+func (q *TokenQuota) Remaining(key string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.Limit - q.used(key)
+}
+
+// Charge records tokens used by key and returns what's left
+func (q *TokenQuota) Charge(key string, tokens int) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if tokens > 0 {
+		q.charges[key] = append(q.charges[key], tokenCharge{at: q.now(), tokens: tokens})
+	}
+	return q.Limit - q.used(key)
+}
+
+// used drops key's charges that have left the window and sums the rest.
+// The caller must hold q.mu.
+func (q *TokenQuota) used(key string) int {
+	charges := q.charges[key]
+	cutoff := q.now().Add(-q.Window)
+	for len(charges) > 0 && !charges[0].at.After(cutoff) {
+		charges = charges[1:]
+	}
+	if len(charges) == 0 {
+		delete(q.charges, key)
+		return 0
+	}
+	q.charges[key] = charges
+
+	total := 0
+	for _, charge := range charges {
+		total += charge.tokens
+	}
+	return total
+}
+
+// Middleware answers 429 to API keys that have used up their budget and
+// reports the remaining budget to the others. It goes after APIKeyAuth,
+// which identifies the key.
+func (q *TokenQuota) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetString(apiKeyHashKey)
+		if key == "" {
+			c.Next()
+			return
+		}
+		remaining := q.Remaining(key)
+		c.Header(TokenQuotaRemainingHeader, strconv.Itoa(max(remaining, 0)))
+		if remaining <= 0 {
+			writeError(c, ErrorCodeQuotaExceeded, "Token quota exceeded, retry later")
+			return
+		}
+		c.Next()
+	}
+}
+
+// consumeTokens charges tokens to the request's API key, when quotas are
+// on, and updates the remaining budget header if the response hasn't
+// started yet
+func (h *Handler) consumeTokens(c *gin.Context, tokens int) {
+	key := c.GetString(apiKeyHashKey)
+	if h.quota == nil || key == "" {
+		return
+	}
+	remaining := h.quota.Charge(key, tokens)
+	if !c.Writer.Written() {
+		c.Header(TokenQuotaRemainingHeader, strconv.Itoa(max(remaining, 0)))
+	}
+}
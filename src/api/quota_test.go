@@ -0,0 +1,73 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"minivault/src/llm"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTokenQuota_SlidingWindow(t *testing.T) {
+	quota := NewTokenQuota(100, time.Minute)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	quota.now = func() time.Time { return now }
+
+	// Usage accumulates within the window
+	assert.Equal(t, 60, quota.Charge("a", 40))
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, 10, quota.Charge("a", 50))
+	assert.Equal(t, 100, quota.Remaining("b"), "keys have separate budgets")
+
+	// Going over leaves a negative balance until usage slides out
+	assert.Equal(t, -20, quota.Charge("a", 30))
+	now = now.Add(31 * time.Second)
+	assert.Equal(t, 20, quota.Remaining("a"), "the first charge left the window")
+	now = now.Add(time.Minute)
+	assert.Equal(t, 100, quota.Remaining("a"))
+	assert.Empty(t, quota.charges)
+}
+
+func TestTokenQuota_Middleware(t *testing.T) {
+	handler, mockGen, mockLogger := setupTestHandler()
+	handler.quota = NewTokenQuota(100, time.Minute)
+	mockGen.On("Generate", mock.Anything, "test prompt", mock.Anything).
+		Return(&llm.Result{Response: "ok", Usage: &llm.Usage{PromptTokens: 30, CompletionTokens: 30}}, nil)
+	mockLogger.On("LogInteraction", mock.Anything, mock.Anything, false, mock.Anything).Return(nil)
+
+	router := gin.New()
+	router.POST("/generate", APIKeyAuth([]string{"first", "second"}), handler.quota.Middleware(), handler.HandleGenerate)
+	generate := func(key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/generate", bytes.NewBufferString(`{"prompt":"test prompt"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Each request is charged its 60 tokens; the one crossing the limit
+	// still completes, and the next is refused
+	w := generate("first")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "40", w.Header().Get(TokenQuotaRemainingHeader))
+	w = generate("first")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get(TokenQuotaRemainingHeader))
+	w = generate("first")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.JSONEq(t, `{"error":"Token quota exceeded, retry later","code":"quota_exceeded"}`, w.Body.String())
+	assert.Equal(t, "0", w.Header().Get(TokenQuotaRemainingHeader))
+
+	// Another key has its own budget
+	w = generate("second")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "40", w.Header().Get(TokenQuotaRemainingHeader))
+	mockGen.AssertNumberOfCalls(t, "Generate", 3)
+}
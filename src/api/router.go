@@ -1,9 +1,16 @@
 package api
 
 import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
 	_ "minivault/docs" // This is required for swagger
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
@@ -16,12 +23,155 @@ func SetupRouter(handler *Handler) *gin.Engine {
 	// Initialize router
 	router := gin.Default()
 
+	// Middleware
+	router.Use(RequestID())
+	router.Use(CORS(
+		getEnv("CORS_ALLOWED_ORIGINS", DefaultCORSOrigins),
+		getEnv("CORS_ALLOWED_METHODS", DefaultCORSMethods),
+		getEnv("CORS_ALLOWED_HEADERS", DefaultCORSHeaders),
+	))
+	router.Use(LogTags(
+		getEnv("LOG_TAG_PREFIX", DefaultLogTagPrefix),
+		getEnvInt("LOG_TAG_MAX_COUNT", DefaultMaxLogTags),
+		getEnvInt("LOG_TAG_MAX_BYTES", DefaultMaxLogTagBytes),
+	))
+	router.Use(BodyReadTimeout(getEnvDuration("BODY_READ_TIMEOUT", DefaultBodyReadTimeout)))
+
+	// Routes answering in one piece get a deadline. Streams can run as long
+	// as tokens keep coming (STREAM_IDLE_TIMEOUT catches stalls), and
+	// batches give each prompt its own deadline instead.
+	timeout := RequestTimeout(handler.timeouts)
+
+	// Generations are admitted by priority once MAX_CONCURRENT_GENERATIONS
+	// are running, for up to QUEUE_WAIT_TIMEOUT; time spent waiting counts
+	// toward REQUEST_TIMEOUT but not GENERATION_TIMEOUT
+	schedule := Schedule(handler.scheduler, handler.priorities, handler.generationTimeout)
+
+	// Generation routes require an API key when keys are configured
+	generation := router.Group("/")
+	if keys, enabled := loadAPIKeys(); enabled {
+		generation.Use(APIKeyAuth(keys))
+		if handler.quota != nil {
+			generation.Use(handler.quota.Middleware())
+		}
+	} else if handler.quota != nil {
+		log.Printf("Ignoring TOKENS_PER_MINUTE: quotas are per API key and API_KEYS is unset")
+	}
+	generation.Use(handler.timeouts.Track())
+
+	// Buffered JSON responses are compressed; streamed ones are flushed
+	// chunk by chunk and left alone
+	compress := Compress(getEnvInt("COMPRESSION_MIN_SIZE", DefaultCompressionMinSize))
+
 	// Register routes
-	router.POST("/generate", handler.HandleGenerate)
-	router.POST("/generate/stream", handler.HandleGenerateStream)
+	generation.POST("/generate", timeout, schedule, compress, handler.HandleGenerate)
+	generation.POST("/generate/stream", schedule, handler.HandleGenerateStream)
+	generation.POST("/generate/batch", schedule, compress, handler.HandleGenerateBatch)
+	generation.GET("/generate/ws", schedule, handler.HandleGenerateWebSocket)
+	generation.POST("/chat", timeout, schedule, compress, handler.HandleChat)
+	generation.POST("/embeddings", timeout, schedule, compress, handler.HandleEmbeddings)
+	generation.GET("/models", timeout, handler.HandleListModels)
+	router.GET("/health", handler.HandleHealth)
+	router.GET("/health/detailed", handler.HandleHealthDetailed)
+	router.GET("/version", handler.HandleVersion)
+
+	// Admin routes are only served when a token is configured
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		admin := router.Group("/admin", AdminAuth(token))
+		admin.GET("/errors", handler.HandleRecentErrors)
+		admin.POST("/reload-lists", handler.HandleReloadLists)
+		admin.POST("/rotate-logs", handler.HandleRotateLogs)
+		router.GET("/logs", AdminAuth(token), handler.HandleLogs)
+	}
+
+	// Prometheus metrics
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	// Swagger documentation
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	return router
 }
+
+// loadAPIKeys reads the comma-separated API_KEYS and the API_KEYS_FILE of
+// one key per line ("#" comments allowed). Auth is enabled when either is
+// set; an unreadable file leaves it enabled, rejecting keys it would have
+// held rather than opening the server up.
+func loadAPIKeys() ([]string, bool) {
+	raw, path := os.Getenv("API_KEYS"), os.Getenv("API_KEYS_FILE")
+	if raw == "" && path == "" {
+		return nil, false
+	}
+
+	var keys []string
+	for _, key := range strings.Split(raw, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Ignoring API_KEYS_FILE, keys in it will be rejected: %v", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				keys = append(keys, line)
+			}
+		}
+	}
+	return keys, true
+}
+
+// getEnv returns the value of the environment variable or fallback when unset
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvDuration parses a duration environment variable such as "10s",
+// returning fallback when unset or invalid
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %s: %v", key, value, fallback, err)
+		return fallback
+	}
+	return d
+}
+
+// getEnvFloat parses a decimal environment variable such as "0.25",
+// returning fallback when unset or invalid
+func getEnvFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %g: %v", key, value, fallback, err)
+		return fallback
+	}
+	return f
+}
+
+// getEnvInt parses an integer environment variable, returning fallback when
+// unset or invalid
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %d: %v", key, value, fallback, err)
+		return fallback
+	}
+	return n
+}
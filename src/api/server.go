@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultShutdownGracePeriod bounds how long shutdown waits for in-flight
+// requests
+const DefaultShutdownGracePeriod = 30 * time.Second
+
+// Serve runs handler on ln until ctx is cancelled, then stops accepting new
+// connections and waits up to grace for in-flight requests, including
+// streams, to finish before closing the rest. It returns once the server
+// has fully stopped.
+func Serve(ctx context.Context, ln net.Listener, handler http.Handler, grace time.Duration) error {
+	server := &http.Server{Handler: handler}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", grace)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+		return err
+	}
+
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+//go:build !windows
+
+package api
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServe_DrainsOnSignal(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, ln, mux, 5*time.Second)
+	}()
+
+	// Fire a slow request and signal while it's in flight
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		response <- result{body: string(body), err: err}
+	}()
+	<-started
+	assert.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+
+	// The in-flight request completes and the server then stops
+	res := <-response
+	assert.NoError(t, res.err)
+	assert.Equal(t, "done", res.body)
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+
+	// New connections are refused once drained
+	_, err = http.Get("http://" + ln.Addr().String() + "/slow")
+	assert.Error(t, err)
+}
+
+func TestServe_GracePeriodExceeded(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hang", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(2 * time.Second)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, ln, mux, 50*time.Millisecond)
+	}()
+	go http.Get("http://" + ln.Addr().String() + "/hang")
+	<-started
+	cancel()
+
+	// Shutdown gives up after the grace period
+	select {
+	case err := <-served:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown did not respect the grace period")
+	}
+}
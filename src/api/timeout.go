@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Timeouts decides how long a request may run: REQUEST_TIMEOUT, scaled by
+// the MODEL_TIMEOUT_MULTIPLIERS entry of the model it asks for, since
+// larger models legitimately take longer, and stretched while many
+// requests are in flight so a busy server degrades instead of timing
+// everything out at once
+type Timeouts struct {
+	Base        time.Duration      // 0 disables the deadline
+	Multipliers map[string]float64 // by model; models without an entry get Base
+
+	// DefaultModel names the model of requests that don't pick one
+	DefaultModel func() string
+
+	// PerInFlight is the fraction of the deadline added for each other
+	// request in flight, up to MaxAdaptive; 0 keeps the deadline fixed
+	PerInFlight float64
+	MaxAdaptive time.Duration // 0 leaves the growth uncapped
+
+	inFlight atomic.Int64
+}
+
+// For returns the deadline for a request to model, "" meaning the default
+// model, or 0 when there is none
+func (t *Timeouts) For(model string) time.Duration {
+	if t.Base <= 0 {
+		return 0
+	}
+	if model == "" && t.DefaultModel != nil {
+		model = t.DefaultModel()
+	}
+	timeout := t.Base
+	if multiplier, ok := t.Multipliers[model]; ok {
+		timeout = time.Duration(float64(t.Base) * multiplier)
+	}
+
+	others := t.inFlight.Load() - 1
+	if t.PerInFlight <= 0 || others <= 0 {
+		return timeout
+	}
+	adaptive := time.Duration(float64(timeout) * (1 + t.PerInFlight*float64(others)))
+	if t.MaxAdaptive > 0 {
+		// The cap limits the growth; it never cuts the model's own deadline
+		adaptive = min(adaptive, max(t.MaxAdaptive, timeout))
+	}
+	return adaptive
+}
+
+// Track counts requests in flight for the adaptive deadline
+func (t *Timeouts) Track() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t.inFlight.Add(1)
+		defer t.inFlight.Add(-1)
+		c.Next()
+	}
+}
+
+// parseTimeoutMultipliers parses comma-separated model=multiplier pairs,
+// e.g. "llama2:70b=2,mixtral=1.5". Malformed entries and multipliers that
+// aren't positive are logged and skipped.
+func parseTimeoutMultipliers(raw string) map[string]float64 {
+	multipliers := make(map[string]float64)
+	for _, pair := range strings.Split(raw, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		model, value, ok := strings.Cut(pair, "=")
+		multiplier, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if !ok || err != nil || multiplier <= 0 || strings.TrimSpace(model) == "" {
+			log.Printf("Ignoring MODEL_TIMEOUT_MULTIPLIERS entry %q: not model=positive number", pair)
+			continue
+		}
+		multipliers[strings.TrimSpace(model)] = multiplier
+	}
+	return multipliers
+}
+
+// requestModel peeks at the model field of a JSON request body, leaving
+// the body for the handler to read
+func requestModel(c *gin.Context) string {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return ""
+	}
+	data, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return ""
+	}
+	var body struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal(data, &body) // handlers report malformed bodies
+	return body.Model
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeouts_For(t *testing.T) {
+	timeouts := &Timeouts{
+		Base:         time.Minute,
+		Multipliers:  map[string]float64{"llama2:70b": 2, "mixtral": 1.5},
+		DefaultModel: func() string { return "llama2:70b" },
+	}
+
+	assert.Equal(t, 2*time.Minute, timeouts.For("llama2:70b"))
+	assert.Equal(t, 90*time.Second, timeouts.For("mixtral"))
+	assert.Equal(t, time.Minute, timeouts.For("smollm"))
+	assert.Equal(t, 2*time.Minute, timeouts.For(""), "requests without a model use the default's multiplier")
+
+	// Multipliers scale the base, so no base means no deadline
+	timeouts.Base = 0
+	assert.Zero(t, timeouts.For("llama2:70b"))
+}
+
+func TestTimeouts_Adaptive(t *testing.T) {
+	timeouts := &Timeouts{
+		Base:        time.Minute,
+		Multipliers: map[string]float64{"big": 2},
+		PerInFlight: 0.5,
+		MaxAdaptive: 3 * time.Minute,
+	}
+
+	// The deadline grows with the requests in flight, then stops at the cap
+	var got []time.Duration
+	for inFlight := int64(1); inFlight <= 6; inFlight++ {
+		timeouts.inFlight.Store(inFlight)
+		got = append(got, timeouts.For(""))
+	}
+	assert.Equal(t, []time.Duration{
+		time.Minute, 90 * time.Second, 2 * time.Minute, 150 * time.Second, 3 * time.Minute, 3 * time.Minute,
+	}, got)
+
+	// A model's own deadline beyond the cap is kept, but doesn't grow
+	timeouts.MaxAdaptive = 90 * time.Second
+	assert.Equal(t, 2*time.Minute, timeouts.For("big"))
+
+	// Off by default
+	timeouts.PerInFlight = 0
+	assert.Equal(t, time.Minute, timeouts.For(""))
+}
+
+func TestTimeouts_Track(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	timeouts := &Timeouts{Base: time.Minute, PerInFlight: 1}
+
+	// Each request in flight sees the others counted
+	release := make(chan struct{})
+	seen := make(chan time.Duration, 3)
+	router := gin.New()
+	router.Use(timeouts.Track())
+	router.GET("/", func(c *gin.Context) {
+		seen <- timeouts.For("")
+		<-release
+	})
+
+	for i := 0; i < 3; i++ {
+		go router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	var longest time.Duration
+	for i := 0; i < 3; i++ {
+		longest = max(longest, <-seen)
+	}
+	assert.Equal(t, 3*time.Minute, longest, "the last of three concurrent requests gets two extra minutes")
+	close(release)
+
+	assert.Eventually(t, func() bool { return timeouts.inFlight.Load() == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, time.Minute, timeouts.For(""))
+}
+
+func TestParseTimeoutMultipliers(t *testing.T) {
+	assert.Equal(t, map[string]float64{"llama2:70b": 2, "mixtral": 1.5},
+		parseTimeoutMultipliers(" llama2:70b=2, mixtral = 1.5 ,bad,zero=0,=3,nan=x"))
+	assert.Empty(t, parseTimeoutMultipliers(""))
+}
+
+func TestRequestTimeout_ModelMultiplier(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	timeouts := &Timeouts{
+		Base:         100 * time.Millisecond,
+		Multipliers:  map[string]float64{"big": 2},
+		DefaultModel: func() string { return "small" },
+	}
+
+	var remaining, recorded time.Duration
+	var body string
+	router := gin.New()
+	router.Use(RequestTimeout(timeouts))
+	router.POST("/generate", func(c *gin.Context) {
+		deadline, _ := c.Request.Context().Deadline()
+		remaining = time.Until(deadline)
+		data, _ := io.ReadAll(c.Request.Body)
+		body = string(data)
+		recorded = c.GetDuration(requestTimeoutKey)
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name string
+		body string
+		want time.Duration
+	}{
+		{name: "Default model gets the base deadline", body: `{"prompt":"hi"}`, want: 100 * time.Millisecond},
+		{name: "2x model gets double", body: `{"prompt":"hi","model":"big"}`, want: 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/generate", bytes.NewBufferString(tt.body))
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.want, recorded, "the effective timeout goes in the log entry")
+			assert.InDelta(t, tt.want, remaining, float64(20*time.Millisecond))
+			assert.Equal(t, tt.body, body, "the handler still reads the whole body")
+		})
+	}
+}
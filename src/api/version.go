@@ -0,0 +1,36 @@
+package api
+
+import (
+	"runtime"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BuildInfo identifies the running build. main sets it from variables
+// injected with -ldflags.
+type BuildInfo struct {
+	Version   string `json:"version"`    // release version, "dev" for local builds
+	Commit    string `json:"commit"`     // git commit the binary was built from
+	BuildTime string `json:"build_time"` // when the binary was built
+	GoVersion string `json:"go_version"` // Go toolchain that built it
+}
+
+// DefaultBuildInfo is served when main doesn't set the build metadata
+var DefaultBuildInfo = BuildInfo{Version: "dev", Commit: "dev", BuildTime: "dev"}
+
+// SetBuildInfo sets the build metadata /version reports
+func (h *Handler) SetBuildInfo(info BuildInfo) {
+	h.build = info
+}
+
+// @Summary Build version
+// @Description Report the version, git commit, build time and Go version of the running build
+// @Tags health
+// @Produce json
+// @Success 200 {object} BuildInfo
+// @Router /version [get]
+func (h *Handler) HandleVersion(c *gin.Context) {
+	info := h.build
+	info.GoVersion = runtime.Version()
+	c.JSON(200, info)
+}
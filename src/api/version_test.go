@@ -0,0 +1,30 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleVersion(t *testing.T) {
+	handler, _, _ := setupTestHandler()
+	router := SetupRouter(handler)
+
+	get := func() BuildInfo {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/version", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		var info BuildInfo
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+		return info
+	}
+
+	assert.Equal(t, BuildInfo{Version: "dev", Commit: "dev", BuildTime: "dev", GoVersion: runtime.Version()}, get())
+
+	handler.SetBuildInfo(BuildInfo{Version: "1.2.0", Commit: "abc123", BuildTime: "2024-05-01T12:00:00Z"})
+	assert.Equal(t, BuildInfo{Version: "1.2.0", Commit: "abc123", BuildTime: "2024-05-01T12:00:00Z", GoVersion: runtime.Version()}, get())
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"minivault/src/llm"
+	"minivault/src/service"
+	"minivault/src/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// upgrader accepts same-origin WebSocket connections only, gorilla's default
+var upgrader = websocket.Upgrader{}
+
+// wsWriter adapts a WebSocket connection to io.Writer, sending each write
+// as a token frame
+type wsWriter struct {
+	conn    *websocket.Conn
+	onWrite func(string)
+}
+
+// Write implements io.Writer
+func (w *wsWriter) Write(p []byte) (int, error) {
+	if err := w.conn.WriteJSON(service.TokenResponse{Token: string(p)}); err != nil {
+		return 0, err
+	}
+	w.onWrite(string(p))
+	return len(p), nil
+}
+
+// @Summary Generate text over WebSocket
+// @Description Upgrade to a WebSocket, then send one JSON prompt frame (the same body as /generate/stream). The server answers with {"token":...} frames as the model generates and a final {"done":true} frame, or an {"error":...} frame, then closes the connection. Closing the connection early cancels the generation.
+// @Tags generation
+// @Success 101 {string} string "Switching Protocols"
+// @Failure 400 {object} map[string]string
+// @Router /generate/ws [get]
+func (h *Handler) HandleGenerateWebSocket(c *gin.Context) {
+	h.metrics.observeRequest(true)
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already answered with an HTTP error
+		h.logError("", fmt.Errorf("websocket upgrade failed: %v", err), true, logDetails(c))
+		return
+	}
+	defer conn.Close()
+
+	var req types.Request
+	if err := conn.ReadJSON(&req); err != nil {
+		h.logError("", err, true, logDetails(c))
+		closeWebSocket(conn, errorResponse(ErrorCodeInvalidRequest, "Invalid request format"))
+		return
+	}
+	start := time.Now()
+
+	if req.PromptURL != "" {
+		err := fmt.Errorf("prompt_url is not supported over WebSocket")
+		h.logError(req.Prompt, err, true, logDetails(c))
+		closeWebSocket(conn, errorResponse(ErrorCodeInvalidRequest, err.Error()))
+		return
+	}
+
+	opts := h.generator.EffectiveOptions(requestOptions(req))
+	details := logDetails(c)
+	details.Stop = opts.Stop
+	details.Model = h.modelFor(opts)
+
+	if failure, err := h.checkPrompt(req.Prompt, req.Model, &details); err != nil {
+		h.logError(req.Prompt, err, true, details)
+		closeWebSocket(conn, failure)
+		return
+	}
+
+	// The client sends nothing more, so a failed read means it went away.
+	// Reading also answers pings and close frames.
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	clientGone := make(chan struct{})
+	go func() {
+		defer close(clientGone)
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	responseBuilder := ""
+	writer := &wsWriter{conn: conn, onWrite: func(text string) {
+		if details.TTFT == 0 {
+			details.TTFT = time.Since(start)
+		}
+		responseBuilder += text
+	}}
+
+	model := h.modelFor(opts)
+	h.metrics.promptSizeBytes.WithLabelValues(model).Observe(float64(len(req.Prompt)))
+	trace := &service.DecisionTrace{}
+	transfer := &llm.Transfer{}
+	usage := &llm.UsageReport{}
+	ctx = llm.WithUsageReport(llm.WithTransfer(service.WithDecisionTrace(ctx, trace), transfer), usage)
+	generationStart := time.Now()
+	err = h.generator.GenerateStream(ctx, req.Prompt, opts, writer)
+	details.Duration = time.Since(generationStart)
+	h.metrics.observeGeneration(model, true, details.Duration)
+	details.Decisions = trace.Decisions()
+	details.Source = trace.Source()
+	details.Cache = trace.Cache()
+	details.BackendRequestBytes = transfer.RequestBytes()
+	details.BackendResponseBytes = transfer.ResponseBytes()
+	details.Usage = usage.Usage()
+	if errors.Is(err, service.ErrBlockedContent) {
+		details.BlockedMidstream = true
+		closeWebSocket(conn, service.StreamBlockedResponse{Blocked: true, Error: "Response stopped by content filter"})
+		h.logger.LogInteraction(req.Prompt, responseBuilder, true, details)
+		return
+	}
+	if err != nil {
+		gone := false
+		select {
+		case <-clientGone:
+			gone = true
+		default:
+		}
+		if errors.Is(err, service.ErrStreamStalled) {
+			details.FinishReason = service.FinishReasonStall
+		} else if gone {
+			details.FinishReason = service.FinishReasonCancelled
+		}
+		h.logError(req.Prompt, err, true, details)
+		if gone {
+			return // nobody left to tell
+		}
+		closeWebSocket(conn, generationFailure(c.Request.Context(), err))
+		return
+	}
+
+	h.metrics.observeResponse(model, len(responseBuilder), h.tokenizer.CountTokens(responseBuilder))
+	h.consumeTokens(c, h.tokenizer.CountTokens(req.Prompt)+h.tokenizer.CountTokens(responseBuilder))
+	closeWebSocket(conn, service.StreamDoneResponse{Done: true})
+
+	h.publish(req.Prompt, responseBuilder, model, true, details)
+	h.logger.LogInteraction(req.Prompt, responseBuilder, true, details)
+}
+
+// closeWebSocket sends a final JSON frame and a normal close. Errors are
+// only logged: the client may already have gone.
+func closeWebSocket(conn *websocket.Conn, frame interface{}) {
+	if err := conn.WriteJSON(frame); err != nil {
+		log.Printf("failed to write final websocket frame: %v", err)
+		return
+	}
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+}
@@ -0,0 +1,294 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the file form of settings otherwise read from environment
+// variables. Fields left out of the file fall back to the environment.
+type Config struct {
+	Server   ServerConfig   `yaml:"server" json:"server"`
+	LLM      LLMConfig      `yaml:"llm" json:"llm"`
+	Logging  LoggingConfig  `yaml:"logging" json:"logging"`
+	Timeouts TimeoutsConfig `yaml:"timeouts" json:"timeouts"`
+}
+
+// ServerConfig holds the HTTP server settings
+type ServerConfig struct {
+	Port int `yaml:"port" json:"port"` // PORT
+}
+
+// LLMConfig selects and configures the backend
+type LLMConfig struct {
+	Type            string `yaml:"type" json:"type"`                           // LLM_TYPE
+	OllamaHost      string `yaml:"ollama_host" json:"ollama_host"`             // OLLAMA_HOST
+	OllamaModel     string `yaml:"ollama_model" json:"ollama_model"`           // OLLAMA_MODEL
+	OllamaKeepAlive string `yaml:"ollama_keep_alive" json:"ollama_keep_alive"` // OLLAMA_KEEP_ALIVE
+	OllamaNumCtx    int    `yaml:"ollama_num_ctx" json:"ollama_num_ctx"`       // OLLAMA_NUM_CTX
+	OpenAIBaseURL   string `yaml:"openai_base_url" json:"openai_base_url"`     // OPENAI_BASE_URL
+	OpenAIModel     string `yaml:"openai_model" json:"openai_model"`           // OPENAI_MODEL
+	OpenAIAPIKey    string `yaml:"openai_api_key" json:"openai_api_key"`       // OPENAI_API_KEY
+	LlamaCppHost    string `yaml:"llamacpp_host" json:"llamacpp_host"`         // LLAMACPP_HOST
+	LlamaCppModel   string `yaml:"llamacpp_model" json:"llamacpp_model"`       // LLAMACPP_MODEL
+}
+
+// LoggingConfig holds the interaction log settings. The numbers and
+// booleans are pointers so an explicit 0 or false can be told apart from a
+// missing field.
+type LoggingConfig struct {
+	Output         string   `yaml:"output" json:"output"`                   // LOG_OUTPUT
+	Format         string   `yaml:"format" json:"format"`                   // LOG_FORMAT
+	MaxSize        *int64   `yaml:"max_size" json:"max_size"`               // LOG_MAX_SIZE
+	MaxFiles       *int     `yaml:"max_files" json:"max_files"`             // LOG_MAX_FILES
+	Fields         []string `yaml:"fields" json:"fields"`                   // LOG_FIELDS
+	Environment    string   `yaml:"environment" json:"environment"`         // ENVIRONMENT
+	Instance       string   `yaml:"instance" json:"instance"`               // INSTANCE_ID
+	Bodies         *bool    `yaml:"bodies" json:"bodies"`                   // LOG_BODIES
+	Redact         *bool    `yaml:"redact" json:"redact"`                   // LOG_REDACT
+	RedactPatterns []string `yaml:"redact_patterns" json:"redact_patterns"` // LOG_REDACT_PATTERNS
+}
+
+// TimeoutsConfig holds durations such as "30s", validated by Validate
+type TimeoutsConfig struct {
+	Request    string `yaml:"request" json:"request"`         // REQUEST_TIMEOUT
+	BodyRead   string `yaml:"body_read" json:"body_read"`     // BODY_READ_TIMEOUT
+	StreamIdle string `yaml:"stream_idle" json:"stream_idle"` // STREAM_IDLE_TIMEOUT
+	Ollama     string `yaml:"ollama" json:"ollama"`           // OLLAMA_TIMEOUT
+	Shutdown   string `yaml:"shutdown" json:"shutdown"`       // SHUTDOWN_GRACE_PERIOD
+
+	// ModelMultipliers scales the request timeout by model
+	ModelMultipliers map[string]float64 `yaml:"model_multipliers" json:"model_multipliers"` // MODEL_TIMEOUT_MULTIPLIERS
+}
+
+// Load reads a YAML (.yaml, .yml) or JSON (.json) config file and
+// validates it. Unknown fields are rejected so typos don't go unnoticed.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	var cfg Config
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse config: %v", err)
+		}
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format %q (available: .yaml, .yml, .json)", ext)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks every field, returning all problems joined together
+// rather than stopping at the first. Settings the file leaves out are
+// checked against the environment they fall back to where that matters,
+// e.g. the OpenAI settings the openai backend requires.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(field, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Server.Port != 0 && (c.Server.Port < 1 || c.Server.Port > 65535) {
+		invalid("server.port", "%d is not a valid port", c.Server.Port)
+	}
+
+	switch c.LLM.Type {
+	case "", "ollama", "openai", "llamacpp", "stub":
+	default:
+		invalid("llm.type", "unknown type %q (available: ollama, openai, llamacpp, stub)", c.LLM.Type)
+	}
+	if c.LLM.OllamaHost != "" {
+		if _, err := url.Parse(c.LLM.OllamaHost); err != nil {
+			invalid("llm.ollama_host", "%v", err)
+		}
+	}
+	if c.LLM.OllamaKeepAlive != "" {
+		if _, err := time.ParseDuration(c.LLM.OllamaKeepAlive); err != nil {
+			invalid("llm.ollama_keep_alive", "%v", err)
+		}
+	}
+	if c.LLM.OllamaNumCtx < 0 {
+		invalid("llm.ollama_num_ctx", "%d is not a positive context size", c.LLM.OllamaNumCtx)
+	}
+	if c.LLM.OpenAIBaseURL != "" {
+		if u, err := url.Parse(c.LLM.OpenAIBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("llm.openai_base_url", "%q is not an http(s) URL", c.LLM.OpenAIBaseURL)
+		}
+	}
+	if c.LLM.LlamaCppHost != "" {
+		if _, err := url.Parse(c.LLM.LlamaCppHost); err != nil {
+			invalid("llm.llamacpp_host", "%v", err)
+		}
+	}
+	if c.lookup("LLM_TYPE") == "openai" {
+		for field, name := range map[string]string{
+			"llm.openai_base_url": "OPENAI_BASE_URL",
+			"llm.openai_model":    "OPENAI_MODEL",
+			"llm.openai_api_key":  "OPENAI_API_KEY",
+		} {
+			if c.lookup(name) == "" {
+				invalid(field, "required for the openai backend (or set %s)", name)
+			}
+		}
+	}
+
+	switch c.Logging.Output {
+	case "", "file", "stdout", "stderr", "both":
+	default:
+		invalid("logging.output", "%q is not one of file, stdout, stderr or both", c.Logging.Output)
+	}
+	if c.Logging.Format != "" && c.Logging.Format != "jsonl" && c.Logging.Format != "text" {
+		invalid("logging.format", "%q is not jsonl or text", c.Logging.Format)
+	}
+	for i, pattern := range c.Logging.RedactPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			invalid(fmt.Sprintf("logging.redact_patterns[%d]", i), "%v", err)
+		}
+	}
+	if c.Logging.MaxSize != nil && *c.Logging.MaxSize < 0 {
+		invalid("logging.max_size", "must not be negative")
+	}
+	if c.Logging.MaxFiles != nil && *c.Logging.MaxFiles < 0 {
+		invalid("logging.max_files", "must not be negative")
+	}
+
+	for field, value := range map[string]string{
+		"timeouts.request":     c.Timeouts.Request,
+		"timeouts.body_read":   c.Timeouts.BodyRead,
+		"timeouts.stream_idle": c.Timeouts.StreamIdle,
+		"timeouts.ollama":      c.Timeouts.Ollama,
+		"timeouts.shutdown":    c.Timeouts.Shutdown,
+	} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil {
+			invalid(field, "%q is not a duration, e.g. 30s", value)
+		} else if d < 0 {
+			invalid(field, "must not be negative")
+		}
+	}
+
+	for model, multiplier := range c.Timeouts.ModelMultipliers {
+		if multiplier <= 0 {
+			invalid("timeouts.model_multipliers."+model, "must be positive")
+		}
+	}
+
+	// Maps iterate in random order; sort by field name to keep the report stable
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
+
+// Apply exports the file's settings as environment variables, which is
+// where the services read their configuration. Variables for fields the
+// file leaves out are untouched.
+func (c *Config) Apply() error {
+	for name, value := range c.environ() {
+		if err := os.Setenv(name, value); err != nil {
+			return fmt.Errorf("failed to set %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// lookup returns the value a setting will have once applied: the file's,
+// or else the environment's
+func (c *Config) lookup(name string) string {
+	if value, ok := c.environ()[name]; ok {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// environ maps the fields set in the file to their environment variables
+func (c *Config) environ() map[string]string {
+	env := make(map[string]string)
+	set := func(name, value string) {
+		if value != "" {
+			env[name] = value
+		}
+	}
+
+	if c.Server.Port != 0 {
+		set("PORT", strconv.Itoa(c.Server.Port))
+	}
+
+	set("LLM_TYPE", c.LLM.Type)
+	set("OLLAMA_HOST", c.LLM.OllamaHost)
+	set("OLLAMA_MODEL", c.LLM.OllamaModel)
+	set("OLLAMA_KEEP_ALIVE", c.LLM.OllamaKeepAlive)
+	if c.LLM.OllamaNumCtx != 0 {
+		set("OLLAMA_NUM_CTX", strconv.Itoa(c.LLM.OllamaNumCtx))
+	}
+	set("OPENAI_BASE_URL", c.LLM.OpenAIBaseURL)
+	set("OPENAI_MODEL", c.LLM.OpenAIModel)
+	set("OPENAI_API_KEY", c.LLM.OpenAIAPIKey)
+	set("LLAMACPP_HOST", c.LLM.LlamaCppHost)
+	set("LLAMACPP_MODEL", c.LLM.LlamaCppModel)
+
+	set("LOG_OUTPUT", c.Logging.Output)
+	set("LOG_FORMAT", c.Logging.Format)
+	if c.Logging.MaxSize != nil {
+		set("LOG_MAX_SIZE", strconv.FormatInt(*c.Logging.MaxSize, 10))
+	}
+	if c.Logging.MaxFiles != nil {
+		set("LOG_MAX_FILES", strconv.Itoa(*c.Logging.MaxFiles))
+	}
+	set("LOG_FIELDS", strings.Join(c.Logging.Fields, ","))
+	set("ENVIRONMENT", c.Logging.Environment)
+	set("INSTANCE_ID", c.Logging.Instance)
+	if c.Logging.Bodies != nil {
+		set("LOG_BODIES", strconv.FormatBool(*c.Logging.Bodies))
+	}
+	if c.Logging.Redact != nil {
+		set("LOG_REDACT", strconv.FormatBool(*c.Logging.Redact))
+	}
+	if len(c.Logging.RedactPatterns) > 0 {
+		patterns, _ := json.Marshal(c.Logging.RedactPatterns)
+		set("LOG_REDACT_PATTERNS", string(patterns))
+	}
+
+	set("REQUEST_TIMEOUT", c.Timeouts.Request)
+	set("BODY_READ_TIMEOUT", c.Timeouts.BodyRead)
+	set("STREAM_IDLE_TIMEOUT", c.Timeouts.StreamIdle)
+	set("OLLAMA_TIMEOUT", c.Timeouts.Ollama)
+	set("SHUTDOWN_GRACE_PERIOD", c.Timeouts.Shutdown)
+	if len(c.Timeouts.ModelMultipliers) > 0 {
+		var pairs []string
+		for model, multiplier := range c.Timeouts.ModelMultipliers {
+			pairs = append(pairs, model+"="+strconv.FormatFloat(multiplier, 'g', -1, 64))
+		}
+		sort.Strings(pairs)
+		set("MODEL_TIMEOUT_MULTIPLIERS", strings.Join(pairs, ","))
+	}
+	return env
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeConfig writes a config file named name into a temp directory
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "YAML",
+			file: "config.yaml",
+			content: `
+server:
+  port: 9090
+llm:
+  type: stub
+logging:
+  max_files: 0
+  fields: [success, llm_type]
+timeouts:
+  request: 45s
+`,
+		},
+		{
+			name:    "JSON",
+			file:    "config.json",
+			content: `{"server":{"port":9090},"llm":{"type":"stub"},"logging":{"max_files":0,"fields":["success","llm_type"]},"timeouts":{"request":"45s"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Load(writeConfig(t, tt.file, tt.content))
+			assert.NoError(t, err)
+			assert.Equal(t, 9090, cfg.Server.Port)
+			assert.Equal(t, "stub", cfg.LLM.Type)
+			assert.Equal(t, 0, *cfg.Logging.MaxFiles)
+			assert.Nil(t, cfg.Logging.MaxSize)
+			assert.Equal(t, map[string]string{
+				"PORT":            "9090",
+				"LLM_TYPE":        "stub",
+				"LOG_MAX_FILES":   "0",
+				"LOG_FIELDS":      "success,llm_type",
+				"REQUEST_TIMEOUT": "45s",
+			}, cfg.environ())
+		})
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{name: "Unknown field", file: "config.yaml", content: "llm:\n  modle: llama2\n", wantErr: "field modle not found"},
+		{name: "Unknown JSON field", file: "config.json", content: `{"server":{"host":"x"}}`, wantErr: `unknown field "host"`},
+		{name: "Unsupported format", file: "config.toml", content: "", wantErr: `unsupported config format ".toml"`},
+		{name: "Missing file", file: "", wantErr: "failed to read config"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "missing.yaml")
+			if tt.file != "" {
+				path = writeConfig(t, tt.file, tt.content)
+			}
+			_, err := Load(path)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestConfig_ValidateReportsAllFields(t *testing.T) {
+	os.Unsetenv("OPENAI_MODEL")
+	os.Setenv("OPENAI_API_KEY", "from-env")
+	defer os.Unsetenv("OPENAI_API_KEY")
+
+	maxSize := int64(-1)
+	cfg := Config{
+		Server:   ServerConfig{Port: 70000},
+		LLM:      LLMConfig{Type: "openai", OpenAIBaseURL: "localhost:8000", OllamaKeepAlive: "forever", OllamaNumCtx: -1},
+		Logging:  LoggingConfig{Output: "syslog", MaxSize: &maxSize, RedactPatterns: []string{`\d+`, `(`}},
+		Timeouts: TimeoutsConfig{Request: "soon", Shutdown: "-5s"},
+	}
+
+	err := cfg.Validate()
+	assert.EqualError(t, err, `llm.ollama_keep_alive: time: invalid duration "forever"
+llm.ollama_num_ctx: -1 is not a positive context size
+llm.openai_base_url: "localhost:8000" is not an http(s) URL
+llm.openai_model: required for the openai backend (or set OPENAI_MODEL)
+logging.max_size: must not be negative
+logging.output: "syslog" is not one of file, stdout, stderr or both
+logging.redact_patterns[1]: error parsing regexp: missing closing ): `+"`(`"+`
+server.port: 70000 is not a valid port
+timeouts.request: "soon" is not a duration, e.g. 30s
+timeouts.shutdown: must not be negative`)
+}
+
+func TestConfig_Apply(t *testing.T) {
+	os.Setenv("OLLAMA_MODEL", "from-env")
+	os.Setenv("OLLAMA_HOST", "http://env:11434")
+	defer os.Unsetenv("OLLAMA_MODEL")
+	defer os.Unsetenv("OLLAMA_HOST")
+	defer os.Unsetenv("LLM_TYPE")
+
+	cfg, err := Load(writeConfig(t, "config.yml", "llm:\n  type: ollama\n  ollama_host: http://file:11434\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, cfg.Apply())
+
+	// The file wins where it sets a field, the environment everywhere else
+	assert.Equal(t, "ollama", os.Getenv("LLM_TYPE"))
+	assert.Equal(t, "http://file:11434", os.Getenv("OLLAMA_HOST"))
+	assert.Equal(t, "from-env", os.Getenv("OLLAMA_MODEL"))
+}
+
+func TestConfig_ApplyModelMultipliers(t *testing.T) {
+	t.Setenv("MODEL_TIMEOUT_MULTIPLIERS", "")
+
+	cfg, err := Load(writeConfig(t, "config.yml", "timeouts:\n  model_multipliers:\n    mixtral: 1.5\n    llama2:70b: 2\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, cfg.Apply())
+	assert.Equal(t, "llama2:70b=2,mixtral=1.5", os.Getenv("MODEL_TIMEOUT_MULTIPLIERS"))
+
+	_, err = Load(writeConfig(t, "config.yml", "timeouts:\n  model_multipliers:\n    mixtral: 0\n"))
+	assert.ErrorContains(t, err, "timeouts.model_multipliers.mixtral: must be positive")
+}
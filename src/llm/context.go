@@ -0,0 +1,154 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ContextLengthError reports a prompt that doesn't fit the model's context
+// window. Limit is the window in tokens, or 0 when it isn't known.
+type ContextLengthError struct {
+	Limit   int
+	Message string
+}
+
+func (e *ContextLengthError) Error() string {
+	return e.Message
+}
+
+var (
+	// contextErrorPattern matches backend errors about the context window,
+	// e.g. Ollama's "exceeds maximum context length" and OpenAI's
+	// "maximum context length is 4096 tokens" or "context_length_exceeded"
+	contextErrorPattern = regexp.MustCompile(`(?i)context[ _](length|window)`)
+
+	// contextLimitPattern picks the first number after the phrase
+	contextLimitPattern = regexp.MustCompile(`(?i)context[ _](?:length|window)\D*(\d+)`)
+)
+
+// maxErrorBodyBytes bounds how much of an error response is read
+const maxErrorBodyBytes = 4096
+
+// parseContextLengthError returns a ContextLengthError when message is a
+// backend complaint about the context window, and nil otherwise
+func parseContextLengthError(message string) *ContextLengthError {
+	if !contextErrorPattern.MatchString(message) {
+		return nil
+	}
+	contextErr := &ContextLengthError{Message: message}
+	if match := contextLimitPattern.FindStringSubmatch(message); match != nil {
+		contextErr.Limit, _ = strconv.Atoi(match[1])
+	}
+	return contextErr
+}
+
+// checkStatus returns nil for 200 OK. Otherwise it closes the body and
+// returns a ContextLengthError when the backend rejected the prompt as too
+// long, or a generic status error.
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+	if contextErr := parseContextLengthError(errorMessage(body)); contextErr != nil {
+		return contextErr
+	}
+	return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+}
+
+// errorMessage extracts the message from Ollama's {"error":"..."} or
+// OpenAI's {"error":{"message":"...","code":"..."}} error bodies
+func errorMessage(body []byte) string {
+	var envelope struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Error == nil {
+		return ""
+	}
+
+	var message string
+	if err := json.Unmarshal(envelope.Error, &message); err == nil {
+		return message
+	}
+	var detail struct {
+		Message string `json:"message"`
+		Code    string `json:"code"`
+	}
+	json.Unmarshal(envelope.Error, &detail)
+	if detail.Code != "" && detail.Message != "" {
+		return fmt.Sprintf("%s (%s)", detail.Message, detail.Code)
+	}
+	return detail.Message + detail.Code
+}
+
+// estimateTokens roughly counts prompt tokens at four bytes each, which
+// errs low for most text so only clearly over-long prompts are rejected
+func estimateTokens(prompt string) int {
+	return (len(prompt) + 3) / 4
+}
+
+// ollamaShowResponse is the part of /api/show that holds model metadata
+type ollamaShowResponse struct {
+	ModelInfo map[string]interface{} `json:"model_info"`
+}
+
+// contextWindow returns the model's context length from /api/show, caching
+// the answer per model. It returns 0 when the window can't be determined.
+func (l *OllamaLLM) contextWindow(ctx context.Context, model string) int {
+	if window, ok := l.contextWindows.Load(model); ok {
+		return window.(int)
+	}
+
+	window := 0
+	resp, err := l.post(ctx, "/api/show", map[string]string{"model": model})
+	if err != nil {
+		// Don't cache: the backend may just be briefly unavailable
+		return 0
+	}
+	defer resp.Body.Close()
+
+	var result ollamaShowResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+		// Keys are prefixed by architecture, e.g. "llama.context_length"
+		for key, value := range result.ModelInfo {
+			if n, ok := value.(float64); ok && strings.HasSuffix(key, ".context_length") {
+				window = int(n)
+				break
+			}
+		}
+	}
+	l.contextWindows.Store(model, window)
+	return window
+}
+
+// checkPromptFits rejects prompts that clearly exceed the model's context
+// window, when checking is enabled and the window is known. With
+// OLLAMA_NUM_CTX set that is the window the model is loaded with, whatever
+// it was trained for.
+func (l *OllamaLLM) checkPromptFits(ctx context.Context, model, prompt string) error {
+	if !l.checkContext {
+		return nil
+	}
+	window := l.numCtx
+	if window <= 0 {
+		window = l.contextWindow(ctx, model)
+	}
+	if window <= 0 {
+		return nil
+	}
+	if tokens := estimateTokens(prompt); tokens > window {
+		return &ContextLengthError{
+			Limit:   window,
+			Message: fmt.Sprintf("prompt is about %d tokens, over the %d token context length of %s", tokens, window, model),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseContextLengthError(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantMatch bool
+		wantLimit int
+	}{
+		{
+			name:      "Ollama context error",
+			body:      `{"error":"input length (5000) exceeds maximum context length (4096)"}`,
+			wantMatch: true,
+			wantLimit: 4096,
+		},
+		{
+			name:      "OpenAI context error",
+			body:      `{"error":{"message":"This model's maximum context length is 8192 tokens. However, you requested 9000 tokens.","code":"context_length_exceeded"}}`,
+			wantMatch: true,
+			wantLimit: 8192,
+		},
+		{
+			name:      "Context error without a limit",
+			body:      `{"error":{"code":"context_length_exceeded"}}`,
+			wantMatch: true,
+		},
+		{name: "Other error", body: `{"error":"model not found"}`},
+		{name: "Not JSON", body: `Internal Server Error`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contextErr := parseContextLengthError(errorMessage([]byte(tt.body)))
+			if !tt.wantMatch {
+				assert.Nil(t, contextErr)
+				return
+			}
+			if assert.NotNil(t, contextErr) {
+				assert.Equal(t, tt.wantLimit, contextErr.Limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"minivault/src/types"
+)
+
+// LlamaCppLLM talks to llama.cpp's llama-server through its native
+// /completion endpoint. The server hosts a single model, so the model name
+// only labels requests.
+type LlamaCppLLM struct {
+	baseURL string
+	model   string
+}
+
+type llamaCppRequest struct {
+	Prompt string   `json:"prompt"`
+	Stop   []string `json:"stop,omitempty"`
+	Stream bool     `json:"stream"`
+
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NPredict    *int     `json:"n_predict,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+}
+
+// llamaCppResponse is the completion, or one streamed piece of it; the
+// counts and timings arrive with the final piece, which has Stop set
+type llamaCppResponse struct {
+	Content         string `json:"content"`
+	Stop            bool   `json:"stop"`
+	TokensEvaluated int    `json:"tokens_evaluated"`
+	TokensPredicted int    `json:"tokens_predicted"`
+	Timings         struct {
+		PromptMS    float64 `json:"prompt_ms"`
+		PredictedMS float64 `json:"predicted_ms"`
+	} `json:"timings"`
+}
+
+// usage returns the reported counts, or nil when the server sent none
+func (r *llamaCppResponse) usage() *Usage {
+	if r.TokensEvaluated == 0 && r.TokensPredicted == 0 {
+		return nil
+	}
+	promptEval := time.Duration(r.Timings.PromptMS * float64(time.Millisecond))
+	eval := time.Duration(r.Timings.PredictedMS * float64(time.Millisecond))
+	return &Usage{
+		PromptTokens:       r.TokensEvaluated,
+		CompletionTokens:   r.TokensPredicted,
+		TotalDuration:      promptEval + eval,
+		PromptEvalDuration: promptEval,
+		EvalDuration:       eval,
+	}
+}
+
+func NewLlamaCppLLM(baseURL, model string) *LlamaCppLLM {
+	return &LlamaCppLLM{
+		baseURL: baseURL,
+		model:   model,
+	}
+}
+
+// Generate completes the prompt, after the system prompt if one is set.
+// Tools are not supported by /completion and are ignored.
+func (l *LlamaCppLLM) Generate(ctx context.Context, prompt string, opts Options) (*Result, error) {
+	resp, err := l.post(ctx, l.completionRequest(prompt, opts, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result llamaCppResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return &Result{Response: result.Content, Usage: result.usage()}, nil
+}
+
+// Chat renders the conversation as a "role: content" transcript ending
+// with the assistant's turn, since /completion takes a plain prompt
+func (l *LlamaCppLLM) Chat(ctx context.Context, messages []types.Message, opts Options) (*Result, error) {
+	var transcript strings.Builder
+	for _, message := range messages {
+		fmt.Fprintf(&transcript, "%s: %s\n", message.Role, message.Content)
+	}
+	transcript.WriteString("assistant:")
+
+	result, err := l.Generate(ctx, transcript.String(), opts)
+	if err != nil {
+		return nil, err
+	}
+	result.Response = strings.TrimSpace(result.Response)
+	return result, nil
+}
+
+// GenerateStream reads the streamed completion, one JSON object per
+// line with an optional "data: " prefix, and writes each piece
+func (l *LlamaCppLLM) GenerateStream(ctx context.Context, prompt string, opts Options, writer io.Writer) error {
+	resp, err := l.post(ctx, l.completionRequest(prompt, opts, true))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "data: "))
+		if line == "" {
+			continue // blank separators between events
+		}
+
+		var chunk llamaCppResponse
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return fmt.Errorf("failed to decode stream: %v", err)
+		}
+		if chunk.Content != "" {
+			if _, err := fmt.Fprintf(writer, "%s", chunk.Content); err != nil {
+				return fmt.Errorf("failed to write response: %w", err)
+			}
+		}
+		if chunk.Stop {
+			reportUsage(ctx, chunk.usage())
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err() // the caller cancelled, e.g. the client went away
+		}
+		return fmt.Errorf("failed to read stream: %v", err)
+	}
+
+	return nil
+}
+
+// Ping checks that the server answers /health, which it only does once
+// the model has loaded
+func (l *LlamaCppLLM) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, PingTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", l.baseURL+"/health", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach llama.cpp server: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ListModels returns the configured model, the only one the server hosts
+func (l *LlamaCppLLM) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	return []ModelInfo{{Name: l.model}}, nil
+}
+
+func (l *LlamaCppLLM) completionRequest(prompt string, opts Options, stream bool) llamaCppRequest {
+	if system := opts.SystemPrompt(); system != "" {
+		prompt = system + "\n\n" + prompt
+	}
+	return llamaCppRequest{
+		Prompt: prompt,
+		Stop:   opts.Stop,
+		Stream: stream,
+
+		Temperature: opts.Temperature,
+		TopP:        opts.TopP,
+		NPredict:    opts.MaxTokens,
+		Seed:        opts.Seed,
+	}
+}
+
+// post sends a completion request and returns the response when the
+// status is 200 OK. The caller must close the body.
+func (l *LlamaCppLLM) post(ctx context.Context, body llamaCppRequest) (*http.Response, error) {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", l.baseURL+"/completion", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	recordTransfer(ctx, len(jsonBody), resp)
+	return resp, nil
+}
@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"minivault/src/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLlamaCppLLM_Generate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/completion", r.URL.Path)
+		assert.Equal(t, "POST", r.Method)
+
+		var req llamaCppRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "Be terse.\n\ntest prompt", req.Prompt)
+		assert.Equal(t, []string{"END"}, req.Stop)
+		assert.Equal(t, 64, *req.NPredict)
+		assert.False(t, req.Stream)
+
+		w.Write([]byte(`{"content":"test response","stop":true,"tokens_evaluated":7,"tokens_predicted":2,"timings":{"prompt_ms":12.5,"predicted_ms":40}}`))
+	}))
+	defer server.Close()
+
+	llm := NewLlamaCppLLM(server.URL, "mistral-7b")
+	system := "Be terse."
+	maxTokens := 64
+	result, err := llm.Generate(context.Background(), "test prompt", Options{System: &system, Stop: []string{"END"}, MaxTokens: &maxTokens})
+	assert.NoError(t, err)
+	assert.Equal(t, "test response", result.Response)
+	assert.Equal(t, &Usage{
+		PromptTokens:       7,
+		CompletionTokens:   2,
+		TotalDuration:      52500 * time.Microsecond,
+		PromptEvalDuration: 12500 * time.Microsecond,
+		EvalDuration:       40 * time.Millisecond,
+	}, result.Usage)
+}
+
+func TestLlamaCppLLM_Chat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req llamaCppRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "user: Hi\nassistant: Hello!\nuser: Capital of France?\nassistant:", req.Prompt)
+		w.Write([]byte(`{"content":" Paris.","stop":true}`))
+	}))
+	defer server.Close()
+
+	llm := NewLlamaCppLLM(server.URL, "mistral-7b")
+	messages := []types.Message{
+		{Role: "user", Content: "Hi"},
+		{Role: "assistant", Content: "Hello!"},
+		{Role: "user", Content: "Capital of France?"},
+	}
+	result, err := llm.Chat(context.Background(), messages, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Paris.", result.Response)
+}
+
+func TestLlamaCppLLM_GenerateStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req llamaCppRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.True(t, req.Stream)
+
+		// llama-server sends server-sent events; bare JSON lines are accepted too
+		w.Write([]byte("data: {\"content\":\"test\",\"stop\":false}\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("{\"content\":\" response\",\"stop\":false}\n"))
+		w.Write([]byte("data: {\"content\":\"\",\"stop\":true,\"tokens_evaluated\":3,\"tokens_predicted\":2,\"timings\":{\"prompt_ms\":1,\"predicted_ms\":2}}\n\n"))
+	}))
+	defer server.Close()
+
+	llm := NewLlamaCppLLM(server.URL, "mistral-7b")
+	var buf bytes.Buffer
+	report := &UsageReport{}
+	err := llm.GenerateStream(WithUsageReport(context.Background(), report), "test prompt", Options{}, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "test response", buf.String())
+	if assert.NotNil(t, report.Usage()) {
+		assert.Equal(t, 2, report.Usage().CompletionTokens)
+		assert.Equal(t, 3*time.Millisecond, report.Usage().TotalDuration)
+	}
+}
+
+func TestLlamaCppLLM_GenerateError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":{"code":503,"message":"Loading model"}}`))
+	}))
+	defer server.Close()
+
+	llm := NewLlamaCppLLM(server.URL, "mistral-7b")
+	_, err := llm.Generate(context.Background(), "test prompt", Options{})
+	assert.EqualError(t, err, "unexpected status code: 503")
+	assert.Error(t, llm.Ping(context.Background()))
+}
+
+func TestLlamaCppLLM_Ping(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/health", r.URL.Path)
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	llm := NewLlamaCppLLM(server.URL, "mistral-7b")
+	assert.NoError(t, llm.Ping(context.Background()))
+	models, err := llm.ListModels(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []ModelInfo{{Name: "mistral-7b"}}, models)
+}
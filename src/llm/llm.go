@@ -2,21 +2,164 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"minivault/src/types"
 )
 
 // LLM defines the interface for language model interactions
 type LLM interface {
-	Generate(ctx context.Context, prompt string) (string, error)
-	GenerateStream(ctx context.Context, prompt string, writer io.Writer) error
+	Generate(ctx context.Context, prompt string, opts Options) (*Result, error)
+	GenerateStream(ctx context.Context, prompt string, opts Options, writer io.Writer) error
+	// Chat answers the last message of a conversation
+	Chat(ctx context.Context, messages []types.Message, opts Options) (*Result, error)
+	Ping(ctx context.Context) error // checks the backend is reachable
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+}
+
+// Embedder is implemented by backends that can embed text
+type Embedder interface {
+	Embeddings(ctx context.Context, input string) ([]float64, error)
+}
+
+// ErrModelListingUnsupported is returned, with an empty list, by ListModels
+// when the backend can't list its models, such as Ollama versions without
+// /api/tags
+var ErrModelListingUnsupported = errors.New("the backend does not support listing models")
+
+// ModelInfo describes a model available on the backend
+type ModelInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size,omitempty"` // bytes on disk, when the backend reports it
 }
 
+// PingTimeout bounds backend health checks
+const PingTimeout = 2 * time.Second
+
+// Options holds optional per-request generation settings
+type Options struct {
+	Model string       `json:"model,omitempty"` // overrides the configured model when set
+	Tools []types.Tool `json:"tools,omitempty"` // function schemas the model may call
+	Stop  []string     `json:"stop,omitempty"`  // sequences that end generation
+
+	// Sampling settings; nil leaves the backend's default
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	Seed        *int     `json:"seed,omitempty"` // makes sampling reproducible on backends that support it
+
+	// System prompt sent ahead of the prompt; nil leaves the server default
+	// and an empty string sends none
+	System *string `json:"system,omitempty"`
+}
+
+// SystemPrompt returns the system prompt to send, "" for none
+func (o Options) SystemPrompt() string {
+	if o.System == nil {
+		return ""
+	}
+	return *o.System
+}
+
+// Result holds the output of a non-streaming generation
+type Result struct {
+	Response      string
+	ToolCalls     []types.ToolCall
+	EncodingIssue bool // response was not valid UTF-8 and had to be sanitized
+	EmptyResponse bool // response was still empty after retrying
+	JSONUnwrapped bool // response was a JSON string holding JSON and was unescaped
+	TimedOut      bool // the deadline passed mid-generation; Response is the text so far
+
+	// CollapsedLines counts repeated lines removed from Response
+	CollapsedLines int
+
+	// RawResponse is the backend's output before post-processing
+	RawResponse string
+
+	// Usage is the token usage, when the backend reports it
+	Usage *Usage
+}
+
+// Usage counts the tokens consumed by a generation and, when the backend
+// reports them, the time it spent on each stage
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+
+	TotalDuration      time.Duration `json:"total_duration,omitempty"`       // whole request, as timed by the backend
+	LoadDuration       time.Duration `json:"load_duration,omitempty"`        // loading the model
+	PromptEvalDuration time.Duration `json:"prompt_eval_duration,omitempty"` // evaluating the prompt
+	EvalDuration       time.Duration `json:"eval_duration,omitempty"`        // generating the response
+}
+
+// Defaults for the Ollama HTTP client when Config leaves them unset
+const (
+	DefaultMaxIdleConns        = 100
+	DefaultMaxIdleConnsPerHost = 10
+
+	// DefaultHTTPTimeout covers a whole generation, including reading a
+	// stream, so it is a backstop rather than a per-request budget
+	DefaultHTTPTimeout = 5 * time.Minute
+)
+
 // Config holds LLM configuration
 type Config struct {
-	Type  string // "ollama" or "stub"
-	URL   string // base URL for API calls
-	Model string // model name
+	Type   string // "ollama", "openai", "llamacpp" or "stub"
+	URL    string // base URL for API calls
+	Model  string // model name
+	APIKey string // bearer token, required for "openai"
+
+	// HTTPClient is used for Ollama requests when set. Otherwise a client
+	// is built from the pooling and timeout settings below, with zero
+	// values replaced by the defaults.
+	HTTPClient          *http.Client
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	Timeout             time.Duration
+
+	// CheckContextWindow looks up each model's context length with
+	// /api/show and rejects prompts that clearly exceed it (Ollama only)
+	CheckContextWindow bool
+
+	// KeepAlive is how long Ollama keeps the model loaded after a request,
+	// as a duration such as "30m"; negative keeps it loaded indefinitely.
+	// NumCtx is the context window the model is loaded with. Both are left
+	// out of requests when unset, keeping Ollama's defaults (Ollama only).
+	KeepAlive string
+	NumCtx    int
+}
+
+// httpClient returns the configured client or builds a pooled one
+func (c Config) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return newHTTPClient(c.MaxIdleConns, c.MaxIdleConnsPerHost, c.Timeout)
+}
+
+// newHTTPClient builds a client with its own connection pool so backends
+// don't share http.DefaultClient's global state. Zero values use the
+// defaults.
+func newHTTPClient(maxIdleConns, maxIdleConnsPerHost int, timeout time.Duration) *http.Client {
+	if maxIdleConns <= 0 {
+		maxIdleConns = DefaultMaxIdleConns
+	}
+	if maxIdleConnsPerHost <= 0 {
+		maxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+	if timeout <= 0 {
+		timeout = DefaultHTTPTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxIdleConns
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	return &http.Client{Transport: transport, Timeout: timeout}
 }
 
 // NewLLM creates a new LLM instance based on configuration
@@ -29,10 +172,72 @@ func NewLLM(config Config) (LLM, error) {
 		if config.Model == "" {
 			return nil, fmt.Errorf("OLLAMA_MODEL is not set")
 		}
-		return NewOllamaLLM(config.URL, config.Model), nil
+		baseURL, err := normalizeBaseURL(config.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OLLAMA_HOST %q: %v", config.URL, err)
+		}
+		backend := NewOllamaLLMWithClient(baseURL, config.Model, config.httpClient())
+		backend.checkContext = config.CheckContextWindow
+		backend.keepAlive = config.KeepAlive
+		backend.numCtx = config.NumCtx
+		return backend, nil
+	case "openai":
+		if config.URL == "" {
+			return nil, fmt.Errorf("OPENAI_BASE_URL is not set")
+		}
+		if config.Model == "" {
+			return nil, fmt.Errorf("OPENAI_MODEL is not set")
+		}
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY is not set")
+		}
+		baseURL, err := normalizeBaseURL(config.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OPENAI_BASE_URL %q: %v", config.URL, err)
+		}
+		return NewOpenAILLM(baseURL, config.Model, config.APIKey), nil
+	case "llamacpp":
+		if config.URL == "" {
+			return nil, fmt.Errorf("LLAMACPP_HOST is not set")
+		}
+		if config.Model == "" {
+			return nil, fmt.Errorf("LLAMACPP_MODEL is not set")
+		}
+		baseURL, err := normalizeBaseURL(config.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LLAMACPP_HOST %q: %v", config.URL, err)
+		}
+		return NewLlamaCppLLM(baseURL, config.Model), nil
 	case "stub":
 		return NewStubLLM(), nil
 	default:
 		return nil, fmt.Errorf("unsupported LLM type: %s", config.Type)
 	}
 }
+
+// normalizeBaseURL validates a backend base URL, defaulting the scheme to
+// http and dropping trailing slashes so paths can be appended directly
+func normalizeBaseURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("scheme must be http or https")
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("missing host")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("must not have a query or fragment")
+	}
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u.String(), nil
+}
@@ -1,7 +1,9 @@
 package llm
 
 import (
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -37,6 +39,42 @@ func TestNewLLM(t *testing.T) {
 			},
 			wantError: true,
 		},
+		{
+			name: "Valid OpenAI config",
+			config: Config{
+				Type:   "openai",
+				URL:    "http://localhost:8000",
+				Model:  "test-model",
+				APIKey: "test-key",
+			},
+			wantError: false,
+		},
+		{
+			name: "Missing OpenAI API key",
+			config: Config{
+				Type:  "openai",
+				URL:   "http://localhost:8000",
+				Model: "test-model",
+			},
+			wantError: true,
+		},
+		{
+			name: "Valid llama.cpp config",
+			config: Config{
+				Type:  "llamacpp",
+				URL:   "localhost:8080",
+				Model: "mistral-7b",
+			},
+			wantError: false,
+		},
+		{
+			name: "Missing llama.cpp URL",
+			config: Config{
+				Type:  "llamacpp",
+				Model: "mistral-7b",
+			},
+			wantError: true,
+		},
 		{
 			name: "Valid stub config",
 			config: Config{
@@ -67,6 +105,12 @@ func TestNewLLM(t *testing.T) {
 				case "ollama":
 					_, ok := llm.(*OllamaLLM)
 					assert.True(t, ok, "Expected OllamaLLM type")
+				case "openai":
+					_, ok := llm.(*OpenAILLM)
+					assert.True(t, ok, "Expected OpenAILLM type")
+				case "llamacpp":
+					_, ok := llm.(*LlamaCppLLM)
+					assert.True(t, ok, "Expected LlamaCppLLM type")
 				case "stub":
 					_, ok := llm.(*StubLLM)
 					assert.True(t, ok, "Expected StubLLM type")
@@ -75,3 +119,72 @@ func TestNewLLM(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeBaseURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{name: "Already valid", raw: "http://localhost:11434", want: "http://localhost:11434"},
+		{name: "Scheme-less", raw: "localhost:11434", want: "http://localhost:11434"},
+		{name: "Trailing slash", raw: "http://ollama:11434/", want: "http://ollama:11434"},
+		{name: "Path with trailing slashes", raw: "https://gpu.internal/ollama//", want: "https://gpu.internal/ollama"},
+		{name: "Surrounding whitespace", raw: " ollama:11434 ", want: "http://ollama:11434"},
+		{name: "Unsupported scheme", raw: "ftp://ollama:11434", wantErr: true},
+		{name: "Missing host", raw: "http://", wantErr: true},
+		{name: "Unparseable", raw: "http://oll ama:11434", wantErr: true},
+		{name: "Query string", raw: "http://ollama:11434?x=1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeBaseURL(tt.raw)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewLLM_NormalizesOllamaURL(t *testing.T) {
+	backend, err := NewLLM(Config{Type: "ollama", URL: "localhost:11434/", Model: "test-model"})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:11434", backend.(*OllamaLLM).baseURL)
+
+	_, err = NewLLM(Config{Type: "ollama", URL: "ftp://localhost", Model: "test-model"})
+	assert.ErrorContains(t, err, "invalid OLLAMA_HOST")
+}
+
+func TestNewLLM_OllamaHTTPClient(t *testing.T) {
+	// Zero values fall back to the defaults
+	backend, err := NewLLM(Config{Type: "ollama", URL: "localhost:11434", Model: "test-model"})
+	assert.NoError(t, err)
+	client := backend.(*OllamaLLM).client
+	transport := client.Transport.(*http.Transport)
+	assert.Equal(t, DefaultHTTPTimeout, client.Timeout)
+	assert.Equal(t, DefaultMaxIdleConns, transport.MaxIdleConns)
+	assert.Equal(t, DefaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+
+	// Pooling and timeout are overridable
+	backend, err = NewLLM(Config{
+		Type: "ollama", URL: "localhost:11434", Model: "test-model",
+		MaxIdleConns: 20, MaxIdleConnsPerHost: 5, Timeout: time.Minute,
+	})
+	assert.NoError(t, err)
+	client = backend.(*OllamaLLM).client
+	transport = client.Transport.(*http.Transport)
+	assert.Equal(t, time.Minute, client.Timeout)
+	assert.Equal(t, 20, transport.MaxIdleConns)
+	assert.Equal(t, 5, transport.MaxIdleConnsPerHost)
+
+	// A supplied client is used as is
+	custom := &http.Client{}
+	backend, err = NewLLM(Config{Type: "ollama", URL: "localhost:11434", Model: "test-model", HTTPClient: custom})
+	assert.NoError(t, err)
+	assert.Same(t, custom, backend.(*OllamaLLM).client)
+}
@@ -4,104 +4,233 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
+	"time"
+
+	"minivault/src/types"
 )
 
 type OllamaLLM struct {
 	baseURL string
 	model   string
+	client  *http.Client // reused across requests so connections are pooled
+
+	// checkContext enables rejecting prompts longer than the model's
+	// context window, which is looked up once per model
+	checkContext   bool
+	contextWindows sync.Map
+
+	keepAlive string // how long Ollama keeps the model loaded, e.g. "30m"; "" leaves its default
+	numCtx    int    // context window Ollama loads the model with; 0 leaves the model's default
 }
 
 type ollamaRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
+	Model     string         `json:"model"`
+	Prompt    string         `json:"prompt"`
+	System    string         `json:"system,omitempty"`
+	Stream    bool           `json:"stream"`
+	Options   *ollamaOptions `json:"options,omitempty"`
+	KeepAlive string         `json:"keep_alive,omitempty"`
+}
+
+// ollamaOptions holds the model parameters Ollama accepts under "options"
+type ollamaOptions struct {
+	Stop        []string `json:"stop,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NumPredict  *int     `json:"num_predict,omitempty"` // Ollama's name for max tokens
+	Seed        *int     `json:"seed,omitempty"`
+	NumCtx      int      `json:"num_ctx,omitempty"`
 }
 
 type ollamaResponse struct {
 	Response string `json:"response"`
 	Done     bool   `json:"done"`
+	ollamaCounts
+}
+
+// ollamaCounts are the token counts and timings, in nanoseconds, that
+// Ollama reports on its final response
+type ollamaCounts struct {
+	PromptEvalCount    int   `json:"prompt_eval_count"`
+	EvalCount          int   `json:"eval_count"`
+	TotalDuration      int64 `json:"total_duration"`
+	LoadDuration       int64 `json:"load_duration"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration"`
+	EvalDuration       int64 `json:"eval_duration"`
+}
+
+// usage returns the reported counts, or nil when Ollama sent none
+func (c ollamaCounts) usage() *Usage {
+	if c.PromptEvalCount == 0 && c.EvalCount == 0 {
+		return nil
+	}
+	return &Usage{
+		PromptTokens:       c.PromptEvalCount,
+		CompletionTokens:   c.EvalCount,
+		TotalDuration:      time.Duration(c.TotalDuration),
+		LoadDuration:       time.Duration(c.LoadDuration),
+		PromptEvalDuration: time.Duration(c.PromptEvalDuration),
+		EvalDuration:       time.Duration(c.EvalDuration),
+	}
+}
+
+// ollamaChatRequest is used for tool calling, which Ollama only supports on /api/chat
+type ollamaChatRequest struct {
+	Model     string          `json:"model"`
+	Messages  []ollamaMessage `json:"messages"`
+	Tools     []types.Tool    `json:"tools,omitempty"`
+	Stream    bool            `json:"stream"`
+	Options   *ollamaOptions  `json:"options,omitempty"`
+	KeepAlive string          `json:"keep_alive,omitempty"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []types.ToolCall `json:"tool_calls,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Message ollamaMessage `json:"message"`
+	Done    bool          `json:"done"`
+	ollamaCounts
+}
+
+// ollamaEmbeddingsRequest and ollamaEmbeddingsResponse are the bodies of
+// /api/embeddings, which embeds one prompt per call
+type ollamaEmbeddingsRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+}
+
+type ollamaEmbeddingsResponse struct {
+	Embedding []float64 `json:"embedding"`
+}
+
+// ollamaTagsResponse is the model list returned by /api/tags
+type ollamaTagsResponse struct {
+	Models []struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	} `json:"models"`
 }
 
 func NewOllamaLLM(baseURL, model string) *OllamaLLM {
+	return NewOllamaLLMWithClient(baseURL, model, nil)
+}
+
+// NewOllamaLLMWithClient is like NewOllamaLLM but sends requests with
+// client. A nil client gets a pooled client with the default settings.
+func NewOllamaLLMWithClient(baseURL, model string, client *http.Client) *OllamaLLM {
 	if baseURL == "" {
 		baseURL = "http://localhost:11434"
 	}
 	if model == "" {
 		model = "llama2"
 	}
+	if client == nil {
+		client = newHTTPClient(0, 0, 0)
+	}
 	return &OllamaLLM{
 		baseURL: baseURL,
 		model:   model,
+		client:  client,
 	}
 }
 
-func (l *OllamaLLM) Generate(ctx context.Context, prompt string) (string, error) {
-	reqBody := ollamaRequest{
-		Model:  l.model,
-		Prompt: prompt,
-		Stream: false,
+func (l *OllamaLLM) Generate(ctx context.Context, prompt string, opts Options) (*Result, error) {
+	if err := l.checkPromptFits(ctx, l.modelFor(opts), prompt); err != nil {
+		return nil, err
 	}
-
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %v", err)
+	if len(opts.Tools) > 0 {
+		// Ollama only supports tool calling on /api/chat
+		return l.Chat(ctx, []types.Message{{Role: "user", Content: prompt}}, opts)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", l.baseURL+"/api/generate", bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+	reqBody := ollamaRequest{
+		Model:     l.modelFor(opts),
+		Prompt:    prompt,
+		System:    opts.SystemPrompt(),
+		Stream:    false,
+		Options:   l.toOllamaOptions(opts),
+		KeepAlive: l.keepAlive,
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := l.post(ctx, "/api/generate", reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
 	var result ollamaResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %v", err)
+		return nil, fmt.Errorf("failed to decode response: %v", err)
 	}
 
-	return result.Response, nil
+	return &Result{Response: result.Response, Usage: result.usage()}, nil
 }
 
-func (l *OllamaLLM) GenerateStream(ctx context.Context, prompt string, writer io.Writer) error {
-	reqBody := ollamaRequest{
-		Model:  l.model,
-		Prompt: prompt,
-		Stream: true,
+// Chat sends the conversation to /api/chat, after the system prompt if
+// one is set
+func (l *OllamaLLM) Chat(ctx context.Context, messages []types.Message, opts Options) (*Result, error) {
+	var chat []ollamaMessage
+	if system := opts.SystemPrompt(); system != "" {
+		chat = append(chat, ollamaMessage{Role: "system", Content: system})
+	}
+	for _, message := range messages {
+		chat = append(chat, ollamaMessage{Role: message.Role, Content: message.Content})
+	}
+	reqBody := ollamaChatRequest{
+		Model:     l.modelFor(opts),
+		Messages:  chat,
+		Tools:     opts.Tools,
+		Stream:    false,
+		Options:   l.toOllamaOptions(opts),
+		KeepAlive: l.keepAlive,
 	}
 
-	jsonBody, err := json.Marshal(reqBody)
+	resp, err := l.post(ctx, "/api/chat", reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to marshal request: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result ollamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", l.baseURL+"/api/generate", bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+	return &Result{
+		Response:  result.Message.Content,
+		ToolCalls: result.Message.ToolCalls,
+		Usage:     result.usage(),
+	}, nil
+}
+
+func (l *OllamaLLM) GenerateStream(ctx context.Context, prompt string, opts Options, writer io.Writer) error {
+	if err := l.checkPromptFits(ctx, l.modelFor(opts), prompt); err != nil {
+		return err
+	}
+	reqBody := ollamaRequest{
+		Model:     l.modelFor(opts),
+		Prompt:    prompt,
+		System:    opts.SystemPrompt(),
+		Stream:    true,
+		Options:   l.toOllamaOptions(opts),
+		KeepAlive: l.keepAlive,
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := l.post(ctx, "/api/generate", reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
 	decoder := json.NewDecoder(resp.Body)
 	for {
 		var result ollamaResponse
@@ -109,17 +238,156 @@ func (l *OllamaLLM) GenerateStream(ctx context.Context, prompt string, writer io
 			if err == io.EOF {
 				break
 			}
+			if ctx.Err() != nil {
+				return ctx.Err() // the caller cancelled, e.g. the client went away
+			}
 			return fmt.Errorf("failed to decode stream: %v", err)
 		}
 
+		// A failed write means the client is gone, so stop pulling tokens
 		if _, err := fmt.Fprintf(writer, "%s", result.Response); err != nil {
-			return fmt.Errorf("failed to write response: %v", err)
+			return fmt.Errorf("failed to write response: %w", err)
 		}
 
 		if result.Done {
+			reportUsage(ctx, result.usage())
 			break
 		}
 	}
 
 	return nil
 }
+
+// Ping checks that the Ollama server answers /api/version
+func (l *OllamaLLM) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, PingTimeout)
+	defer cancel()
+
+	resp, err := l.get(ctx, "/api/version")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ListModels returns the models pulled on the Ollama server. Versions
+// without /api/tags answer 404, which gives an empty list and
+// ErrModelListingUnsupported.
+func (l *OllamaLLM) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	resp, err := l.get(ctx, "/api/tags")
+	if errors.Is(err, errNotFound) {
+		return []ModelInfo{}, ErrModelListingUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result ollamaTagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	models := make([]ModelInfo, 0, len(result.Models))
+	for _, model := range result.Models {
+		models = append(models, ModelInfo{Name: model.Name, Size: model.Size})
+	}
+	return models, nil
+}
+
+// Embeddings embeds input with the configured model
+func (l *OllamaLLM) Embeddings(ctx context.Context, input string) ([]float64, error) {
+	resp, err := l.post(ctx, "/api/embeddings", ollamaEmbeddingsRequest{Model: l.model, Prompt: input})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result ollamaEmbeddingsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if len(result.Embedding) == 0 {
+		return nil, fmt.Errorf("response has no embedding")
+	}
+	return result.Embedding, nil
+}
+
+// errNotFound is returned by get when the path doesn't exist on the server
+var errNotFound = errors.New("unexpected status code: 404")
+
+// get sends a GET request to the given Ollama API path and returns the
+// response when the status is 200 OK. The caller must close the body.
+func (l *OllamaLLM) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", l.baseURL+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Ollama: %v", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// modelFor returns the per-request model override or the configured model
+func (l *OllamaLLM) modelFor(opts Options) string {
+	if opts.Model != "" {
+		return opts.Model
+	}
+	return l.model
+}
+
+// toOllamaOptions maps generation options, and the configured context
+// size, onto Ollama's options object, returning nil when nothing is set so
+// the field is omitted entirely
+func (l *OllamaLLM) toOllamaOptions(opts Options) *ollamaOptions {
+	if len(opts.Stop) == 0 && opts.Temperature == nil && opts.TopP == nil && opts.MaxTokens == nil && opts.Seed == nil && l.numCtx == 0 {
+		return nil
+	}
+	return &ollamaOptions{
+		Stop:        opts.Stop,
+		Temperature: opts.Temperature,
+		TopP:        opts.TopP,
+		NumPredict:  opts.MaxTokens,
+		Seed:        opts.Seed,
+		NumCtx:      l.numCtx,
+	}
+}
+
+// post sends a JSON request to the given Ollama API path and returns the
+// response when the status is 200 OK. The caller must close the body.
+func (l *OllamaLLM) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", l.baseURL+path, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	recordTransfer(ctx, len(jsonBody), resp)
+	return resp, nil
+}
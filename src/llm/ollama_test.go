@@ -4,9 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"minivault/src/types"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -28,8 +34,9 @@ func TestOllamaLLM_Generate(t *testing.T) {
 
 		// Send response
 		response := ollamaResponse{
-			Response: "test response",
-			Done:     true,
+			Response:     "test response",
+			Done:         true,
+			ollamaCounts: ollamaCounts{PromptEvalCount: 12, EvalCount: 3},
 		}
 		json.NewEncoder(w).Encode(response)
 	}))
@@ -40,9 +47,238 @@ func TestOllamaLLM_Generate(t *testing.T) {
 	ctx := context.Background()
 
 	// Test generation
-	response, err := llm.Generate(ctx, "test prompt")
+	result, err := llm.Generate(ctx, "test prompt", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "test response", result.Response)
+	assert.Equal(t, &Usage{PromptTokens: 12, CompletionTokens: 3}, result.Usage)
+}
+
+func TestOllamaLLM_GenerateStop(t *testing.T) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		json.NewEncoder(w).Encode(ollamaResponse{Response: "ok", Done: true})
+	}))
+	defer server.Close()
+
+	llm := NewOllamaLLM(server.URL, "test-model")
+	ctx := context.Background()
+
+	// Stop sequences are sent under options
+	_, err := llm.Generate(ctx, "test prompt", Options{Stop: []string{"</s>"}})
+	assert.NoError(t, err)
+
+	// Without stops the options object is omitted entirely
+	_, err = llm.Generate(ctx, "test prompt", Options{})
+	assert.NoError(t, err)
+
+	assert.Len(t, bodies, 2)
+	assert.Equal(t, map[string]interface{}{"stop": []interface{}{"</s>"}}, bodies[0]["options"])
+	assert.NotContains(t, bodies[1], "options")
+}
+
+func TestOllamaLLM_GenerateSystem(t *testing.T) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		json.NewEncoder(w).Encode(ollamaResponse{Response: "ok", Done: true})
+	}))
+	defer server.Close()
+
+	llm := NewOllamaLLM(server.URL, "test-model")
+	ctx := context.Background()
+
+	// The system prompt goes in /api/generate's system field
+	system := "Be terse."
+	_, err := llm.Generate(ctx, "test prompt", Options{System: &system})
+	assert.NoError(t, err)
+
+	// An empty system prompt is left out
+	empty := ""
+	_, err = llm.Generate(ctx, "test prompt", Options{System: &empty})
+	assert.NoError(t, err)
+
+	assert.Len(t, bodies, 2)
+	assert.Equal(t, "Be terse.", bodies[0]["system"])
+	assert.NotContains(t, bodies[1], "system")
+}
+
+func TestOllamaLLM_GenerateSampling(t *testing.T) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		json.NewEncoder(w).Encode(ollamaResponse{Response: "ok", Done: true})
+	}))
+	defer server.Close()
+
+	llm := NewOllamaLLM(server.URL, "test-model")
+	temperature, topP, maxTokens, seed := 0.2, 0.9, 64, 7
+
+	// Sampling settings are sent under options, max tokens as num_predict
+	_, err := llm.Generate(context.Background(), "test prompt", Options{Temperature: &temperature, TopP: &topP, MaxTokens: &maxTokens, Seed: &seed})
+	assert.NoError(t, err)
+	err = llm.GenerateStream(context.Background(), "test prompt", Options{Temperature: &temperature}, &bytes.Buffer{})
+	assert.NoError(t, err)
+
+	assert.Len(t, bodies, 2)
+	assert.Equal(t, map[string]interface{}{"temperature": 0.2, "top_p": 0.9, "num_predict": float64(64), "seed": float64(7)}, bodies[0]["options"])
+	assert.Equal(t, map[string]interface{}{"temperature": 0.2}, bodies[1]["options"])
+}
+
+func TestOllamaLLM_KeepAliveAndNumCtx(t *testing.T) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		if r.URL.Path == "/api/chat" {
+			json.NewEncoder(w).Encode(ollamaChatResponse{Message: ollamaMessage{Role: "assistant", Content: "ok"}, Done: true})
+			return
+		}
+		json.NewEncoder(w).Encode(ollamaResponse{Response: "ok", Done: true})
+	}))
+	defer server.Close()
+
+	// Unset, both are left out so Ollama's defaults apply
+	backend, err := NewLLM(Config{Type: "ollama", URL: server.URL, Model: "test-model"})
+	assert.NoError(t, err)
+	_, err = backend.Generate(context.Background(), "test prompt", Options{})
+	assert.NoError(t, err)
+	assert.NotContains(t, bodies[0], "keep_alive")
+	assert.NotContains(t, bodies[0], "options")
+
+	// Configured, keep_alive is top-level and num_ctx goes under options
+	backend, err = NewLLM(Config{Type: "ollama", URL: server.URL, Model: "test-model", KeepAlive: "30m", NumCtx: 8192})
+	assert.NoError(t, err)
+	temperature := 0.2
+	_, err = backend.Generate(context.Background(), "test prompt", Options{Temperature: &temperature})
+	assert.NoError(t, err)
+	err = backend.GenerateStream(context.Background(), "test prompt", Options{}, &bytes.Buffer{})
+	assert.NoError(t, err)
+	_, err = backend.(*OllamaLLM).Chat(context.Background(), []types.Message{{Role: "user", Content: "hi"}}, Options{})
+	assert.NoError(t, err)
+
+	assert.Len(t, bodies, 4)
+	assert.Equal(t, "30m", bodies[1]["keep_alive"])
+	assert.Equal(t, map[string]interface{}{"temperature": 0.2, "num_ctx": float64(8192)}, bodies[1]["options"])
+	for _, body := range bodies[2:] {
+		assert.Equal(t, "30m", body["keep_alive"])
+		assert.Equal(t, map[string]interface{}{"num_ctx": float64(8192)}, body["options"])
+	}
+}
+
+func TestOllamaLLM_GenerateModelOverride(t *testing.T) {
+	var models []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		models = append(models, req.Model)
+		json.NewEncoder(w).Encode(ollamaResponse{Response: "ok", Done: true})
+	}))
+	defer server.Close()
+
+	llm := NewOllamaLLM(server.URL, "test-model")
+	ctx := context.Background()
+
+	_, err := llm.Generate(ctx, "test prompt", Options{Model: "other-model"})
+	assert.NoError(t, err)
+	_, err = llm.Generate(ctx, "test prompt", Options{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"other-model", "test-model"}, models)
+}
+
+func TestOllamaLLM_GenerateWithTools(t *testing.T) {
+	// Create test server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Tool calling goes through the chat API
+		assert.Equal(t, "/api/chat", r.URL.Path)
+		assert.Equal(t, "POST", r.Method)
+
+		// Parse request body
+		var req ollamaChatRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		assert.NoError(t, err)
+		assert.Equal(t, "test-model", req.Model)
+		assert.Equal(t, []ollamaMessage{{Role: "user", Content: "test prompt"}}, req.Messages)
+		assert.Len(t, req.Tools, 1)
+		assert.Equal(t, "get_weather", req.Tools[0].Function.Name)
+		assert.False(t, req.Stream)
+
+		// Send response with a tool call
+		w.Write([]byte(`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Paris"}}}]},"done":true}`))
+	}))
+	defer server.Close()
+
+	// Create LLM with test server URL
+	llm := NewOllamaLLM(server.URL, "test-model")
+	ctx := context.Background()
+
+	// Test generation with tools
+	tools := []types.Tool{{
+		Type:     "function",
+		Function: types.ToolFunction{Name: "get_weather", Parameters: json.RawMessage(`{"type":"object"}`)},
+	}}
+	result, err := llm.Generate(ctx, "test prompt", Options{Tools: tools})
+	assert.NoError(t, err)
+	assert.Len(t, result.ToolCalls, 1)
+	assert.Equal(t, "get_weather", result.ToolCalls[0].Function.Name)
+	assert.JSONEq(t, `{"city":"Paris"}`, string(result.ToolCalls[0].Function.Arguments))
+}
+
+func TestOllamaLLM_Chat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/chat", r.URL.Path)
+
+		var req ollamaChatRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, []ollamaMessage{
+			{Role: "system", Content: "Be terse."},
+			{Role: "user", Content: "Hi"},
+			{Role: "assistant", Content: "Hello!"},
+			{Role: "user", Content: "Capital of France?"},
+		}, req.Messages)
+		assert.False(t, req.Stream)
+
+		w.Write([]byte(`{"message":{"role":"assistant","content":"Paris."},"done":true}`))
+	}))
+	defer server.Close()
+
+	llm := NewOllamaLLM(server.URL, "test-model")
+	system := "Be terse."
+	messages := []types.Message{
+		{Role: "user", Content: "Hi"},
+		{Role: "assistant", Content: "Hello!"},
+		{Role: "user", Content: "Capital of France?"},
+	}
+	result, err := llm.Chat(context.Background(), messages, Options{System: &system})
+	assert.NoError(t, err)
+	assert.Equal(t, "Paris.", result.Response)
+	assert.Nil(t, result.Usage) // no counts were reported
+}
+
+func TestOllamaLLM_Embeddings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/embeddings", r.URL.Path)
+
+		var req ollamaEmbeddingsRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, ollamaEmbeddingsRequest{Model: "test-model", Prompt: "hello"}, req)
+
+		w.Write([]byte(`{"embedding":[0.1,-0.2,0.3]}`))
+	}))
+	defer server.Close()
+
+	llm := NewOllamaLLM(server.URL, "test-model")
+	vector, err := llm.Embeddings(context.Background(), "hello")
 	assert.NoError(t, err)
-	assert.Equal(t, "test response", response)
+	assert.Equal(t, []float64{0.1, -0.2, 0.3}, vector)
 }
 
 func TestOllamaLLM_GenerateStream(t *testing.T) {
@@ -63,7 +299,9 @@ func TestOllamaLLM_GenerateStream(t *testing.T) {
 		// Send streamed responses
 		responses := []ollamaResponse{
 			{Response: "test", Done: false},
-			{Response: " response", Done: true},
+			{Response: " response", Done: true, ollamaCounts: ollamaCounts{
+				PromptEvalCount: 12, EvalCount: 2, TotalDuration: 5e8, LoadDuration: 1e6, PromptEvalDuration: 2e7, EvalDuration: 4e8,
+			}},
 		}
 
 		for _, resp := range responses {
@@ -79,9 +317,41 @@ func TestOllamaLLM_GenerateStream(t *testing.T) {
 
 	// Test streaming
 	var buf bytes.Buffer
-	err := llm.GenerateStream(ctx, "test prompt", &buf)
+	report := &UsageReport{}
+	err := llm.GenerateStream(WithUsageReport(ctx, report), "test prompt", Options{}, &buf)
 	assert.NoError(t, err)
 	assert.Equal(t, "test response", buf.String())
+
+	// The counts and timings come from the final message
+	assert.Equal(t, &Usage{
+		PromptTokens:       12,
+		CompletionTokens:   2,
+		TotalDuration:      500 * time.Millisecond,
+		LoadDuration:       time.Millisecond,
+		PromptEvalDuration: 20 * time.Millisecond,
+		EvalDuration:       400 * time.Millisecond,
+	}, report.Usage())
+}
+
+func TestOllamaLLM_GenerateStreamCancelled(t *testing.T) {
+	// Send one token, then hang until the client goes away
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ollamaResponse{Response: "test"})
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	llm := NewOllamaLLM(server.URL, "test-model")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var buf bytes.Buffer
+	start := time.Now()
+	err := llm.GenerateStream(ctx, "test prompt", Options{}, &buf)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, "test", buf.String())
 }
 
 func TestOllamaLLM_GenerateError(t *testing.T) {
@@ -97,13 +367,149 @@ func TestOllamaLLM_GenerateError(t *testing.T) {
 	ctx := context.Background()
 
 	// Test generation error
-	_, err := llm.Generate(ctx, "test prompt")
+	_, err := llm.Generate(ctx, "test prompt", Options{})
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "unexpected status code: 500")
 
 	// Test streaming error
 	var buf bytes.Buffer
-	err = llm.GenerateStream(ctx, "test prompt", &buf)
+	err = llm.GenerateStream(ctx, "test prompt", Options{}, &buf)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "unexpected status code: 500")
 }
+
+func TestOllamaLLM_Ping(t *testing.T) {
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/version", r.URL.Path)
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"version":"0.1.32"}`))
+	}))
+	defer server.Close()
+
+	llm := NewOllamaLLM(server.URL, "test-model")
+	assert.NoError(t, llm.Ping(context.Background()))
+
+	healthy = false
+	assert.ErrorContains(t, llm.Ping(context.Background()), "503")
+
+	// An unreachable server fails rather than hanging
+	server.Close()
+	assert.ErrorContains(t, llm.Ping(context.Background()), "failed to reach Ollama")
+}
+
+func TestOllamaLLM_ListModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/tags", r.URL.Path)
+		assert.Equal(t, "GET", r.Method)
+		w.Write([]byte(`{"models":[{"name":"llama2:latest","size":3825819519,"digest":"abc"},{"name":"smollm:135m","size":91739413}]}`))
+	}))
+	defer server.Close()
+
+	llm := NewOllamaLLM(server.URL, "test-model")
+	models, err := llm.ListModels(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []ModelInfo{
+		{Name: "llama2:latest", Size: 3825819519},
+		{Name: "smollm:135m", Size: 91739413},
+	}, models)
+}
+
+func TestOllamaLLM_ListModelsNotFound(t *testing.T) {
+	// Older Ollama versions have no /api/tags
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	llm := NewOllamaLLM(server.URL, "test-model")
+	models, err := llm.ListModels(context.Background())
+	assert.ErrorIs(t, err, ErrModelListingUnsupported)
+	assert.Empty(t, models)
+	assert.NotNil(t, models)
+}
+
+func TestOllamaLLM_ReusesConnections(t *testing.T) {
+	var conns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ollamaResponse{Response: "ok", Done: true})
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	llm := NewOllamaLLM(server.URL, "test-model")
+	for i := 0; i < 3; i++ {
+		_, err := llm.Generate(context.Background(), "test prompt", Options{})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&conns))
+}
+
+func TestOllamaLLM_ContextLengthExceeded(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"input length (5000) exceeds maximum context length (4096)"}`))
+	}))
+	defer server.Close()
+
+	llm := NewOllamaLLM(server.URL, "test-model")
+
+	_, err := llm.Generate(context.Background(), "test prompt", Options{})
+	var contextErr *ContextLengthError
+	if assert.ErrorAs(t, err, &contextErr) {
+		assert.Equal(t, 4096, contextErr.Limit)
+	}
+
+	err = llm.GenerateStream(context.Background(), "test prompt", Options{}, &bytes.Buffer{})
+	assert.ErrorAs(t, err, &contextErr)
+}
+
+func TestOllamaLLM_CheckContextWindow(t *testing.T) {
+	var shows, generates int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/show":
+			atomic.AddInt32(&shows, 1)
+			w.Write([]byte(`{"model_info":{"general.architecture":"llama","llama.context_length":16}}`))
+		case "/api/generate":
+			atomic.AddInt32(&generates, 1)
+			json.NewEncoder(w).Encode(ollamaResponse{Response: "ok", Done: true})
+		}
+	}))
+	defer server.Close()
+
+	llm := NewOllamaLLM(server.URL, "test-model")
+	llm.checkContext = true
+
+	// A short prompt fits
+	_, err := llm.Generate(context.Background(), "short prompt", Options{})
+	assert.NoError(t, err)
+
+	// A prompt of about 25 tokens is rejected before reaching the backend
+	_, err = llm.Generate(context.Background(), strings.Repeat("word ", 20), Options{})
+	var contextErr *ContextLengthError
+	if assert.ErrorAs(t, err, &contextErr) {
+		assert.Equal(t, 16, contextErr.Limit)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&generates))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&shows)) // looked up once per model
+
+	// OLLAMA_NUM_CTX is the window the model actually gets, so it wins over
+	// the model's own context length without asking /api/show
+	llm = NewOllamaLLM(server.URL, "other-model")
+	llm.checkContext = true
+	llm.numCtx = 32
+	_, err = llm.Generate(context.Background(), strings.Repeat("word ", 20), Options{})
+	assert.NoError(t, err, "fits the larger configured window")
+	_, err = llm.Generate(context.Background(), strings.Repeat("word ", 40), Options{})
+	if assert.ErrorAs(t, err, &contextErr) {
+		assert.Equal(t, 32, contextErr.Limit)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&shows))
+}
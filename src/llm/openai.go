@@ -0,0 +1,270 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"minivault/src/types"
+)
+
+// OpenAILLM talks to servers exposing the OpenAI chat completions API,
+// such as vLLM
+type OpenAILLM struct {
+	baseURL string
+	model   string
+	apiKey  string
+}
+
+type openAIChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+	Tools    []types.Tool    `json:"tools,omitempty"`
+	Stop     []string        `json:"stop,omitempty"`
+	Stream   bool            `json:"stream"`
+
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+}
+
+type openAIMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
+}
+
+// openAIToolCall differs from types.ToolCall in carrying the arguments as
+// a JSON-encoded string
+type openAIToolCall struct {
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIChatResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+	} `json:"choices"`
+}
+
+type openAIModelsResponse struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
+type openAIStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+func NewOpenAILLM(baseURL, model, apiKey string) *OpenAILLM {
+	return &OpenAILLM{
+		baseURL: baseURL,
+		model:   model,
+		apiKey:  apiKey,
+	}
+}
+
+// Generate sends the prompt as a single user message
+func (l *OpenAILLM) Generate(ctx context.Context, prompt string, opts Options) (*Result, error) {
+	return l.Chat(ctx, []types.Message{{Role: "user", Content: prompt}}, opts)
+}
+
+// Chat sends the conversation as a chat completion
+func (l *OpenAILLM) Chat(ctx context.Context, messages []types.Message, opts Options) (*Result, error) {
+	resp, err := l.post(ctx, l.chatRequest(messages, opts, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result openAIChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if len(result.Choices) == 0 {
+		return nil, fmt.Errorf("response has no choices")
+	}
+
+	message := result.Choices[0].Message
+	return &Result{
+		Response:  message.Content,
+		ToolCalls: toToolCalls(message.ToolCalls),
+	}, nil
+}
+
+// GenerateStream reads the server-sent events of a streamed completion and
+// writes each content delta
+func (l *OpenAILLM) GenerateStream(ctx context.Context, prompt string, opts Options, writer io.Writer) error {
+	resp, err := l.post(ctx, l.chatRequest([]types.Message{{Role: "user", Content: prompt}}, opts, true))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue // blank separators, comments and other SSE fields
+		}
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("failed to decode stream: %v", err)
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(writer, "%s", chunk.Choices[0].Delta.Content); err != nil {
+			return fmt.Errorf("failed to write response: %w", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err() // the caller cancelled, e.g. the client went away
+		}
+		return fmt.Errorf("failed to read stream: %v", err)
+	}
+
+	return nil
+}
+
+// Ping checks that the server answers /v1/models with the configured key
+func (l *OpenAILLM) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, PingTimeout)
+	defer cancel()
+
+	resp, err := l.getModels(ctx)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ListModels returns the models served by the server. The OpenAI API does
+// not report sizes.
+func (l *OpenAILLM) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	resp, err := l.getModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result openAIModelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	models := make([]ModelInfo, 0, len(result.Data))
+	for _, model := range result.Data {
+		models = append(models, ModelInfo{Name: model.ID})
+	}
+	return models, nil
+}
+
+// getModels requests /v1/models, returning the response when the status is
+// 200 OK. The caller must close the body.
+func (l *OpenAILLM) getModels(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", l.baseURL+"/v1/models", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+l.apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach OpenAI server: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (l *OpenAILLM) chatRequest(messages []types.Message, opts Options, stream bool) openAIChatRequest {
+	model := l.model
+	if opts.Model != "" {
+		model = opts.Model
+	}
+	var chat []openAIMessage
+	if system := opts.SystemPrompt(); system != "" {
+		chat = append(chat, openAIMessage{Role: "system", Content: system})
+	}
+	for _, message := range messages {
+		chat = append(chat, openAIMessage{Role: message.Role, Content: message.Content})
+	}
+	return openAIChatRequest{
+		Model:    model,
+		Messages: chat,
+		Tools:    opts.Tools,
+		Stop:     opts.Stop,
+		Stream:   stream,
+
+		Temperature: opts.Temperature,
+		TopP:        opts.TopP,
+		MaxTokens:   opts.MaxTokens,
+		Seed:        opts.Seed,
+	}
+}
+
+// toToolCalls converts OpenAI tool calls, keeping arguments that aren't
+// valid JSON as a JSON string so they still reach the client
+func toToolCalls(calls []openAIToolCall) []types.ToolCall {
+	var toolCalls []types.ToolCall
+	for _, call := range calls {
+		arguments := json.RawMessage(call.Function.Arguments)
+		if !json.Valid(arguments) {
+			arguments, _ = json.Marshal(call.Function.Arguments)
+		}
+		toolCalls = append(toolCalls, types.ToolCall{
+			Function: types.ToolCallFunction{Name: call.Function.Name, Arguments: arguments},
+		})
+	}
+	return toolCalls
+}
+
+// post sends a chat completion request and returns the response when the
+// status is 200 OK. The caller must close the body.
+func (l *OpenAILLM) post(ctx context.Context, body openAIChatRequest) (*http.Response, error) {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", l.baseURL+"/v1/chat/completions", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+l.apiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	recordTransfer(ctx, len(jsonBody), resp)
+	return resp, nil
+}
@@ -0,0 +1,164 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"minivault/src/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAILLM_Generate(t *testing.T) {
+	// Create test server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Verify request
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+
+		// Parse request body
+		var req openAIChatRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		assert.NoError(t, err)
+		assert.Equal(t, "test-model", req.Model)
+		assert.Equal(t, []openAIMessage{{Role: "user", Content: "test prompt"}}, req.Messages)
+		assert.Equal(t, []string{"END"}, req.Stop)
+		assert.False(t, req.Stream)
+
+		// Send response
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"test response"}}]}`))
+	}))
+	defer server.Close()
+
+	// Create LLM with test server URL
+	llm := NewOpenAILLM(server.URL, "test-model", "test-key")
+	ctx := context.Background()
+
+	// Test generation
+	result, err := llm.Generate(ctx, "test prompt", Options{Stop: []string{"END"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "test response", result.Response)
+}
+
+func TestOpenAILLM_GenerateSystem(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIChatRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, []openAIMessage{
+			{Role: "system", Content: "Be terse."},
+			{Role: "user", Content: "test prompt"},
+		}, req.Messages)
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`))
+	}))
+	defer server.Close()
+
+	llm := NewOpenAILLM(server.URL, "test-model", "test-key")
+	system := "Be terse."
+	_, err := llm.Generate(context.Background(), "test prompt", Options{System: &system})
+	assert.NoError(t, err)
+}
+
+func TestOpenAILLM_Chat(t *testing.T) {
+	messages := []types.Message{
+		{Role: "user", Content: "Hi"},
+		{Role: "assistant", Content: "Hello!"},
+		{Role: "user", Content: "Capital of France?"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIChatRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, []openAIMessage{
+			{Role: "user", Content: "Hi"},
+			{Role: "assistant", Content: "Hello!"},
+			{Role: "user", Content: "Capital of France?"},
+		}, req.Messages)
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"Paris."}}]}`))
+	}))
+	defer server.Close()
+
+	llm := NewOpenAILLM(server.URL, "test-model", "test-key")
+	result, err := llm.Chat(context.Background(), messages, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Paris.", result.Response)
+}
+
+func TestOpenAILLM_GenerateWithTools(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIChatRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Len(t, req.Tools, 1)
+
+		// OpenAI encodes arguments as a JSON string
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[` +
+			`{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]}}]}`))
+	}))
+	defer server.Close()
+
+	llm := NewOpenAILLM(server.URL, "test-model", "test-key")
+	tools := []types.Tool{{Type: "function", Function: types.ToolFunction{Name: "get_weather"}}}
+
+	result, err := llm.Generate(context.Background(), "weather in Paris?", Options{Tools: tools})
+	assert.NoError(t, err)
+	assert.Len(t, result.ToolCalls, 1)
+	assert.Equal(t, "get_weather", result.ToolCalls[0].Function.Name)
+	assert.JSONEq(t, `{"city":"Paris"}`, string(result.ToolCalls[0].Function.Arguments))
+}
+
+func TestOpenAILLM_GenerateStream(t *testing.T) {
+	// Create test server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIChatRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.True(t, req.Stream)
+
+		// Send server-sent events, including a role-only first delta
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, delta := range []string{`{"role":"assistant"}`, `{"content":"test"}`, `{"content":" response"}`} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":%s}]}\n\n", delta)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	// Create LLM with test server URL
+	llm := NewOpenAILLM(server.URL, "test-model", "test-key")
+
+	// Test streaming
+	var buf bytes.Buffer
+	err := llm.GenerateStream(context.Background(), "test prompt", Options{}, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "test response", buf.String())
+}
+
+func TestOpenAILLM_GenerateError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	llm := NewOpenAILLM(server.URL, "test-model", "bad-key")
+	_, err := llm.Generate(context.Background(), "test prompt", Options{})
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestOpenAILLM_ListModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/models", r.URL.Path)
+		assert.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"object":"list","data":[{"id":"meta-llama/Llama-3-8B","object":"model"}]}`))
+	}))
+	defer server.Close()
+
+	llm := NewOpenAILLM(server.URL, "test-model", "test-key")
+	models, err := llm.ListModels(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []ModelInfo{{Name: "meta-llama/Llama-3-8B"}}, models)
+	assert.NoError(t, llm.Ping(context.Background()))
+}
@@ -2,30 +2,211 @@ package llm
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"math"
+	"math/rand/v2"
 	"time"
+	"unicode"
+
+	"minivault/src/types"
 )
 
-type StubLLM struct{}
+// StubToolCallPrompt makes the stub answer with a canned call to the first
+// provided tool instead of text, so tool-calling clients can be tested offline
+const StubToolCallPrompt = "__stub_tool_call__"
+
+// DefaultStubEmbeddingDimension is the length of the stub's fake embeddings
+const DefaultStubEmbeddingDimension = 16
+
+// DemoStubTokenDelay paces the stub's streams like a slow model, for demos.
+// It's selected with the "demo" token delay.
+const DemoStubTokenDelay = 100 * time.Millisecond
+
+type StubLLM struct {
+	// CountTokens sizes the prompt and response for the reported usage
+	CountTokens func(text string) int
+
+	// EmbeddingDimension is the length of the vectors Embeddings returns
+	EmbeddingDimension int
+
+	// Respond produces the response to a prompt, or to a chat's last user
+	// message; nil echoes it. See NewStubResponder.
+	Respond func(prompt string) string
+
+	// TokenDelay is the pause after each streamed token; 0 streams as fast
+	// as the client reads
+	TokenDelay time.Duration
+}
+
+// ParseStubTokenDelay parses a token delay such as "20ms", or "demo" for
+// DemoStubTokenDelay
+func ParseStubTokenDelay(raw string) (time.Duration, error) {
+	if raw == "demo" {
+		return DemoStubTokenDelay, nil
+	}
+	delay, err := time.ParseDuration(raw)
+	if err != nil || delay < 0 {
+		return 0, fmt.Errorf("%q is not a non-negative duration or \"demo\"", raw)
+	}
+	return delay, nil
+}
 
 func NewStubLLM() *StubLLM {
-	return &StubLLM{}
+	return &StubLLM{CountTokens: estimateTokens, EmbeddingDimension: DefaultStubEmbeddingDimension}
+}
+
+func (l *StubLLM) Generate(_ context.Context, prompt string, opts Options) (*Result, error) {
+	if prompt == StubToolCallPrompt && len(opts.Tools) > 0 {
+		name := opts.Tools[0].Function.Name
+		return &Result{
+			ToolCalls: []types.ToolCall{{
+				Function: types.ToolCallFunction{
+					Name:      name,
+					Arguments: json.RawMessage(`{}`),
+				},
+			}},
+			Usage: l.usage(prompt, name+"{}"),
+		}, nil
+	}
+	response := stubPrefix(opts) + stubEcho(prompt)
+	if l.Respond != nil {
+		response = stubPrefix(opts) + l.Respond(prompt)
+	}
+	return &Result{Response: response, Usage: l.usage(prompt, response)}, nil
 }
 
-func (l *StubLLM) Generate(_ context.Context, prompt string) (string, error) {
-	return fmt.Sprintf("This is a stubbed response to your prompt: %s", prompt), nil
+// Chat echoes the last user message
+func (l *StubLLM) Chat(_ context.Context, messages []types.Message, opts Options) (*Result, error) {
+	var last string
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			last = messages[i].Content
+			break
+		}
+	}
+	response := stubPrefix(opts) + fmt.Sprintf("This is a stubbed response to your message: %s", last)
+	if l.Respond != nil {
+		response = stubPrefix(opts) + l.Respond(last)
+	}
+	return &Result{Response: response, Usage: l.usage(last, response)}, nil
 }
 
-func (l *StubLLM) GenerateStream(_ context.Context, prompt string, writer io.Writer) error {
-	words := []string{"This", "is", "a", "stubbed", "streaming", "response", "to", "your", "prompt:", prompt}
+// usage reports token counts like a real backend would
+func (l *StubLLM) usage(prompt, completion string) *Usage {
+	countTokens := l.CountTokens
+	if countTokens == nil {
+		countTokens = estimateTokens
+	}
+	return &Usage{
+		PromptTokens:     countTokens(prompt),
+		CompletionTokens: countTokens(completion),
+	}
+}
+
+// stubPrefix echoes the seed and system prompt ahead of the stub's response
+func stubPrefix(opts Options) string {
+	var prefix string
+	if opts.Seed != nil {
+		prefix = fmt.Sprintf("[seed: %d] ", *opts.Seed)
+	}
+	if system := opts.SystemPrompt(); system != "" {
+		prefix += fmt.Sprintf("[system: %s] ", system)
+	}
+	return prefix
+}
 
-	for _, word := range words {
-		if _, err := fmt.Fprintf(writer, "%s\n", word); err != nil {
+func (l *StubLLM) GenerateStream(ctx context.Context, prompt string, opts Options, writer io.Writer) error {
+	if prefix := stubPrefix(opts); prefix != "" {
+		if _, err := io.WriteString(writer, prefix); err != nil {
 			return err
 		}
-		time.Sleep(100 * time.Millisecond) // Simulate streaming delay
+	}
+	var chunks []string
+	if l.Respond != nil {
+		// Keep the whitespace, so line breaks and code blocks survive
+		chunks = splitAfterWords(l.Respond(prompt))
+	} else {
+		for _, word := range []string{"This", "is", "a", "stubbed", "streaming", "response", "to", "your", "prompt:"} {
+			chunks = append(chunks, word+"\n")
+		}
+		// One token per word of the prompt, so longer prompts stream longer
+		if words := splitAfterWords(prompt); len(words) > 0 {
+			words[len(words)-1] += "\n"
+			chunks = append(chunks, words...)
+		}
+	}
+
+	for _, chunk := range chunks {
+		if _, err := io.WriteString(writer, chunk); err != nil {
+			return err
+		}
+		// Simulate streaming delay, stopping early if the caller cancels
+		if l.TokenDelay <= 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.TokenDelay):
+		}
 	}
 
 	return nil
 }
+
+// splitAfterWords splits s into words, each with the whitespace after it
+func splitAfterWords(s string) []string {
+	var chunks []string
+	start, afterSpace := 0, false
+	for i, r := range s {
+		if afterSpace && !unicode.IsSpace(r) {
+			chunks = append(chunks, s[start:i])
+			start = i
+		}
+		afterSpace = unicode.IsSpace(r)
+	}
+	if start < len(s) {
+		chunks = append(chunks, s[start:])
+	}
+	return chunks
+}
+
+// Embeddings returns a fake unit vector derived from a hash of input, so
+// the same input always gets the same vector
+func (l *StubLLM) Embeddings(_ context.Context, input string) ([]float64, error) {
+	dimension := l.EmbeddingDimension
+	if dimension <= 0 {
+		dimension = DefaultStubEmbeddingDimension
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(input))
+	random := rand.New(rand.NewPCG(hash.Sum64(), 0))
+
+	vector := make([]float64, dimension)
+	var norm float64
+	for i := range vector {
+		vector[i] = 2*random.Float64() - 1
+		norm += vector[i] * vector[i]
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] /= norm
+	}
+	return vector, nil
+}
+
+// Ping always succeeds; the stub has no dependencies
+func (l *StubLLM) Ping(_ context.Context) error {
+	return nil
+}
+
+// ListModels reports the stub as the only model
+func (l *StubLLM) ListModels(_ context.Context) ([]ModelInfo, error) {
+	return []ModelInfo{{Name: "stub"}}, nil
+}
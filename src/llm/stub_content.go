@@ -0,0 +1,142 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Stub modes, selecting what the stub answers with
+const (
+	StubModeEcho     = "echo"     // "This is a stubbed response to your prompt: ..."
+	StubModeCanned   = "canned"   // responses from a file, by prompt pattern
+	StubModeLorem    = "lorem"    // lorem ipsum of a fixed number of words
+	StubModeMarkdown = "markdown" // markdown with headings, lists, a table and code blocks
+)
+
+// DefaultStubLoremWords is the length of lorem ipsum responses
+const DefaultStubLoremWords = 100
+
+// StubResponse maps a prompt pattern to a canned stub response
+type StubResponse struct {
+	Pattern  string `json:"pattern"` // regular expression matched against the prompt
+	Response string `json:"response"`
+}
+
+// NewStubResponder returns the stub's responder for mode, or nil for the
+// default echo. Canned responses are read from responsesPath and lorem
+// ipsum responses are loremWords long.
+func NewStubResponder(mode, responsesPath string, loremWords int) (func(prompt string) string, error) {
+	switch mode {
+	case "", StubModeEcho:
+		return nil, nil
+	case StubModeCanned:
+		return LoadStubResponses(responsesPath)
+	case StubModeLorem:
+		if loremWords <= 0 {
+			loremWords = DefaultStubLoremWords
+		}
+		return func(string) string { return loremIpsum(loremWords) }, nil
+	case StubModeMarkdown:
+		return stubMarkdown, nil
+	default:
+		return nil, fmt.Errorf("unknown stub mode %q (available: echo, canned, lorem, markdown)", mode)
+	}
+}
+
+// LoadStubResponses reads a JSON array of StubResponse from path. The
+// responder answers with the first entry whose pattern matches the prompt,
+// and echoes prompts that match none.
+func LoadStubResponses(path string) (func(prompt string) string, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no stub responses file set")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stub responses: %v", err)
+	}
+	var entries []StubResponse
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse stub responses: %v", err)
+	}
+
+	patterns := make([]*regexp.Regexp, len(entries))
+	for i, entry := range entries {
+		if patterns[i], err = regexp.Compile(entry.Pattern); err != nil {
+			return nil, fmt.Errorf("stub response %d has an invalid pattern: %v", i, err)
+		}
+	}
+	return func(prompt string) string {
+		for i, pattern := range patterns {
+			if pattern.MatchString(prompt) {
+				return entries[i].Response
+			}
+		}
+		return stubEcho(prompt)
+	}, nil
+}
+
+// stubEcho is the stub's default response
+func stubEcho(prompt string) string {
+	return fmt.Sprintf("This is a stubbed response to your prompt: %s", prompt)
+}
+
+// loremWords is the text lorem ipsum responses cycle through
+var loremWords = strings.Fields(`lorem ipsum dolor sit amet consectetur adipiscing elit sed do
+	eiusmod tempor incididunt ut labore et dolore magna aliqua ut enim ad minim veniam quis
+	nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat duis aute irure
+	dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur`)
+
+// loremIpsum returns n words of lorem ipsum in sentences of ten words, with
+// a paragraph break every five sentences
+func loremIpsum(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		word := loremWords[i%len(loremWords)]
+		switch {
+		case i == 0:
+		case i%50 == 0:
+			b.WriteString("\n\n")
+		default:
+			b.WriteString(" ")
+		}
+		if i%10 == 0 {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		b.WriteString(word)
+		if i%10 == 9 || i == n-1 {
+			b.WriteString(".")
+		}
+	}
+	return b.String()
+}
+
+// stubMarkdown returns a document using the common markdown constructs,
+// so clients can check how they render
+func stubMarkdown(prompt string) string {
+	return fmt.Sprintf("# Stubbed response\n\n"+
+		"You asked: *%s*\n\n"+
+		"## A list\n\n"+
+		"- **Bold** and _italic_ text\n"+
+		"- `inline code` and a [link](https://example.com)\n"+
+		"- A nested list:\n"+
+		"  1. First\n"+
+		"  2. Second\n\n"+
+		"## A table\n\n"+
+		"| Backend | Streaming |\n"+
+		"|---------|-----------|\n"+
+		"| ollama  | yes       |\n"+
+		"| stub    | yes       |\n\n"+
+		"## Code\n\n"+
+		"```go\n"+
+		"func main() {\n"+
+		"\tfmt.Println(\"Hello, MiniVault\")\n"+
+		"}\n"+
+		"```\n\n"+
+		"```json\n"+
+		"{\"response\": \"stubbed\"}\n"+
+		"```\n\n"+
+		"> A blockquote to finish.\n", prompt)
+}
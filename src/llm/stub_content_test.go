@@ -0,0 +1,111 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"minivault/src/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeStubResponses writes a stub responses file into a temp directory
+func writeStubResponses(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "responses.json")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestNewStubResponder_Canned(t *testing.T) {
+	path := writeStubResponses(t, `[
+		{"pattern": "(?i)weather", "response": "Sunny, 21°C."},
+		{"pattern": "^list", "response": "- one\n- two\n"}
+	]`)
+	respond, err := NewStubResponder(StubModeCanned, path, 0)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Sunny, 21°C.", respond("What's the WEATHER like?"))
+	assert.Equal(t, "- one\n- two\n", respond("list things"))
+	assert.Equal(t, "This is a stubbed response to your prompt: hello", respond("hello"))
+}
+
+func TestNewStubResponder_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		path    string
+		wantErr string
+	}{
+		{name: "Unknown mode", mode: "haiku", wantErr: `unknown stub mode "haiku"`},
+		{name: "No file", mode: StubModeCanned, wantErr: "no stub responses file set"},
+		{name: "Missing file", mode: StubModeCanned, path: "missing.json", wantErr: "failed to read stub responses"},
+		{name: "Invalid pattern", mode: StubModeCanned, path: writeStubResponses(t, `[{"pattern":"(","response":"x"}]`), wantErr: "stub response 0 has an invalid pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewStubResponder(tt.mode, tt.path, 0)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestNewStubResponder_Lorem(t *testing.T) {
+	respond, err := NewStubResponder(StubModeLorem, "", 60)
+	assert.NoError(t, err)
+
+	response := respond("anything")
+	assert.Len(t, strings.Fields(response), 60)
+	assert.True(t, strings.HasPrefix(response, "Lorem ipsum dolor sit amet consectetur adipiscing elit sed do."))
+	assert.Contains(t, response, ".\n\n") // a paragraph break after 50 words
+	assert.Equal(t, response, respond("something else"))
+
+	respond, _ = NewStubResponder(StubModeLorem, "", 0)
+	assert.Len(t, strings.Fields(respond("")), DefaultStubLoremWords)
+}
+
+func TestNewStubResponder_Markdown(t *testing.T) {
+	respond, err := NewStubResponder(StubModeMarkdown, "", 0)
+	assert.NoError(t, err)
+
+	response := respond("render me")
+	assert.Contains(t, response, "You asked: *render me*")
+	assert.Contains(t, response, "```go\n")
+	assert.Contains(t, response, "| Backend | Streaming |")
+}
+
+func TestStubLLM_Respond(t *testing.T) {
+	llm := NewStubLLM()
+	llm.Respond = func(prompt string) string { return "## Title\n\nSome `code` for " + prompt }
+	ctx := context.Background()
+
+	result, err := llm.Generate(ctx, "you", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "## Title\n\nSome `code` for you", result.Response)
+
+	result, err = llm.Chat(ctx, []types.Message{{Role: "user", Content: "chat"}}, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "## Title\n\nSome `code` for chat", result.Response)
+
+	// Streamed word by word, with the formatting intact
+	var buf bytes.Buffer
+	recorder := &writeRecorder{next: &buf}
+	assert.NoError(t, llm.GenerateStream(ctx, "you", Options{}, recorder))
+	assert.Equal(t, "## Title\n\nSome `code` for you", buf.String())
+	assert.Equal(t, []string{"## ", "Title\n\n", "Some ", "`code` ", "for ", "you"}, recorder.writes)
+}
+
+// writeRecorder keeps each write passed through it
+type writeRecorder struct {
+	next   *bytes.Buffer
+	writes []string
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return w.next.Write(p)
+}
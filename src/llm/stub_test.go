@@ -3,7 +3,11 @@ package llm
 import (
 	"bytes"
 	"context"
+	"strings"
 	"testing"
+	"time"
+
+	"minivault/src/types"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -13,9 +17,95 @@ func TestStubLLM_Generate(t *testing.T) {
 	ctx := context.Background()
 	prompt := "test prompt"
 
-	response, err := llm.Generate(ctx, prompt)
+	result, err := llm.Generate(ctx, prompt, Options{})
+	assert.NoError(t, err)
+	assert.Contains(t, result.Response, prompt)
+}
+
+func TestStubLLM_GenerateSystem(t *testing.T) {
+	llm := NewStubLLM()
+	ctx := context.Background()
+	system := "Be terse."
+
+	result, err := llm.Generate(ctx, "test prompt", Options{System: &system})
+	assert.NoError(t, err)
+	assert.Equal(t, "[system: Be terse.] This is a stubbed response to your prompt: test prompt", result.Response)
+
+	var buf bytes.Buffer
+	err = llm.GenerateStream(ctx, "test prompt", Options{System: &system}, &buf)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "[system: Be terse.] This\n"))
+}
+
+func TestStubLLM_Chat(t *testing.T) {
+	llm := NewStubLLM()
+	messages := []types.Message{
+		{Role: "user", Content: "first question"},
+		{Role: "assistant", Content: "first answer"},
+		{Role: "user", Content: "second question"},
+	}
+
+	result, err := llm.Chat(context.Background(), messages, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "This is a stubbed response to your message: second question", result.Response)
+}
+
+func TestStubLLM_Embeddings(t *testing.T) {
+	llm := &StubLLM{EmbeddingDimension: 4}
+	ctx := context.Background()
+
+	first, err := llm.Embeddings(ctx, "hello")
+	assert.NoError(t, err)
+	assert.Len(t, first, 4)
+
+	// Vectors are unit length and stable for the same input
+	var norm float64
+	for _, value := range first {
+		norm += value * value
+	}
+	assert.InDelta(t, 1, norm, 1e-9)
+	again, _ := llm.Embeddings(ctx, "hello")
+	assert.Equal(t, first, again)
+
+	other, _ := llm.Embeddings(ctx, "goodbye")
+	assert.NotEqual(t, first, other)
+}
+
+func TestStubLLM_GenerateUsage(t *testing.T) {
+	llm := NewStubLLM()
+	ctx := context.Background()
+
+	result, err := llm.Generate(ctx, "test prompt", Options{})
+	assert.NoError(t, err)
+	if assert.NotNil(t, result.Usage) {
+		assert.Equal(t, estimateTokens("test prompt"), result.Usage.PromptTokens)
+		assert.Equal(t, estimateTokens(result.Response), result.Usage.CompletionTokens)
+		assert.Greater(t, result.Usage.PromptTokens, 0)
+		assert.Greater(t, result.Usage.CompletionTokens, 0)
+	}
+
+	// A custom tokenizer is used for both counts
+	llm.CountTokens = func(text string) int { return 7 }
+	result, err = llm.Generate(ctx, "test prompt", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, &Usage{PromptTokens: 7, CompletionTokens: 7}, result.Usage)
+}
+
+func TestStubLLM_GenerateToolCall(t *testing.T) {
+	llm := NewStubLLM()
+	ctx := context.Background()
+	tools := []types.Tool{{Type: "function", Function: types.ToolFunction{Name: "get_weather"}}}
+
+	result, err := llm.Generate(ctx, StubToolCallPrompt, Options{Tools: tools})
+	assert.NoError(t, err)
+	assert.Len(t, result.ToolCalls, 1)
+	assert.Equal(t, "get_weather", result.ToolCalls[0].Function.Name)
+
+	// Without tools the magic prompt is echoed like any other
+	result, err = llm.Generate(ctx, StubToolCallPrompt, Options{})
 	assert.NoError(t, err)
-	assert.Contains(t, response, prompt)
+	assert.Empty(t, result.ToolCalls)
+	assert.Contains(t, result.Response, StubToolCallPrompt)
 }
 
 func TestStubLLM_GenerateStream(t *testing.T) {
@@ -24,7 +114,51 @@ func TestStubLLM_GenerateStream(t *testing.T) {
 	prompt := "test prompt"
 	var buf bytes.Buffer
 
-	err := llm.GenerateStream(ctx, prompt, &buf)
+	err := llm.GenerateStream(ctx, prompt, Options{}, &buf)
 	assert.NoError(t, err)
 	assert.Contains(t, buf.String(), prompt)
 }
+
+func TestStubLLM_GenerateStreamTokens(t *testing.T) {
+	llm := NewStubLLM()
+	prompt := strings.Repeat("word ", 200)
+	recorder := &writeRecorder{next: &bytes.Buffer{}}
+
+	// Without a delay, even a long stream is quick
+	start := time.Now()
+	assert.NoError(t, llm.GenerateStream(context.Background(), prompt, Options{}, recorder))
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// Nine words of preamble, then one token per word of the prompt
+	assert.Len(t, recorder.writes, 9+200)
+	assert.Equal(t, "This\nis\na\nstubbed\nstreaming\nresponse\nto\nyour\nprompt:\n"+prompt+"\n", recorder.next.String())
+}
+
+func TestParseStubTokenDelay(t *testing.T) {
+	delay, err := ParseStubTokenDelay("demo")
+	assert.NoError(t, err)
+	assert.Equal(t, DemoStubTokenDelay, delay)
+
+	delay, err = ParseStubTokenDelay("0")
+	assert.NoError(t, err)
+	assert.Zero(t, delay)
+
+	_, err = ParseStubTokenDelay("-1s")
+	assert.Error(t, err)
+	_, err = ParseStubTokenDelay("slow")
+	assert.Error(t, err)
+}
+
+func TestStubLLM_GenerateStreamCancelled(t *testing.T) {
+	llm := NewStubLLM()
+	llm.TokenDelay = DemoStubTokenDelay
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var buf bytes.Buffer
+
+	start := time.Now()
+	err := llm.GenerateStream(ctx, "test prompt", Options{}, &buf)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, "This\n", buf.String())
+}
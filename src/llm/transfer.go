@@ -0,0 +1,55 @@
+package llm
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// Transfer accumulates the bytes sent to and received from a backend over
+// the course of a request, including retries
+type Transfer struct {
+	requestBytes  atomic.Int64
+	responseBytes atomic.Int64
+}
+
+// RequestBytes returns the total size of the request bodies sent
+func (t *Transfer) RequestBytes() int64 {
+	return t.requestBytes.Load()
+}
+
+// ResponseBytes returns the total size of the response bodies read
+func (t *Transfer) ResponseBytes() int64 {
+	return t.responseBytes.Load()
+}
+
+type transferKey struct{}
+
+// WithTransfer returns a context that records backend traffic into t
+func WithTransfer(ctx context.Context, t *Transfer) context.Context {
+	return context.WithValue(ctx, transferKey{}, t)
+}
+
+// recordTransfer counts a request body of n bytes and wraps the response
+// body so the bytes read from it are counted too
+func recordTransfer(ctx context.Context, n int, resp *http.Response) {
+	t, ok := ctx.Value(transferKey{}).(*Transfer)
+	if !ok {
+		return
+	}
+	t.requestBytes.Add(int64(n))
+	resp.Body = &countingBody{ReadCloser: resp.Body, count: &t.responseBytes}
+}
+
+// countingBody counts the bytes read through it
+type countingBody struct {
+	io.ReadCloser
+	count *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.count.Add(int64(n))
+	return n, err
+}
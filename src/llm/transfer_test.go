@@ -0,0 +1,63 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransfer_OllamaGenerate(t *testing.T) {
+	var received, sent int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = len(body)
+
+		response, _ := json.Marshal(ollamaResponse{Response: "test response", Done: true})
+		sent = len(response)
+		w.Write(response)
+	}))
+	defer server.Close()
+
+	llm := NewOllamaLLM(server.URL, "test-model")
+	transfer := &Transfer{}
+	ctx := WithTransfer(context.Background(), transfer)
+
+	_, err := llm.Generate(ctx, "test prompt", Options{})
+	assert.NoError(t, err)
+
+	// The counts match what the server saw on the wire
+	assert.Greater(t, transfer.RequestBytes(), int64(len("test prompt")))
+	assert.Equal(t, int64(received), transfer.RequestBytes())
+	assert.Equal(t, int64(sent), transfer.ResponseBytes())
+
+	// A second call, such as a retry, adds to the totals
+	_, err = llm.Generate(ctx, "test prompt", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2*received), transfer.RequestBytes())
+	assert.Equal(t, int64(2*sent), transfer.ResponseBytes())
+}
+
+func TestTransfer_OllamaGenerateStream(t *testing.T) {
+	var sent int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, resp := range []ollamaResponse{{Response: "test"}, {Response: " response", Done: true}} {
+			line, _ := json.Marshal(resp)
+			sent += len(line) + 1
+			w.Write(append(line, '\n'))
+		}
+	}))
+	defer server.Close()
+
+	transfer := &Transfer{}
+	ctx := WithTransfer(context.Background(), transfer)
+	err := NewOllamaLLM(server.URL, "test-model").GenerateStream(ctx, "test prompt", Options{}, &bytes.Buffer{})
+	assert.NoError(t, err)
+	assert.Greater(t, transfer.RequestBytes(), int64(0))
+	assert.Equal(t, int64(sent), transfer.ResponseBytes())
+}
@@ -0,0 +1,39 @@
+package llm
+
+import (
+	"context"
+	"sync"
+)
+
+// UsageReport receives the usage a backend reports at the end of a
+// stream, where there is no Result to carry it
+type UsageReport struct {
+	mu    sync.Mutex
+	usage *Usage
+}
+
+// Usage returns the last usage reported, or nil when the backend sent none
+func (r *UsageReport) Usage() *Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.usage
+}
+
+type usageReportKey struct{}
+
+// WithUsageReport returns a context that records streamed usage into r
+func WithUsageReport(ctx context.Context, r *UsageReport) context.Context {
+	return context.WithValue(ctx, usageReportKey{}, r)
+}
+
+// reportUsage records usage for the stream served under ctx. A retried or
+// fallen back stream replaces what an earlier attempt reported.
+func reportUsage(ctx context.Context, usage *Usage) {
+	r, ok := ctx.Value(usageReportKey{}).(*UsageReport)
+	if !ok || usage == nil {
+		return
+	}
+	r.mu.Lock()
+	r.usage = usage
+	r.mu.Unlock()
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// JitterStrategy randomizes retry delays so clients that failed together
+// don't all retry together
+type JitterStrategy string
+
+const (
+	JitterNone         JitterStrategy = "none"         // the exponential delay as is
+	JitterFull         JitterStrategy = "full"         // uniform in [0, delay]
+	JitterEqual        JitterStrategy = "equal"        // half the delay plus uniform in [0, delay/2]
+	JitterDecorrelated JitterStrategy = "decorrelated" // uniform in [base, 3 × previous delay]
+)
+
+const (
+	// DefaultRetryJitter is used when RETRY_JITTER is unset
+	DefaultRetryJitter = JitterFull
+
+	// DefaultRetryMaxBackoff caps retry delays when RETRY_MAX_BACKOFF is unset
+	DefaultRetryMaxBackoff = 10 * time.Second
+)
+
+// ParseJitterStrategy validates a RETRY_JITTER value
+func ParseJitterStrategy(name string) (JitterStrategy, error) {
+	switch strategy := JitterStrategy(name); strategy {
+	case JitterNone, JitterFull, JitterEqual, JitterDecorrelated:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown jitter strategy %q (available: none, full, equal, decorrelated)", name)
+}
+
+// Backoff computes exponential delays between retries of a backend call
+type Backoff struct {
+	Base   time.Duration // delay before the first retry; 0 retries immediately
+	Max    time.Duration // cap on any one delay; 0 means DefaultRetryMaxBackoff
+	Jitter JitterStrategy
+}
+
+// Delay returns how long to wait before retry number attempt, counting
+// from 0. prev is the previous delay, used by decorrelated jitter.
+func (b Backoff) Delay(attempt int, prev time.Duration) time.Duration {
+	if b.Base <= 0 {
+		return 0
+	}
+	maxDelay := b.Max
+	if maxDelay <= 0 {
+		maxDelay = DefaultRetryMaxBackoff
+	}
+
+	if b.Jitter == JitterDecorrelated {
+		upper := min(3*max(prev, b.Base), maxDelay)
+		if upper <= b.Base {
+			return upper
+		}
+		return b.Base + rand.N(upper-b.Base+1)
+	}
+
+	delay := b.Base
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+
+	switch b.Jitter {
+	case JitterFull:
+		return rand.N(delay + 1)
+	case JitterEqual:
+		return delay/2 + rand.N(delay/2+1)
+	default:
+		return delay
+	}
+}
+
+// retryWaiter sleeps the backoff delays of one request's retries
+type retryWaiter struct {
+	backoff Backoff
+	attempt int
+	prev    time.Duration
+}
+
+// wait sleeps before the next retry, returning early with the context's
+// error if it's cancelled
+func (w *retryWaiter) wait(ctx context.Context) error {
+	delay := w.backoff.Delay(w.attempt, w.prev)
+	w.attempt++
+	w.prev = delay
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff_FullJitter(t *testing.T) {
+	backoff := Backoff{Base: 100 * time.Millisecond, Max: time.Second, Jitter: JitterFull}
+
+	for attempt, ceiling := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second} {
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 50; i++ {
+			delay := backoff.Delay(attempt, 0)
+			assert.GreaterOrEqual(t, delay, time.Duration(0))
+			assert.LessOrEqual(t, delay, ceiling, "attempt %d", attempt)
+			seen[delay] = true
+		}
+		assert.Greater(t, len(seen), 1, "attempt %d delays should vary", attempt)
+	}
+}
+
+func TestBackoff_Strategies(t *testing.T) {
+	base := 100 * time.Millisecond
+
+	// No jitter doubles exactly up to the cap
+	none := Backoff{Base: base, Max: 300 * time.Millisecond, Jitter: JitterNone}
+	assert.Equal(t, base, none.Delay(0, 0))
+	assert.Equal(t, 200*time.Millisecond, none.Delay(1, 0))
+	assert.Equal(t, 300*time.Millisecond, none.Delay(5, 0))
+
+	// Equal jitter keeps at least half the delay
+	equal := Backoff{Base: base, Jitter: JitterEqual}
+	for i := 0; i < 50; i++ {
+		delay := equal.Delay(2, 0)
+		assert.GreaterOrEqual(t, delay, 200*time.Millisecond)
+		assert.LessOrEqual(t, delay, 400*time.Millisecond)
+	}
+
+	// Decorrelated jitter grows from the previous delay
+	decorrelated := Backoff{Base: base, Max: time.Second, Jitter: JitterDecorrelated}
+	for i := 0; i < 50; i++ {
+		delay := decorrelated.Delay(3, 200*time.Millisecond)
+		assert.GreaterOrEqual(t, delay, base)
+		assert.LessOrEqual(t, delay, 600*time.Millisecond)
+	}
+	assert.LessOrEqual(t, decorrelated.Delay(3, time.Hour), time.Second)
+
+	// Without a base delay retries are immediate
+	assert.Zero(t, Backoff{Jitter: JitterFull}.Delay(3, 0))
+}
+
+func TestParseJitterStrategy(t *testing.T) {
+	strategy, err := ParseJitterStrategy("decorrelated")
+	assert.NoError(t, err)
+	assert.Equal(t, JitterDecorrelated, strategy)
+
+	_, err = ParseJitterStrategy("random")
+	assert.ErrorContains(t, err, "unknown jitter strategy")
+}
+
+func TestRetryWaiter_Cancelled(t *testing.T) {
+	waiter := &retryWaiter{backoff: Backoff{Base: time.Hour, Jitter: JitterNone}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.ErrorIs(t, waiter.wait(ctx), context.Canceled)
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrBlockedContent is returned when a stream is cut off by the blocklist
+var ErrBlockedContent = errors.New("response blocked by content filter")
+
+// Blocklist matches text against a set of forbidden terms, case-insensitively
+type Blocklist struct {
+	terms  []string
+	window int // bytes of recent output that can hold the longest term
+}
+
+// NewBlocklist creates a blocklist of the given terms
+func NewBlocklist(terms []string) *Blocklist {
+	b := &Blocklist{}
+	for _, term := range terms {
+		if term = strings.ToLower(strings.TrimSpace(term)); term != "" {
+			b.terms = append(b.terms, term)
+			// Lowercasing keeps the rune count but not the byte count
+			b.window = max(b.window, utf8.RuneCountInString(term)*utf8.UTFMax)
+		}
+	}
+	return b
+}
+
+// LoadBlocklist reads one term per line from path, skipping blank lines and
+// lines starting with #
+func LoadBlocklist(path string) (*Blocklist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blocklist file: %v", err)
+	}
+	defer file.Close()
+
+	var terms []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		terms = append(terms, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read blocklist file: %v", err)
+	}
+	return NewBlocklist(terms), nil
+}
+
+// Match returns the first blocked term contained in text
+func (b *Blocklist) Match(text string) (string, bool) {
+	lower := strings.ToLower(text)
+	for _, term := range b.terms {
+		if strings.Contains(lower, term) {
+			return term, true
+		}
+	}
+	return "", false
+}
+
+// blockingWriter scans the stream output and refuses the chunk that
+// completes a blocked term, aborting the stream. Only the end of the output
+// that could hold the start of a term is kept and scanned again, so each
+// chunk costs the same however long the stream runs.
+type blockingWriter struct {
+	next      io.Writer
+	blocklist *Blocklist
+	tail      []byte // recent output, up to the blocklist's window
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.tail = append(w.tail, p...)
+	if _, blocked := w.blocklist.Match(string(w.tail)); blocked {
+		return 0, ErrBlockedContent
+	}
+	if excess := len(w.tail) - w.blocklist.window; excess > 0 {
+		w.tail = append(w.tail[:0], w.tail[excess:]...)
+	}
+	return w.next.Write(p)
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlocklist_Match(t *testing.T) {
+	blocklist := NewBlocklist([]string{"Secret", " ", "forbidden word"})
+
+	term, ok := blocklist.Match("this is a SECRET plan")
+	assert.True(t, ok)
+	assert.Equal(t, "secret", term)
+
+	_, ok = blocklist.Match("nothing to see here")
+	assert.False(t, ok)
+}
+
+func TestLoadBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("# banned\nbanana\n\nkumquat\n"), 0644))
+
+	blocklist, err := LoadBlocklist(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"banana", "kumquat"}, blocklist.terms)
+
+	_, err = LoadBlocklist(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
+
+func TestBlockingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writer := &blockingWriter{next: &buf, blocklist: NewBlocklist([]string{"banana"})}
+
+	_, err := writer.Write([]byte("I like "))
+	assert.NoError(t, err)
+
+	// A term split across chunks is caught when it completes
+	_, err = writer.Write([]byte("ban"))
+	assert.NoError(t, err)
+	_, err = writer.Write([]byte("ana bread"))
+	assert.ErrorIs(t, err, ErrBlockedContent)
+
+	assert.Equal(t, "I like ban", buf.String())
+}
+
+func TestBlockingWriter_ScansOnlyTheTail(t *testing.T) {
+	blocklist := NewBlocklist([]string{"banana", "ÉCLAIR"})
+	writer := &blockingWriter{next: &bytes.Buffer{}, blocklist: blocklist}
+
+	// The kept output stays bounded however long the stream runs
+	for i := 0; i < 1000; i++ {
+		_, err := writer.Write([]byte("a perfectly fine sentence. "))
+		assert.NoError(t, err)
+	}
+	assert.LessOrEqual(t, len(writer.tail), blocklist.window)
+
+	// while a term split across many small chunks, and a multi-byte rune
+	// split between chunks, are still caught
+	for _, chunk := range []string{"un ", "\xc3", "\x89cl", "a", "i", "r"} {
+		_, err := writer.Write([]byte(chunk))
+		if chunk == "r" {
+			assert.ErrorIs(t, err, ErrBlockedContent)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
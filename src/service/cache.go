@@ -0,0 +1,232 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"minivault/src/llm"
+)
+
+// Cache outcomes recorded in the log entry's cache field
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+// DefaultCacheTTL is used when CACHE_TTL is unset
+const DefaultCacheTTL = 10 * time.Minute
+
+// CachingGenerator wraps a Generator with an in-memory LRU cache of
+// responses, keyed by model, whitespace-normalized prompt and options.
+// Non-streaming hits return the cached result; streaming hits replay the
+// cached chunks as they were first sent. Streaming and non-streaming
+// responses are cached separately, since post-processing differs.
+type CachingGenerator struct {
+	Generator
+	maxEntries int
+	ttl        time.Duration // 0 keeps entries until they are evicted
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+}
+
+// cacheEntry is one cached response
+type cacheEntry struct {
+	key     string
+	result  llm.Result // non-streaming response
+	chunks  []string   // streamed chunks, in order
+	expires time.Time
+}
+
+// NewCachingGenerator caches up to maxEntries responses from next for ttl
+func NewCachingGenerator(next Generator, maxEntries int, ttl time.Duration) *CachingGenerator {
+	return &CachingGenerator{
+		Generator:  next,
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		now:        time.Now,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// CacheFromEnv wraps next in a CachingGenerator sized by CACHE_MAX_ENTRIES
+// and CACHE_TTL, or returns next unchanged when caching is off
+func CacheFromEnv(next Generator) Generator {
+	raw := os.Getenv("CACHE_MAX_ENTRIES")
+	if raw == "" {
+		return next
+	}
+	maxEntries, err := strconv.Atoi(raw)
+	if err != nil || maxEntries < 0 {
+		log.Printf("Ignoring CACHE_MAX_ENTRIES %q: not a non-negative integer", raw)
+		return next
+	}
+	if maxEntries == 0 {
+		return next
+	}
+
+	ttl := DefaultCacheTTL
+	if raw := os.Getenv("CACHE_TTL"); raw != "" {
+		if parsed, err := time.ParseDuration(raw); err != nil || parsed < 0 {
+			log.Printf("Ignoring CACHE_TTL %q: not a non-negative duration", raw)
+		} else {
+			ttl = parsed
+		}
+	}
+	return NewCachingGenerator(next, maxEntries, ttl)
+}
+
+// Generate answers from the cache when it can and caches the backend's
+// response otherwise
+func (g *CachingGenerator) Generate(ctx context.Context, prompt string, opts llm.Options) (*llm.Result, error) {
+	key := g.key(prompt, opts, false)
+	if entry, ok := g.get(key); ok {
+		g.recordHit(ctx)
+		result := entry.result
+		if usage := result.Usage; usage != nil {
+			// The tokens still hold; the backend's timings were for another request
+			result.Usage = &llm.Usage{PromptTokens: usage.PromptTokens, CompletionTokens: usage.CompletionTokens}
+		}
+		return &result, nil
+	}
+	recordCache(ctx, CacheMiss)
+
+	result, err := g.Generator.Generate(ctx, prompt, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !result.EmptyResponse && !result.TimedOut && (result.Response != "" || len(result.ToolCalls) > 0) {
+		g.put(&cacheEntry{key: key, result: *result})
+	}
+	return result, nil
+}
+
+// GenerateStream replays cached chunks when it can, and otherwise records
+// the chunks of a stream that completes so it can be replayed later
+func (g *CachingGenerator) GenerateStream(ctx context.Context, prompt string, opts llm.Options, writer io.Writer) error {
+	key := g.key(prompt, opts, true)
+	if entry, ok := g.get(key); ok {
+		g.recordHit(ctx)
+		for _, chunk := range entry.chunks {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(writer, chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	recordCache(ctx, CacheMiss)
+
+	recorder := &chunkRecorder{next: writer}
+	if err := g.Generator.GenerateStream(ctx, prompt, opts, recorder); err != nil {
+		return err
+	}
+	if len(recorder.chunks) > 0 {
+		g.put(&cacheEntry{key: key, chunks: recorder.chunks})
+	}
+	return nil
+}
+
+// ReloadLists reloads the wrapped generator's lists and, if that succeeds,
+// empties the cache, since cached responses were screened against the old
+// lists and hits don't pass through them again
+func (g *CachingGenerator) ReloadLists() error {
+	if err := g.Generator.ReloadLists(); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	clear(g.entries)
+	g.order.Init()
+	return nil
+}
+
+// recordHit notes a cache hit in the trace, with the cache as the source
+func (g *CachingGenerator) recordHit(ctx context.Context) {
+	recordCache(ctx, CacheHit)
+	recordDecision(ctx, Decision{Backend: "cache", Outcome: "success"})
+}
+
+// key identifies a request. Runs of whitespace in the prompt compare
+// equal, so trailing newlines and re-indentation still hit. Every
+// llm.Options field is part of the key; output_format isn't among them,
+// since handlers apply it to the response after generation.
+func (g *CachingGenerator) key(prompt string, opts llm.Options, streaming bool) string {
+	model := opts.Model
+	if model == "" {
+		model = g.Generator.Model()
+	}
+	options, _ := json.Marshal(opts)
+
+	hash := sha256.New()
+	hash.Write([]byte(strconv.FormatBool(streaming) + "\x00" + model + "\x00"))
+	hash.Write([]byte(strings.Join(strings.Fields(prompt), " ") + "\x00"))
+	hash.Write(options)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// get returns the live entry for key, marking it recently used
+func (g *CachingGenerator) get(key string) (*cacheEntry, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	element, ok := g.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if g.ttl > 0 && !g.now().Before(entry.expires) {
+		g.order.Remove(element)
+		delete(g.entries, key)
+		return nil, false
+	}
+	g.order.MoveToFront(element)
+	return entry, true
+}
+
+// put stores entry, evicting the least recently used entries over the limit
+func (g *CachingGenerator) put(entry *cacheEntry) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	entry.expires = g.now().Add(g.ttl)
+	if element, ok := g.entries[entry.key]; ok {
+		element.Value = entry
+		g.order.MoveToFront(element)
+		return
+	}
+	g.entries[entry.key] = g.order.PushFront(entry)
+	for g.order.Len() > g.maxEntries {
+		oldest := g.order.Back()
+		g.order.Remove(oldest)
+		delete(g.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// chunkRecorder passes writes through, keeping a copy of each one sent
+type chunkRecorder struct {
+	next   io.Writer
+	chunks []string
+}
+
+// Write implements io.Writer
+func (w *chunkRecorder) Write(p []byte) (int, error) {
+	n, err := w.next.Write(p)
+	if n > 0 {
+		w.chunks = append(w.chunks, string(p[:n]))
+	}
+	return n, err
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"minivault/src/llm"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newCachedRecorder returns a cache in front of a recordingLLM-backed generator
+func newCachedRecorder(maxEntries int, ttl time.Duration) (*CachingGenerator, *recordingLLM) {
+	generator := NewGeneratorService("stub")
+	backend := &recordingLLM{}
+	generator.llmService = backend
+	return NewCachingGenerator(generator, maxEntries, ttl), backend
+}
+
+func TestCachingGenerator_Generate(t *testing.T) {
+	cache, backend := newCachedRecorder(10, time.Minute)
+
+	// A miss reaches the backend and is recorded in the trace
+	trace := &DecisionTrace{}
+	result, err := cache.Generate(WithDecisionTrace(context.Background(), trace), "tell me  a joke\n", llm.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", result.Response)
+	assert.Equal(t, CacheMiss, trace.Cache())
+
+	// The same prompt, modulo whitespace, is answered from the cache
+	trace = &DecisionTrace{}
+	result, err = cache.Generate(WithDecisionTrace(context.Background(), trace), "tell me a joke", llm.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", result.Response)
+	assert.Equal(t, CacheHit, trace.Cache())
+	assert.Equal(t, "cache", trace.Source())
+	assert.Len(t, backend.prompts, 1)
+
+	// Other options or models are separate entries
+	temperature := 0.2
+	_, err = cache.Generate(context.Background(), "tell me a joke", llm.Options{Temperature: &temperature})
+	assert.NoError(t, err)
+	_, err = cache.Generate(context.Background(), "tell me a joke", llm.Options{Model: "llama2"})
+	assert.NoError(t, err)
+	assert.Len(t, backend.prompts, 3)
+}
+
+func TestCachingGenerator_KeysOnOptions(t *testing.T) {
+	cache, backend := newCachedRecorder(10, time.Minute)
+	seed, otherSeed := 1, 2
+	maxTokens, otherMaxTokens := 50, 100
+	system, otherSystem := "Be brief.", "Be thorough."
+
+	variants := []llm.Options{
+		{},
+		{Seed: &seed},
+		{Seed: &otherSeed},
+		{MaxTokens: &maxTokens},
+		{MaxTokens: &otherMaxTokens},
+		{System: &system},
+		{System: &otherSystem},
+	}
+	for _, opts := range variants {
+		_, err := cache.Generate(context.Background(), "tell me a joke", opts)
+		assert.NoError(t, err)
+	}
+	assert.Len(t, backend.prompts, len(variants), "each option value gets its own entry")
+
+	// and repeating any of them hits its entry
+	for _, opts := range variants {
+		_, err := cache.Generate(context.Background(), "tell me a joke", opts)
+		assert.NoError(t, err)
+	}
+	assert.Len(t, backend.prompts, len(variants))
+}
+
+func TestCachingGenerator_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache, backend := newCachedRecorder(2, 0)
+	ctx := context.Background()
+
+	for _, prompt := range []string{"a", "b", "a", "c"} {
+		_, err := cache.Generate(ctx, prompt, llm.Options{})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, backend.prompts)
+
+	// "b" was least recently used when "c" arrived, so it's gone; "a" stays
+	cache.Generate(ctx, "a", llm.Options{})
+	cache.Generate(ctx, "b", llm.Options{})
+	assert.Equal(t, []string{"a", "b", "c", "b"}, backend.prompts)
+}
+
+func TestCachingGenerator_TTL(t *testing.T) {
+	cache, backend := newCachedRecorder(10, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	cache.Generate(ctx, "prompt", llm.Options{})
+	now = now.Add(59 * time.Second)
+	cache.Generate(ctx, "prompt", llm.Options{})
+	assert.Len(t, backend.prompts, 1)
+
+	now = now.Add(time.Second)
+	cache.Generate(ctx, "prompt", llm.Options{})
+	assert.Len(t, backend.prompts, 2)
+}
+
+func TestCachingGenerator_StreamReplaysChunks(t *testing.T) {
+	generator := NewGeneratorService("stub")
+	backend := &stallingLLM{tokens: []string{"Hel", "lo", "!"}, interval: time.Millisecond}
+	generator.llmService = backend
+	cache := NewCachingGenerator(generator, 10, time.Minute)
+
+	first := &chunkRecorder{next: &bytes.Buffer{}}
+	assert.NoError(t, cache.GenerateStream(context.Background(), "prompt", llm.Options{}, first))
+
+	// The replay sends the same chunks without calling the backend
+	backend.tokens = nil
+	trace := &DecisionTrace{}
+	replay := &chunkRecorder{next: &bytes.Buffer{}}
+	assert.NoError(t, cache.GenerateStream(WithDecisionTrace(context.Background(), trace), "prompt", llm.Options{}, replay))
+	assert.Equal(t, []string{"Hel", "lo", "!"}, replay.chunks)
+	assert.Equal(t, first.chunks, replay.chunks)
+	assert.Equal(t, CacheHit, trace.Cache())
+
+	// Non-streaming requests don't share the streamed entry
+	result, err := cache.Generate(context.Background(), "prompt", llm.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "", result.Response)
+}
+
+func TestCacheFromEnv(t *testing.T) {
+	generator := NewGeneratorService("stub")
+
+	// Off unless CACHE_MAX_ENTRIES is a positive number
+	for _, value := range []string{"", "0", "lots"} {
+		os.Setenv("CACHE_MAX_ENTRIES", value)
+		assert.Same(t, generator, CacheFromEnv(generator))
+	}
+
+	os.Setenv("CACHE_MAX_ENTRIES", "100")
+	os.Setenv("CACHE_TTL", "30s")
+	defer os.Unsetenv("CACHE_MAX_ENTRIES")
+	defer os.Unsetenv("CACHE_TTL")
+	cache, ok := CacheFromEnv(generator).(*CachingGenerator)
+	assert.True(t, ok)
+	assert.Equal(t, 100, cache.maxEntries)
+	assert.Equal(t, 30*time.Second, cache.ttl)
+}
+
+func TestCachingGenerator_ReloadListsClearsCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("banana\n"), 0644))
+	t.Setenv("STREAM_BLOCKLIST_FILE", path)
+	generator := NewGeneratorService("stub")
+	backend := &recordingLLM{}
+	generator.llmService = backend
+	cache := NewCachingGenerator(generator, 10, time.Minute)
+	stream := func() error {
+		return cache.GenerateStream(context.Background(), "test prompt", llm.Options{}, io.Discard)
+	}
+
+	// Cache a response the current list lets through
+	assert.NoError(t, stream())
+	assert.NoError(t, stream())
+	assert.Len(t, backend.prompts, 1)
+
+	// A list that blocks it applies to the next request instead of the replay
+	assert.NoError(t, os.WriteFile(path, []byte("ok\n"), 0644))
+	assert.NoError(t, cache.ReloadLists())
+	assert.ErrorIs(t, stream(), ErrBlockedContent)
+
+	// A failed reload keeps both the list and the cache
+	assert.NoError(t, os.Remove(path))
+	assert.Error(t, cache.ReloadLists())
+	assert.ErrorIs(t, stream(), ErrBlockedContent)
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"sync"
+)
+
+// Decision records one backend attempt and why the next step was taken
+type Decision struct {
+	Backend string `json:"backend"`          // backend that was attempted
+	Outcome string `json:"outcome"`          // "success", "error", "retry" or "unavailable"
+	Reason  string `json:"reason,omitempty"` // why the attempt failed or was retried
+}
+
+// DecisionTrace collects, in order, the decisions made while serving a request
+type DecisionTrace struct {
+	mu        sync.Mutex
+	decisions []Decision
+	cache     string // CacheHit or CacheMiss; "" when the cache wasn't consulted
+}
+
+type decisionTraceKey struct{}
+
+// WithDecisionTrace returns a context that records generation decisions into trace
+func WithDecisionTrace(ctx context.Context, trace *DecisionTrace) context.Context {
+	return context.WithValue(ctx, decisionTraceKey{}, trace)
+}
+
+// Decisions returns a copy of the recorded decisions
+func (t *DecisionTrace) Decisions() []Decision {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Decision(nil), t.decisions...)
+}
+
+// Source returns the backend that produced the response, i.e. the last
+// successful decision, or "" if none succeeded
+func (t *DecisionTrace) Source() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := len(t.decisions) - 1; i >= 0; i-- {
+		if t.decisions[i].Outcome == "success" {
+			return t.decisions[i].Backend
+		}
+	}
+	return ""
+}
+
+// Cache returns whether the response cache was hit, CacheHit or CacheMiss,
+// or "" if caching is off
+func (t *DecisionTrace) Cache() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cache
+}
+
+// recordCache notes the cache outcome in the trace carried by ctx, if any
+func recordCache(ctx context.Context, outcome string) {
+	trace, ok := ctx.Value(decisionTraceKey{}).(*DecisionTrace)
+	if !ok {
+		return
+	}
+	trace.mu.Lock()
+	trace.cache = outcome
+	trace.mu.Unlock()
+}
+
+// recordDecision appends d to the trace carried by ctx, if any
+func recordDecision(ctx context.Context, d Decision) {
+	trace, ok := ctx.Value(decisionTraceKey{}).(*DecisionTrace)
+	if !ok {
+		return
+	}
+	trace.mu.Lock()
+	trace.decisions = append(trace.decisions, d)
+	trace.mu.Unlock()
+}
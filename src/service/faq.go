@@ -0,0 +1,78 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// FAQEntry maps a prompt pattern to a canned answer. Exactly one of Match
+// (compared case-insensitively after trimming whitespace) or Regex is set.
+type FAQEntry struct {
+	Match  string `json:"match,omitempty"`
+	Regex  string `json:"regex,omitempty"`
+	Answer string `json:"answer"`
+}
+
+// FAQ answers common prompts without calling the backend
+type FAQ struct {
+	entries []faqEntry
+}
+
+type faqEntry struct {
+	match  string
+	re     *regexp.Regexp
+	answer string
+}
+
+// NewFAQ compiles the given entries, checked in order
+func NewFAQ(entries []FAQEntry) (*FAQ, error) {
+	faq := &FAQ{}
+	for i, entry := range entries {
+		compiled := faqEntry{match: strings.TrimSpace(entry.Match), answer: entry.Answer}
+		switch {
+		case entry.Regex != "" && entry.Match != "":
+			return nil, fmt.Errorf("FAQ entry %d sets both match and regex", i)
+		case entry.Regex != "":
+			re, err := regexp.Compile(entry.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("FAQ entry %d has an invalid regex: %v", i, err)
+			}
+			compiled.re = re
+		case entry.Match == "":
+			return nil, fmt.Errorf("FAQ entry %d needs a match or regex", i)
+		}
+		faq.entries = append(faq.entries, compiled)
+	}
+	return faq, nil
+}
+
+// LoadFAQ reads a JSON array of FAQ entries from path
+func LoadFAQ(path string) (*FAQ, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read FAQ file: %v", err)
+	}
+	var entries []FAQEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse FAQ file: %v", err)
+	}
+	return NewFAQ(entries)
+}
+
+// Lookup returns the answer of the first entry matching prompt
+func (f *FAQ) Lookup(prompt string) (string, bool) {
+	trimmed := strings.TrimSpace(prompt)
+	for _, entry := range f.entries {
+		if entry.re != nil {
+			if entry.re.MatchString(prompt) {
+				return entry.answer, true
+			}
+		} else if strings.EqualFold(entry.match, trimmed) {
+			return entry.answer, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFAQ_Lookup(t *testing.T) {
+	faq, err := NewFAQ([]FAQEntry{
+		{Match: "What are your opening hours?", Answer: "9am to 5pm"},
+		{Regex: `(?i)\brefund\b`, Answer: "Refunds take 5 days"},
+	})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		prompt     string
+		wantAnswer string
+		wantHit    bool
+	}{
+		{name: "Exact match ignores case and whitespace", prompt: "  what are your opening hours?\n", wantAnswer: "9am to 5pm", wantHit: true},
+		{name: "Regex match", prompt: "How do I get a REFUND?", wantAnswer: "Refunds take 5 days", wantHit: true},
+		{name: "Miss", prompt: "Tell me a joke", wantHit: false},
+		{name: "Exact match must be whole prompt", prompt: "What are your opening hours? And prices?", wantHit: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answer, ok := faq.Lookup(tt.prompt)
+			assert.Equal(t, tt.wantHit, ok)
+			assert.Equal(t, tt.wantAnswer, answer)
+		})
+	}
+}
+
+func TestLoadFAQ(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faq.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"match":"ping","answer":"pong"}]`), 0644))
+
+	faq, err := LoadFAQ(path)
+	assert.NoError(t, err)
+	answer, ok := faq.Lookup("ping")
+	assert.True(t, ok)
+	assert.Equal(t, "pong", answer)
+
+	_, err = NewFAQ([]FAQEntry{{Answer: "no pattern"}})
+	assert.Error(t, err)
+	_, err = NewFAQ([]FAQEntry{{Regex: "(", Answer: "bad regex"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats a JSON model response can be converted to
+const (
+	OutputFormatJSON = "json"
+	OutputFormatYAML = "yaml"
+	OutputFormatCSV  = "csv"
+)
+
+// outputContentTypes maps each output format to its Content-Type
+var outputContentTypes = map[string]string{
+	OutputFormatJSON: "application/json",
+	OutputFormatYAML: "application/yaml",
+	OutputFormatCSV:  "text/csv",
+}
+
+// ValidOutputFormat reports whether format is a supported output format
+func ValidOutputFormat(format string) bool {
+	_, ok := outputContentTypes[format]
+	return ok
+}
+
+// ConvertOutput converts a JSON response to format, returning the converted
+// text and its Content-Type. It fails when the response isn't valid JSON or
+// doesn't have a shape the format can represent.
+func ConvertOutput(response, format string) (string, string, error) {
+	contentType, ok := outputContentTypes[format]
+	if !ok {
+		return "", "", fmt.Errorf("unsupported output format %q", format)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(response), &value); err != nil {
+		return "", "", fmt.Errorf("response is not valid JSON: %v", err)
+	}
+
+	var converted []byte
+	var err error
+	switch format {
+	case OutputFormatJSON:
+		converted, err = json.MarshalIndent(value, "", "  ")
+	case OutputFormatYAML:
+		converted, err = yaml.Marshal(value)
+	case OutputFormatCSV:
+		converted, err = toCSV(value)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return string(converted), contentType, nil
+}
+
+// toCSV renders an array of objects as a table with a header row of their
+// keys, sorted. A single object is treated as a one-row table and an array
+// of arrays is written row by row.
+func toCSV(value interface{}) ([]byte, error) {
+	if object, ok := value.(map[string]interface{}); ok {
+		value = []interface{}{object}
+	}
+	rows, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("CSV output needs a JSON array or object")
+	}
+
+	var records [][]string
+	if len(rows) > 0 {
+		if _, isArray := rows[0].([]interface{}); isArray {
+			for _, row := range rows {
+				cells, ok := row.([]interface{})
+				if !ok {
+					return nil, fmt.Errorf("CSV output needs rows of the same kind")
+				}
+				records = append(records, csvCells(cells))
+			}
+		} else {
+			header, err := csvHeader(rows)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, header)
+			for _, row := range rows {
+				object := row.(map[string]interface{})
+				cells := make([]interface{}, len(header))
+				for i, key := range header {
+					cells[i] = object[key]
+				}
+				records = append(records, csvCells(cells))
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// csvHeader returns the sorted union of keys across rows of objects
+func csvHeader(rows []interface{}) ([]string, error) {
+	seen := make(map[string]bool)
+	var header []string
+	for _, row := range rows {
+		object, ok := row.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("CSV output needs rows of the same kind")
+		}
+		for key := range object {
+			if !seen[key] {
+				seen[key] = true
+				header = append(header, key)
+			}
+		}
+	}
+	sort.Strings(header)
+	return header, nil
+}
+
+// csvCells formats values as CSV cells. Strings are written as-is, missing
+// values are left empty and anything else is written as JSON.
+func csvCells(values []interface{}) []string {
+	cells := make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+		case string:
+			cells[i] = v
+		default:
+			encoded, _ := json.Marshal(v)
+			cells[i] = string(encoded)
+		}
+	}
+	return cells
+}
+
+// unwrapJSONString undoes one level of escaping when a response is a JSON
+// string literal whose content is itself a JSON object or array, as some
+// models return. It reports whether the response was unwrapped.
+func unwrapJSONString(response string) (string, bool) {
+	var inner string
+	if err := json.Unmarshal([]byte(strings.TrimSpace(response)), &inner); err != nil {
+		return response, false
+	}
+	trimmed := strings.TrimSpace(inner)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return response, false
+	}
+	if !json.Valid([]byte(trimmed)) {
+		return response, false
+	}
+	return inner, true
+}
+
+// collapseRepeatedLines keeps one copy of each run of identical consecutive
+// lines, as some models loop on a line. Blank lines are left alone so
+// paragraph spacing survives. It returns the number of lines removed.
+func collapseRepeatedLines(response string) (string, int) {
+	lines := strings.Split(response, "\n")
+	kept := lines[:1]
+	for _, line := range lines[1:] {
+		if line != "" && line == kept[len(kept)-1] {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n"), len(lines) - len(kept)
+}
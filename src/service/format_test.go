@@ -0,0 +1,147 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertOutput(t *testing.T) {
+	tests := []struct {
+		name            string
+		response        string
+		format          string
+		wantOutput      string
+		wantContentType string
+		wantErr         bool
+	}{
+		{
+			name:            "JSON object to YAML",
+			response:        `{"name":"Ada","languages":["en","fr"],"age":36}`,
+			format:          OutputFormatYAML,
+			wantOutput:      "age: 36\nlanguages:\n    - en\n    - fr\nname: Ada\n",
+			wantContentType: "application/yaml",
+		},
+		{
+			name:            "JSON array to YAML",
+			response:        `[{"id":1},{"id":2}]`,
+			format:          OutputFormatYAML,
+			wantOutput:      "- id: 1\n- id: 2\n",
+			wantContentType: "application/yaml",
+		},
+		{
+			name:            "Array of objects to CSV",
+			response:        `[{"name":"Ada","age":36},{"name":"Alan","tags":["x"]}]`,
+			format:          OutputFormatCSV,
+			wantOutput:      "age,name,tags\n36,Ada,\n,Alan,\"[\"\"x\"\"]\"\n",
+			wantContentType: "text/csv",
+		},
+		{
+			name:            "Array of arrays to CSV",
+			response:        `[["a","b"],[1,2]]`,
+			format:          OutputFormatCSV,
+			wantOutput:      "a,b\n1,2\n",
+			wantContentType: "text/csv",
+		},
+		{
+			name:     "Scalar can't be CSV",
+			response: `42`,
+			format:   OutputFormatCSV,
+			wantErr:  true,
+		},
+		{
+			name:     "Invalid JSON",
+			response: "Sure! Here is your YAML",
+			format:   OutputFormatYAML,
+			wantErr:  true,
+		},
+		{
+			name:     "Unknown format",
+			response: `{}`,
+			format:   "xml",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, contentType, err := ConvertOutput(tt.response, tt.format)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantOutput, output)
+			assert.Equal(t, tt.wantContentType, contentType)
+		})
+	}
+}
+
+func TestUnwrapJSONString(t *testing.T) {
+	tests := []struct {
+		name        string
+		response    string
+		want        string
+		wantApplied bool
+	}{
+		{
+			name:        "Double-escaped object is unwrapped one level",
+			response:    `"{\"name\":\"Ada\",\"quote\":\"say \\\"hi\\\"\"}"`,
+			want:        `{"name":"Ada","quote":"say \"hi\""}`,
+			wantApplied: true,
+		},
+		{
+			name:        "Double-escaped array",
+			response:    ` "[1, 2, 3]"` + "\n",
+			want:        `[1, 2, 3]`,
+			wantApplied: true,
+		},
+		{name: "Plain JSON is left alone", response: `{"name":"Ada"}`, want: `{"name":"Ada"}`},
+		{name: "JSON string of text is left alone", response: `"hello"`, want: `"hello"`},
+		{name: "String of invalid JSON is left alone", response: `"{not json"`, want: `"{not json"`},
+		{name: "Prose is left alone", response: "Hello there", want: "Hello there"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, applied := unwrapJSONString(tt.response)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantApplied, applied)
+		})
+	}
+}
+
+func TestCollapseRepeatedLines(t *testing.T) {
+	tests := []struct {
+		name          string
+		response      string
+		want          string
+		wantCollapsed int
+	}{
+		{
+			name:          "Runs of repeated lines keep one copy",
+			response:      "Hello\nHello\nHello\nWorld\nWorld\n",
+			want:          "Hello\nWorld\n",
+			wantCollapsed: 3,
+		},
+		{
+			name:     "Non-consecutive repeats are kept",
+			response: "a\nb\na",
+			want:     "a\nb\na",
+		},
+		{
+			name:     "Blank lines are kept",
+			response: "para one\n\n\npara two",
+			want:     "para one\n\n\npara two",
+		},
+		{name: "Empty response", response: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, collapsed := collapseRepeatedLines(tt.response)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantCollapsed, collapsed)
+		})
+	}
+}
@@ -1,25 +1,64 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"minivault/src/llm"
+	"minivault/src/types"
 )
 
 // Generator interface defines the contract for text generation services
 type Generator interface {
-	Generate(ctx context.Context, prompt string) (string, error)
-	GenerateStream(ctx context.Context, prompt string, writer io.Writer) error
+	Generate(ctx context.Context, prompt string, opts llm.Options) (*llm.Result, error)
+	GenerateStream(ctx context.Context, prompt string, opts llm.Options, writer io.Writer) error
+	Chat(ctx context.Context, messages []types.Message, opts llm.Options) (*llm.Result, error)
+	Embeddings(ctx context.Context, inputs []string) ([][]float64, error)
+	EffectiveOptions(opts llm.Options) llm.Options
+	EffectivePrompt(prompt string) string
+	Model() string
+	Backend() string
+	Primary() (backend, model string) // configured backend type and model
+	BackendError() error              // why the configured backend isn't serving, if it isn't
+	Ping(ctx context.Context) error
+	ListModels(ctx context.Context) ([]llm.ModelInfo, error)
+	ReloadLists() error // re-reads file-backed content lists
 }
 
 // GeneratorService provides text generation with automatic fallback
 type GeneratorService struct {
-	llmService llm.LLM
+	llmService       llm.LLM
+	backend          string // active backend type, "stub" after a fallback
+	primary          string // configured backend type
+	primaryModel     string // configured model name
+	fallbackReason   error  // why the configured backend couldn't be used, if it couldn't
+	model            string // active model name, "stub" when serving from the stub
+	fewShot          string // examples prepended to every prompt
+	systemPrompt     string // SYSTEM_PROMPT, sent when a request doesn't set its own
+	validateUTF8     bool   // retry once and sanitize responses that aren't valid UTF-8
+	retryEmpty       int    // extra attempts when the backend returns only whitespace
+	unwrapJSON       bool   // unescape responses that are JSON strings holding JSON
+	dedupLines       bool   // collapse consecutive duplicate lines in responses
+	partialOnTimeout bool   // stream internally so a deadline returns the text so far
+	defaultStops     map[string][]string
+	retryBackoff     Backoff
+	streamIdle       time.Duration             // longest gap between streamed tokens; 0 disables
+	faq              *FAQ                      // canned answers checked before the backend; nil when disabled
+	blocklistPath    string                    // STREAM_BLOCKLIST_FILE, re-read by ReloadLists
+	blocklist        atomic.Pointer[Blocklist] // aborts streams that produce blocked terms; nil when disabled
 }
 
 // NewGeneratorService creates a new generator service
@@ -29,26 +68,434 @@ func NewGeneratorService(llmType string) *GeneratorService {
 		URL:   os.Getenv("OLLAMA_HOST"),
 		Model: os.Getenv("OLLAMA_MODEL"),
 	}
+	// Unset or invalid values leave the client defaults in place
+	config.MaxIdleConns, _ = strconv.Atoi(os.Getenv("OLLAMA_MAX_IDLE_CONNS"))
+	config.MaxIdleConnsPerHost, _ = strconv.Atoi(os.Getenv("OLLAMA_MAX_IDLE_CONNS_PER_HOST"))
+	config.Timeout, _ = time.ParseDuration(os.Getenv("OLLAMA_TIMEOUT"))
+	config.CheckContextWindow, _ = strconv.ParseBool(os.Getenv("OLLAMA_CHECK_CONTEXT"))
+	if raw := os.Getenv("OLLAMA_KEEP_ALIVE"); raw != "" {
+		if _, err := time.ParseDuration(raw); err != nil {
+			log.Printf("Ignoring OLLAMA_KEEP_ALIVE: %v", err)
+		} else {
+			config.KeepAlive = raw
+		}
+	}
+	if raw := os.Getenv("OLLAMA_NUM_CTX"); raw != "" {
+		if numCtx, err := strconv.Atoi(raw); err != nil || numCtx <= 0 {
+			log.Printf("Ignoring OLLAMA_NUM_CTX %q: not a positive integer", raw)
+		} else {
+			config.NumCtx = numCtx
+		}
+	}
+	if llmType == "openai" {
+		config.URL = os.Getenv("OPENAI_BASE_URL")
+		config.Model = os.Getenv("OPENAI_MODEL")
+		config.APIKey = os.Getenv("OPENAI_API_KEY")
+	}
+	if llmType == "llamacpp" {
+		config.URL = os.Getenv("LLAMACPP_HOST")
+		config.Model = os.Getenv("LLAMACPP_MODEL")
+	}
 
 	// Try to create LLM service, fallback to stub if fails
+	model := config.Model
+	backend := llmType
+	var fallbackReason error
 	llmService, err := llm.NewLLM(config)
 	if err != nil {
 		llmService, _ = llm.NewLLM(llm.Config{Type: "stub"})
+		backend = "stub"
+		fallbackReason = err
+	}
+	if stub, ok := llmService.(*llm.StubLLM); ok {
+		model = "stub"
+		// Report usage with the same tokenizer as the log's token_count
+		stub.CountTokens = TokenizerFromEnv().CountTokens
+		if raw := os.Getenv("STUB_EMBEDDING_DIM"); raw != "" {
+			if dimension, err := strconv.Atoi(raw); err != nil || dimension <= 0 {
+				log.Printf("Ignoring STUB_EMBEDDING_DIM %q: not a positive integer", raw)
+			} else {
+				stub.EmbeddingDimension = dimension
+			}
+		}
+		loremWords, _ := strconv.Atoi(os.Getenv("STUB_LOREM_WORDS"))
+		respond, err := llm.NewStubResponder(os.Getenv("STUB_MODE"), os.Getenv("STUB_RESPONSES_FILE"), loremWords)
+		if err != nil {
+			log.Printf("Ignoring STUB_MODE, echoing prompts: %v", err)
+		}
+		stub.Respond = respond
+		if raw := os.Getenv("STUB_TOKEN_DELAY"); raw != "" {
+			if delay, err := llm.ParseStubTokenDelay(raw); err != nil {
+				log.Printf("Ignoring STUB_TOKEN_DELAY: %v", err)
+			} else {
+				stub.TokenDelay = delay
+			}
+		}
+	}
+
+	// Load optional few-shot examples
+	var fewShot string
+	if path := os.Getenv("FEWSHOT_FILE"); path != "" {
+		fewShot, err = loadFewShot(path)
+		if err != nil {
+			log.Printf("Ignoring few-shot examples: %v", err)
+		}
+	}
+
+	validateUTF8, _ := strconv.ParseBool(os.Getenv("VALIDATE_UTF8"))
+	retryEmpty, _ := strconv.Atoi(os.Getenv("RETRY_EMPTY"))
+	retryBackoff := Backoff{Jitter: DefaultRetryJitter}
+	retryBackoff.Base, _ = time.ParseDuration(os.Getenv("RETRY_BACKOFF"))
+	retryBackoff.Max, _ = time.ParseDuration(os.Getenv("RETRY_MAX_BACKOFF"))
+	if raw := os.Getenv("RETRY_JITTER"); raw != "" {
+		if jitter, err := ParseJitterStrategy(raw); err == nil {
+			retryBackoff.Jitter = jitter
+		} else {
+			log.Printf("Ignoring RETRY_JITTER: %v", err)
+		}
+	}
+	unwrapJSON, _ := strconv.ParseBool(os.Getenv("UNWRAP_JSON_STRINGS"))
+	dedupLines, _ := strconv.ParseBool(os.Getenv("DEDUP_LINES"))
+	streamIdle, _ := time.ParseDuration(os.Getenv("STREAM_IDLE_TIMEOUT"))
+	partialOnTimeout, _ := strconv.ParseBool(os.Getenv("PARTIAL_ON_TIMEOUT"))
+
+	// Load optional per-model default stop sequences, e.g. {"llama2":["</s>"]}
+	var defaultStops map[string][]string
+	if raw := os.Getenv("DEFAULT_STOPS"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &defaultStops); err != nil {
+			log.Printf("Ignoring DEFAULT_STOPS: %v", err)
+		}
+	}
+
+	// Load optional FAQ answers
+	var faq *FAQ
+	if path := os.Getenv("FAQ_FILE"); path != "" {
+		faq, err = LoadFAQ(path)
+		if err != nil {
+			log.Printf("Ignoring FAQ: %v", err)
+		}
+	}
+
+	// Load optional streaming content blocklist
+	var blocklist *Blocklist
+	blocklistPath := os.Getenv("STREAM_BLOCKLIST_FILE")
+	if blocklistPath != "" {
+		blocklist, err = LoadBlocklist(blocklistPath)
+		if err != nil {
+			log.Printf("Ignoring stream blocklist: %v", err)
+		}
+	}
+
+	g := &GeneratorService{
+		llmService:       llmService,
+		backend:          backend,
+		primary:          llmType,
+		primaryModel:     config.Model,
+		fallbackReason:   fallbackReason,
+		model:            model,
+		fewShot:          fewShot,
+		systemPrompt:     os.Getenv("SYSTEM_PROMPT"),
+		validateUTF8:     validateUTF8,
+		retryEmpty:       retryEmpty,
+		retryBackoff:     retryBackoff,
+		streamIdle:       streamIdle,
+		unwrapJSON:       unwrapJSON,
+		dedupLines:       dedupLines,
+		partialOnTimeout: partialOnTimeout,
+		defaultStops:     defaultStops,
+		faq:              faq,
+		blocklistPath:    blocklistPath,
+	}
+	if blocklist != nil {
+		g.blocklist.Store(blocklist)
+	}
+	return g
+}
+
+// ReloadLists re-reads the stream blocklist file. The new list replaces the
+// old one atomically: streams already running keep the list they started
+// with, and on error the current list stays in place.
+func (g *GeneratorService) ReloadLists() error {
+	if g.blocklistPath == "" {
+		return nil
+	}
+	blocklist, err := LoadBlocklist(g.blocklistPath)
+	if err != nil {
+		return err
+	}
+	g.blocklist.Store(blocklist)
+	return nil
+}
+
+// loadFewShot reads example blocks from a file to prepend to prompts
+func loadFewShot(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read few-shot file: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Model returns the name of the active model
+func (g *GeneratorService) Model() string {
+	return g.model
+}
+
+// Backend returns the type of the active backend, "stub" after a fallback
+func (g *GeneratorService) Backend() string {
+	return g.backend
+}
+
+// Primary returns the configured backend type and model, which differ from
+// Backend and Model after a fallback
+func (g *GeneratorService) Primary() (backend, model string) {
+	return g.primary, g.primaryModel
+}
+
+// Ping checks that the active backend is reachable
+func (g *GeneratorService) Ping(ctx context.Context) error {
+	return g.llmService.Ping(ctx)
+}
+
+// ListModels returns the models available on the active backend
+func (g *GeneratorService) ListModels(ctx context.Context) ([]llm.ModelInfo, error) {
+	return g.llmService.ListModels(ctx)
+}
+
+// EffectivePrompt returns the prompt sent to the backend, with any few-shot
+// examples prepended. Callers keep logging the raw user prompt.
+func (g *GeneratorService) EffectivePrompt(prompt string) string {
+	if g.fewShot == "" {
+		return prompt
+	}
+	return g.fewShot + "\n\n" + prompt
+}
+
+// EffectiveOptions returns opts with server-side defaults for the active
+// model applied. The request's stop sequences come first, followed by any
+// model defaults not already present, and SYSTEM_PROMPT is used unless the
+// request set a system prompt, even an empty one.
+func (g *GeneratorService) EffectiveOptions(opts llm.Options) llm.Options {
+	if opts.System == nil && g.systemPrompt != "" {
+		system := g.systemPrompt
+		opts.System = &system
+	}
+
+	model := g.model
+	if opts.Model != "" {
+		model = opts.Model
+	}
+	defaults := g.defaultStops[model]
+	if len(defaults) == 0 {
+		return opts
+	}
+
+	stops := append([]string(nil), opts.Stop...)
+	for _, stop := range defaults {
+		if !containsString(stops, stop) {
+			stops = append(stops, stop)
+		}
+	}
+	opts.Stop = stops
+	return opts
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// BackendError returns why the configured backend couldn't be created, in
+// which case the stub is serving in its place, or nil when it was created
+func (g *GeneratorService) BackendError() error {
+	return g.fallbackReason
+}
+
+// recordFallback notes in the request's trace that the configured backend
+// was skipped in favour of the stub
+func (g *GeneratorService) recordFallback(ctx context.Context) {
+	if g.fallbackReason != nil {
+		recordDecision(ctx, Decision{Backend: g.primary, Outcome: "unavailable", Reason: g.fallbackReason.Error()})
 	}
+}
 
-	return &GeneratorService{
-		llmService: llmService,
+// recordOutcome notes the result of an attempt against the active backend
+func (g *GeneratorService) recordOutcome(ctx context.Context, err error) {
+	if err != nil {
+		recordDecision(ctx, Decision{Backend: g.backend, Outcome: "error", Reason: err.Error()})
+		return
 	}
+	recordDecision(ctx, Decision{Backend: g.backend, Outcome: "success"})
+}
+
+// isEmptyResult reports whether a result has neither text nor tool calls
+func isEmptyResult(result *llm.Result) bool {
+	return strings.TrimSpace(result.Response) == "" && len(result.ToolCalls) == 0
+}
+
+// lookupFAQ returns a canned answer for prompt, recording the hit in the trace
+func (g *GeneratorService) lookupFAQ(ctx context.Context, prompt string) (string, bool) {
+	if g.faq == nil {
+		return "", false
+	}
+	answer, ok := g.faq.Lookup(prompt)
+	if ok {
+		recordDecision(ctx, Decision{Backend: "faq", Outcome: "success"})
+	}
+	return answer, ok
 }
 
 // Generate returns a response from the LLM
-func (g *GeneratorService) Generate(ctx context.Context, prompt string) (string, error) {
-	return g.llmService.Generate(ctx, prompt)
+func (g *GeneratorService) Generate(ctx context.Context, prompt string, opts llm.Options) (*llm.Result, error) {
+	if answer, ok := g.lookupFAQ(ctx, prompt); ok {
+		return &llm.Result{Response: answer, RawResponse: answer}, nil
+	}
+
+	g.recordFallback(ctx)
+	opts = g.EffectiveOptions(opts)
+	result, err := g.generate(ctx, g.EffectivePrompt(prompt), opts)
+	if err != nil {
+		g.recordOutcome(ctx, err)
+		return nil, err
+	}
+
+	retries := &retryWaiter{backoff: g.retryBackoff}
+	for attempt := 0; attempt < g.retryEmpty && isEmptyResult(result); attempt++ {
+		recordDecision(ctx, Decision{Backend: g.backend, Outcome: "retry", Reason: "response was empty"})
+		if err := retries.wait(ctx); err != nil {
+			g.recordOutcome(ctx, err)
+			return nil, err
+		}
+		result, err = g.generate(ctx, g.EffectivePrompt(prompt), opts)
+		if err != nil {
+			g.recordOutcome(ctx, err)
+			return nil, err
+		}
+	}
+	if g.retryEmpty > 0 && isEmptyResult(result) {
+		result.EmptyResponse = true
+	}
+
+	if g.validateUTF8 && !result.TimedOut && !utf8.ValidString(result.Response) {
+		// Mojibake is usually transient, so ask once more before sanitizing
+		recordDecision(ctx, Decision{Backend: g.backend, Outcome: "retry", Reason: "response was not valid UTF-8"})
+		if err := retries.wait(ctx); err != nil {
+			g.recordOutcome(ctx, err)
+			return nil, err
+		}
+		result, err = g.generate(ctx, g.EffectivePrompt(prompt), opts)
+		if err != nil {
+			g.recordOutcome(ctx, err)
+			return nil, err
+		}
+	}
+
+	// Post-processing below only changes Response; keep the original
+	result.RawResponse = result.Response
+	if g.validateUTF8 && !utf8.ValidString(result.Response) {
+		result.Response = strings.ToValidUTF8(result.Response, "\uFFFD")
+		result.EncodingIssue = true
+	}
+	if g.unwrapJSON {
+		result.Response, result.JSONUnwrapped = unwrapJSONString(result.Response)
+	}
+	if g.dedupLines {
+		result.Response, result.CollapsedLines = collapseRepeatedLines(result.Response)
+	}
+
+	if result.TimedOut {
+		recordDecision(ctx, Decision{Backend: g.backend, Outcome: "partial", Reason: "deadline passed mid-generation"})
+		return result, nil
+	}
+	g.recordOutcome(ctx, nil)
+	return result, nil
 }
 
-// GenerateStream streams responses from the LLM
-func (g *GeneratorService) GenerateStream(ctx context.Context, prompt string, writer io.Writer) error {
-	return g.llmService.GenerateStream(ctx, prompt, writer)
+// generate asks the backend for a complete response. With PARTIAL_ON_TIMEOUT
+// it streams instead, so text that arrived before the deadline can be
+// returned; tool calls and backend usage aren't available that way.
+func (g *GeneratorService) generate(ctx context.Context, prompt string, opts llm.Options) (*llm.Result, error) {
+	if !g.partialOnTimeout {
+		return g.llmService.Generate(ctx, prompt, opts)
+	}
+	var text strings.Builder
+	err := g.llmService.GenerateStream(ctx, prompt, opts, &text)
+	if err != nil && (text.Len() == 0 || !errors.Is(ctx.Err(), context.DeadlineExceeded)) {
+		return nil, err
+	}
+	return &llm.Result{Response: text.String(), TimedOut: err != nil}, nil
+}
+
+// Chat answers a conversation. Few-shot examples, FAQ answers and response
+// post-processing only apply to single prompts.
+func (g *GeneratorService) Chat(ctx context.Context, messages []types.Message, opts llm.Options) (*llm.Result, error) {
+	g.recordFallback(ctx)
+	result, err := g.llmService.Chat(ctx, messages, g.EffectiveOptions(opts))
+	g.recordOutcome(ctx, err)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ErrEmbeddingsUnsupported is returned by Embeddings when the active
+// backend can't embed text
+var ErrEmbeddingsUnsupported = errors.New("embeddings are not supported by this backend")
+
+// Embeddings embeds each input in turn, returning the vectors in input
+// order
+func (g *GeneratorService) Embeddings(ctx context.Context, inputs []string) ([][]float64, error) {
+	embedder, ok := g.llmService.(llm.Embedder)
+	if !ok {
+		return nil, ErrEmbeddingsUnsupported
+	}
+	vectors := make([][]float64, len(inputs))
+	for i, input := range inputs {
+		vector, err := embedder.Embeddings(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// GenerateStream streams responses from the LLM. When a blocklist is
+// configured the stream is cut off with ErrBlockedContent as soon as the
+// accumulated output contains a blocked term. With an idle timeout, a
+// stream that goes quiet for longer after its first token is cancelled
+// with ErrStreamStalled.
+func (g *GeneratorService) GenerateStream(ctx context.Context, prompt string, opts llm.Options, writer io.Writer) error {
+	if answer, ok := g.lookupFAQ(ctx, prompt); ok {
+		_, err := writer.Write([]byte(answer))
+		return err
+	}
+
+	if blocklist := g.blocklist.Load(); blocklist != nil {
+		writer = &blockingWriter{next: writer, blocklist: blocklist}
+	}
+	if g.streamIdle > 0 {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		idle := &idleWriter{next: writer, timeout: g.streamIdle, cancel: cancel}
+		defer idle.stop()
+		writer = idle
+	}
+
+	g.recordFallback(ctx)
+	err := g.llmService.GenerateStream(ctx, g.EffectivePrompt(prompt), g.EffectiveOptions(opts), writer)
+	if err != nil && errors.Is(context.Cause(ctx), ErrStreamStalled) {
+		// Backends report the cancellation however they like
+		err = ErrStreamStalled
+	}
+	g.recordOutcome(ctx, err)
+	return err
 }
 
 // ChunkedWriter implements io.Writer for chunked transfer encoding
@@ -56,42 +503,308 @@ type ChunkedWriter struct {
 	w       http.ResponseWriter
 	flusher http.Flusher
 	onWrite func(string)
+
+	// When buffering, records are held back until the stream ends or grows
+	// past bufferLimit, so short responses can be sent with Content-Length
+	buffering   bool
+	bufferLimit int
+	buffer      bytes.Buffer
+	downgraded  bool // flushing unsupported, so everything is buffered
+
+	offsets bool  // include each token's byte offset in its record
+	written int64 // bytes of token text sent so far
+
+	sse bool // frame records as Server-Sent Events instead of JSON lines
+
+	// SSE events are numbered from 1 so clients can reconnect with
+	// Last-Event-ID; the first one sent also suggests a retry delay
+	eventID     int64
+	retry       time.Duration
+	retrySent   bool
+	resumeAfter int64          // events up to this ID are skipped on a cache replay
+	resumeTrace *DecisionTrace // tells whether the stream is a cache replay
+
+	// err is the first failed write. A record that was cut short can't be
+	// completed, so everything after it is refused rather than appended
+	// to the fragment.
+	err error
 }
 
 // TokenResponse represents a single token in the stream
 type TokenResponse struct {
-	Token string `json:"token"`
+	Token  string `json:"token"`
+	Offset *int64 `json:"offset,omitempty"` // byte position of the token in the full text
+}
+
+// StreamErrorResponse is the terminal record sent when a stream fails after
+// the response has already been committed
+type StreamErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
 }
 
-// NewChunkedWriter creates a new chunked transfer writer
+// StreamDoneResponse is the data of the terminal SSE "done" event
+type StreamDoneResponse struct {
+	Done bool `json:"done"`
+}
+
+// StreamBlockedResponse is the terminal safety marker sent when a stream is
+// cut off by the content blocklist
+type StreamBlockedResponse struct {
+	Blocked bool   `json:"blocked"`
+	Error   string `json:"error"`
+}
+
+// DefaultSSERetry is the reconnection delay suggested to SSE clients
+const DefaultSSERetry = 3 * time.Second
+
+// NewChunkedWriter creates a new chunked transfer writer. When w can't
+// flush, as behind some proxies and test recorders, the whole stream is
+// buffered instead and sent by Finish as a single response.
 func NewChunkedWriter(w http.ResponseWriter, onWrite func(string)) *ChunkedWriter {
 	w.Header().Set("Content-Type", "application/json")
 	// Content-Length is intentionally not set to enable chunked transfer
+	return newChunkedWriter(w, onWrite)
+}
+
+// NewSSEWriter creates a writer that streams the same records as
+// Server-Sent Events for EventSource clients: each one is sent as a
+// "data:" message with an incrementing "id:", and WriteDone ends the
+// stream with a "done" event
+func NewSSEWriter(w http.ResponseWriter, onWrite func(string)) *ChunkedWriter {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	cw := newChunkedWriter(w, onWrite)
+	cw.sse = true
+	cw.retry = DefaultSSERetry
+	return cw
+}
 
-	return &ChunkedWriter{
+func newChunkedWriter(w http.ResponseWriter, onWrite func(string)) *ChunkedWriter {
+	cw := &ChunkedWriter{
 		w:       w,
-		flusher: w.(http.Flusher),
 		onWrite: onWrite,
 	}
+	if supportsFlush(w) {
+		cw.flusher = w.(http.Flusher)
+	} else {
+		cw.downgraded = true
+		cw.BufferUpTo(math.MaxInt)
+	}
+	return cw
+}
+
+// supportsFlush reports whether w, or the writer it wraps, can flush.
+// Wrappers such as gin's implement Flush regardless of the underlying
+// writer, so the check looks through them.
+func supportsFlush(w http.ResponseWriter) bool {
+	for {
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			_, ok := w.(http.Flusher)
+			return ok
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// Downgraded reports whether streaming was replaced by a single buffered
+// response because the underlying writer can't flush
+func (w *ChunkedWriter) Downgraded() bool {
+	return w.downgraded
+}
+
+// BufferUpTo holds output back until it exceeds limit bytes. Streams that
+// finish under the limit are sent in one piece with Content-Length by
+// Finish; longer ones switch to chunked transfer once the limit is passed.
+func (w *ChunkedWriter) BufferUpTo(limit int) {
+	w.buffering = true
+	w.bufferLimit = limit
+}
+
+// IncludeOffsets adds each token's starting byte offset in the response
+// text to its record, so clients can detect dropped chunks by comparing it
+// with the length of the text received so far
+func (w *ChunkedWriter) IncludeOffsets() {
+	w.offsets = true
+}
+
+// SetRetry sets the reconnection delay sent with the first SSE event; 0
+// leaves it to the client
+func (w *ChunkedWriter) SetRetry(retry time.Duration) {
+	w.retry = retry
+}
+
+// ResumeAfter skips SSE events up to and including lastID, for clients
+// reconnecting with Last-Event-ID. Only a stream replayed from the cache
+// repeats the events the client already has, so the skip applies when
+// trace records a cache hit; fresh generations are sent in full from id 1.
+func (w *ChunkedWriter) ResumeAfter(lastID int64, trace *DecisionTrace) {
+	w.resumeAfter = lastID
+	w.resumeTrace = trace
+}
+
+// skipReplayed reports whether the next event was delivered before the
+// client reconnected, numbering it if so
+func (w *ChunkedWriter) skipReplayed() bool {
+	if w.eventID >= w.resumeAfter || w.resumeTrace == nil || w.resumeTrace.Cache() != CacheHit {
+		return false
+	}
+	w.eventID++
+	return true
 }
 
 // Write implements io.Writer
 func (w *ChunkedWriter) Write(p []byte) (n int, err error) {
-	data := string(p)
-	if w.onWrite != nil {
-		w.onWrite(data)
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.skipReplayed() {
+		w.written += int64(len(p))
+		return len(p), nil
 	}
+	data := string(p)
 
 	// Send token as newline-delimited JSON
 	response := TokenResponse{Token: data}
+	if w.offsets {
+		offset := w.written
+		response.Offset = &offset
+	}
+	w.written += int64(len(p))
 	jsonData, err := json.Marshal(response)
 	if err != nil {
 		return 0, err
 	}
 
-	if _, err := fmt.Fprintf(w.w, "%s\n", jsonData); err != nil {
+	if err := w.writeLine(jsonData); err != nil {
 		return 0, err
 	}
-	w.flusher.Flush()
+	// Only report tokens the client was sent
+	if w.onWrite != nil {
+		w.onWrite(data)
+	}
 	return len(p), nil
 }
+
+// WriteError sends an in-stream error record. It is used instead of a JSON
+// error response once tokens (and thus a 200 header) have been written.
+func (w *ChunkedWriter) WriteError(code, message string) error {
+	jsonData, err := json.Marshal(StreamErrorResponse{Error: message, Code: code})
+	if err != nil {
+		return err
+	}
+	return w.writeLine(jsonData)
+}
+
+// Discard drops any buffered records and the stream's headers so a plain
+// JSON error response can be sent instead. It only helps while nothing has
+// reached the client.
+func (w *ChunkedWriter) Discard() {
+	w.buffer.Reset()
+	w.buffering = false
+	w.w.Header().Del("Content-Type")
+	w.w.Header().Del("Cache-Control")
+}
+
+// WriteBlocked sends the in-stream safety marker for a blocked stream
+func (w *ChunkedWriter) WriteBlocked() error {
+	jsonData, err := json.Marshal(StreamBlockedResponse{Blocked: true, Error: "Response stopped by content filter"})
+	if err != nil {
+		return err
+	}
+	return w.writeLine(jsonData)
+}
+
+// WriteDone marks the successful end of an SSE stream with a "done" event.
+// Newline-delimited JSON streams just end, so it writes nothing for them.
+func (w *ChunkedWriter) WriteDone() error {
+	if !w.sse {
+		return nil
+	}
+	jsonData, err := json.Marshal(StreamDoneResponse{Done: true})
+	if err != nil {
+		return err
+	}
+	return w.writeRecord("done", jsonData)
+}
+
+// Finish sends any buffered output with a Content-Length header. It is a
+// no-op when the writer isn't buffering or has already switched to chunked.
+func (w *ChunkedWriter) Finish() error {
+	if !w.buffering {
+		return nil
+	}
+	w.buffering = false
+	w.w.Header().Set("Content-Length", strconv.Itoa(w.buffer.Len()))
+	err := w.send(w.buffer.Bytes())
+	w.buffer.Reset()
+	return err
+}
+
+// writeLine writes one record as a JSON line or an unnamed SSE message
+func (w *ChunkedWriter) writeLine(jsonData []byte) error {
+	return w.writeRecord("", jsonData)
+}
+
+// writeRecord frames and writes one record, buffering it if requested.
+// event names the SSE event and is ignored for JSON lines. The whole frame
+// goes out in a single write so records are never split across writes.
+func (w *ChunkedWriter) writeRecord(event string, jsonData []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	var frame []byte
+	if w.sse {
+		w.eventID++
+		if !w.retrySent && w.retry > 0 {
+			frame = append(frame, "retry: "+strconv.FormatInt(w.retry.Milliseconds(), 10)+"\n"...)
+			w.retrySent = true
+		}
+		frame = append(frame, "id: "+strconv.FormatInt(w.eventID, 10)+"\n"...)
+		if event != "" {
+			frame = append(frame, "event: "+event+"\n"...)
+		}
+		frame = append(frame, "data: "...)
+		frame = append(frame, jsonData...)
+		frame = append(frame, "\n\n"...)
+	} else {
+		frame = append(jsonData, '\n')
+	}
+
+	if w.buffering {
+		w.buffer.Write(frame)
+		if w.buffer.Len() <= w.bufferLimit {
+			return nil
+		}
+		// Too long to buffer: flush what we have and continue chunked
+		w.buffering = false
+		err := w.send(w.buffer.Bytes())
+		w.buffer.Reset()
+		if err != nil {
+			return err
+		}
+		w.flusher.Flush()
+		return nil
+	}
+
+	if err := w.send(frame); err != nil {
+		return err
+	}
+	w.flusher.Flush()
+	return nil
+}
+
+// send writes data to the response, treating a short write as an error
+// and remembering the failure so the stream is aborted
+func (w *ChunkedWriter) send(data []byte) error {
+	n, err := w.w.Write(data)
+	if err == nil && n < len(data) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		w.err = err
+	}
+	return err
+}
@@ -3,24 +3,36 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"minivault/src/llm"
+	"minivault/src/types"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestNewGeneratorService(t *testing.T) {
 	tests := []struct {
-		name    string
-		llmType string
-		envVars map[string]string
+		name      string
+		llmType   string
+		envVars   map[string]string
+		wantModel string
 	}{
 		{
-			name:    "Create with stub type",
-			llmType: "stub",
-			envVars: map[string]string{},
+			name:      "Create with stub type",
+			llmType:   "stub",
+			envVars:   map[string]string{},
+			wantModel: "stub",
 		},
 		{
 			name:    "Create with ollama type",
@@ -29,11 +41,28 @@ func TestNewGeneratorService(t *testing.T) {
 				"OLLAMA_HOST":  "http://localhost:11434",
 				"OLLAMA_MODEL": "test-model",
 			},
+			wantModel: "test-model",
+		},
+		{
+			name:    "Create with llamacpp type",
+			llmType: "llamacpp",
+			envVars: map[string]string{
+				"LLAMACPP_HOST":  "http://localhost:8080",
+				"LLAMACPP_MODEL": "mistral-7b",
+			},
+			wantModel: "mistral-7b",
+		},
+		{
+			name:      "Incomplete llamacpp config falls back to stub",
+			llmType:   "llamacpp",
+			envVars:   map[string]string{"LLAMACPP_MODEL": "mistral-7b"},
+			wantModel: "stub",
 		},
 		{
-			name:    "Invalid type falls back to stub",
-			llmType: "invalid",
-			envVars: map[string]string{},
+			name:      "Invalid type falls back to stub",
+			llmType:   "invalid",
+			envVars:   map[string]string{},
+			wantModel: "stub",
 		},
 	}
 
@@ -49,10 +78,352 @@ func TestNewGeneratorService(t *testing.T) {
 			service := NewGeneratorService(tt.llmType)
 			assert.NotNil(t, service)
 			assert.NotNil(t, service.llmService)
+			assert.Equal(t, tt.wantModel, service.Model())
+		})
+	}
+}
+
+func TestGeneratorService_BackendError(t *testing.T) {
+	os.Unsetenv("OLLAMA_HOST")
+	service := NewGeneratorService("ollama")
+	assert.EqualError(t, service.BackendError(), "OLLAMA_HOST is not set")
+	assert.Equal(t, "stub", service.Model())
+
+	assert.NoError(t, NewGeneratorService("stub").BackendError())
+}
+
+// recordingLLM captures the prompts it receives
+type recordingLLM struct {
+	prompts []string
+	opts    []llm.Options
+}
+
+func (l *recordingLLM) Generate(_ context.Context, prompt string, opts llm.Options) (*llm.Result, error) {
+	l.prompts = append(l.prompts, prompt)
+	l.opts = append(l.opts, opts)
+	return &llm.Result{Response: "ok"}, nil
+}
+
+func (l *recordingLLM) GenerateStream(_ context.Context, prompt string, opts llm.Options, writer io.Writer) error {
+	l.prompts = append(l.prompts, prompt)
+	l.opts = append(l.opts, opts)
+	_, err := writer.Write([]byte("ok"))
+	return err
+}
+
+func (l *recordingLLM) Chat(_ context.Context, messages []types.Message, opts llm.Options) (*llm.Result, error) {
+	l.prompts = append(l.prompts, messages[len(messages)-1].Content)
+	l.opts = append(l.opts, opts)
+	return &llm.Result{Response: "ok"}, nil
+}
+
+func (l *recordingLLM) Ping(_ context.Context) error {
+	return nil
+}
+
+func (l *recordingLLM) ListModels(_ context.Context) ([]llm.ModelInfo, error) {
+	return nil, nil
+}
+
+// sequenceLLM returns the configured responses in order
+type sequenceLLM struct {
+	responses []string
+	calls     int
+}
+
+func (l *sequenceLLM) Generate(_ context.Context, _ string, _ llm.Options) (*llm.Result, error) {
+	response := l.responses[l.calls]
+	l.calls++
+	return &llm.Result{Response: response}, nil
+}
+
+func (l *sequenceLLM) GenerateStream(_ context.Context, _ string, _ llm.Options, writer io.Writer) error {
+	if l.calls >= len(l.responses) {
+		return nil
+	}
+	response := l.responses[l.calls]
+	l.calls++
+	_, err := writer.Write([]byte(response))
+	return err
+}
+
+func (l *sequenceLLM) Chat(ctx context.Context, _ []types.Message, opts llm.Options) (*llm.Result, error) {
+	return l.Generate(ctx, "", opts)
+}
+
+func (l *sequenceLLM) Ping(_ context.Context) error {
+	return nil
+}
+
+func (l *sequenceLLM) ListModels(_ context.Context) ([]llm.ModelInfo, error) {
+	return nil, nil
+}
+
+func TestGeneratorService_ValidateUTF8(t *testing.T) {
+	invalid := "caf\xe9"
+
+	tests := []struct {
+		name          string
+		responses     []string
+		wantResponse  string
+		wantIssue     bool
+		wantCallCount int
+	}{
+		{
+			name:          "Valid response is not retried",
+			responses:     []string{"café"},
+			wantResponse:  "café",
+			wantCallCount: 1,
+		},
+		{
+			name:          "Invalid then valid is retried once",
+			responses:     []string{invalid, "café"},
+			wantResponse:  "café",
+			wantCallCount: 2,
+		},
+		{
+			name:          "Invalid twice is sanitized and flagged",
+			responses:     []string{invalid, invalid},
+			wantResponse:  "caf\uFFFD",
+			wantIssue:     true,
+			wantCallCount: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &sequenceLLM{responses: tt.responses}
+			service := &GeneratorService{llmService: backend, validateUTF8: true}
+
+			result, err := service.Generate(context.Background(), "test prompt", llm.Options{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantResponse, result.Response)
+			assert.Equal(t, tt.wantIssue, result.EncodingIssue)
+			assert.Equal(t, tt.wantCallCount, backend.calls)
+		})
+	}
+}
+
+func TestGeneratorService_DefaultStops(t *testing.T) {
+	os.Setenv("DEFAULT_STOPS", `{"stub":["</s>","\n\nUser:"],"other":["never"]}`)
+	defer os.Unsetenv("DEFAULT_STOPS")
+
+	service := NewGeneratorService("stub")
+	backend := &recordingLLM{}
+	service.llmService = backend
+
+	// Defaults for the active model are applied
+	assert.Equal(t, []string{"</s>", "\n\nUser:"}, service.EffectiveOptions(llm.Options{}).Stop)
+
+	// Request stops come first and duplicates are dropped
+	merged := service.EffectiveOptions(llm.Options{Stop: []string{"END", "</s>"}})
+	assert.Equal(t, []string{"END", "</s>", "\n\nUser:"}, merged.Stop)
+
+	// The merged stops are what reaches the backend
+	ctx := context.Background()
+	_, err := service.Generate(ctx, "test prompt", llm.Options{Stop: []string{"END"}})
+	assert.NoError(t, err)
+	err = service.GenerateStream(ctx, "test prompt", llm.Options{Stop: []string{"END"}}, newMockWriter())
+	assert.NoError(t, err)
+	for _, opts := range backend.opts {
+		assert.Equal(t, []string{"END", "</s>", "\n\nUser:"}, opts.Stop)
+	}
+}
+
+func TestGeneratorService_SystemPrompt(t *testing.T) {
+	os.Setenv("SYSTEM_PROMPT", "Be terse.")
+	defer os.Unsetenv("SYSTEM_PROMPT")
+
+	service := NewGeneratorService("stub")
+	backend := &recordingLLM{}
+	service.llmService = backend
+
+	// The server default applies when the request sets none
+	assert.Equal(t, "Be terse.", service.EffectiveOptions(llm.Options{}).SystemPrompt())
+
+	// A request's own system prompt wins, and empty means none
+	own, empty := "Be verbose.", ""
+	assert.Equal(t, "Be verbose.", service.EffectiveOptions(llm.Options{System: &own}).SystemPrompt())
+	assert.Equal(t, "", service.EffectiveOptions(llm.Options{System: &empty}).SystemPrompt())
+
+	ctx := context.Background()
+	_, err := service.Generate(ctx, "test prompt", llm.Options{})
+	assert.NoError(t, err)
+	err = service.GenerateStream(ctx, "test prompt", llm.Options{}, newMockWriter())
+	assert.NoError(t, err)
+	for _, opts := range backend.opts {
+		assert.Equal(t, "Be terse.", opts.SystemPrompt())
+	}
+}
+
+func TestGeneratorService_Chat(t *testing.T) {
+	os.Setenv("SYSTEM_PROMPT", "Be terse.")
+	defer os.Unsetenv("SYSTEM_PROMPT")
+
+	service := NewGeneratorService("stub")
+	backend := &recordingLLM{}
+	service.llmService = backend
+
+	trace := &DecisionTrace{}
+	ctx := WithDecisionTrace(context.Background(), trace)
+	result, err := service.Chat(ctx, []types.Message{{Role: "user", Content: "Hi"}}, llm.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", result.Response)
+
+	// Server defaults apply to chats too
+	assert.Equal(t, []string{"Hi"}, backend.prompts)
+	assert.Equal(t, "Be terse.", backend.opts[0].SystemPrompt())
+	assert.Equal(t, "stub", trace.Source())
+}
+
+func TestGeneratorService_Embeddings(t *testing.T) {
+	os.Setenv("STUB_EMBEDDING_DIM", "3")
+	defer os.Unsetenv("STUB_EMBEDDING_DIM")
+
+	service := NewGeneratorService("stub")
+	ctx := context.Background()
+
+	// Vectors come back in input order
+	vectors, err := service.Embeddings(ctx, []string{"first", "second"})
+	assert.NoError(t, err)
+	assert.Len(t, vectors, 2)
+	first, _ := service.Embeddings(ctx, []string{"first"})
+	second, _ := service.Embeddings(ctx, []string{"second"})
+	assert.Equal(t, [][]float64{first[0], second[0]}, vectors)
+	assert.Len(t, first[0], 3)
+
+	// Backends that can't embed say so
+	service.llmService = &recordingLLM{}
+	_, err = service.Embeddings(ctx, []string{"first"})
+	assert.ErrorIs(t, err, ErrEmbeddingsUnsupported)
+}
+
+func TestGeneratorService_StubMode(t *testing.T) {
+	os.Setenv("STUB_MODE", "lorem")
+	os.Setenv("STUB_LOREM_WORDS", "5")
+	defer os.Unsetenv("STUB_MODE")
+	defer os.Unsetenv("STUB_LOREM_WORDS")
+
+	result, err := NewGeneratorService("stub").Generate(context.Background(), "prompt", llm.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Lorem ipsum dolor sit amet.", result.Response)
+
+	// An unusable mode keeps the default echo
+	os.Setenv("STUB_MODE", "canned")
+	result, err = NewGeneratorService("stub").Generate(context.Background(), "prompt", llm.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "This is a stubbed response to your prompt: prompt", result.Response)
+}
+
+func TestGeneratorService_StubTokenDelay(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "demo", want: llm.DemoStubTokenDelay},
+		{value: "25ms", want: 25 * time.Millisecond},
+		{value: "soon", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("STUB_TOKEN_DELAY", tt.value)
+			stub := NewGeneratorService("stub").llmService.(*llm.StubLLM)
+			assert.Equal(t, tt.want, stub.TokenDelay)
 		})
 	}
 }
 
+func TestGeneratorService_DecisionTrace(t *testing.T) {
+	// An ollama service without OLLAMA_HOST falls back to the stub
+	os.Unsetenv("OLLAMA_HOST")
+	service := NewGeneratorService("ollama")
+
+	trace := &DecisionTrace{}
+	ctx := WithDecisionTrace(context.Background(), trace)
+	_, err := service.Generate(ctx, "test prompt", llm.Options{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []Decision{
+		{Backend: "ollama", Outcome: "unavailable", Reason: "OLLAMA_HOST is not set"},
+		{Backend: "stub", Outcome: "success"},
+	}, trace.Decisions())
+}
+
+func TestGeneratorService_DecisionTraceRetry(t *testing.T) {
+	backend := &sequenceLLM{responses: []string{"caf\xe9", "café"}}
+	service := &GeneratorService{llmService: backend, backend: "ollama", validateUTF8: true}
+
+	trace := &DecisionTrace{}
+	ctx := WithDecisionTrace(context.Background(), trace)
+	_, err := service.Generate(ctx, "test prompt", llm.Options{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []Decision{
+		{Backend: "ollama", Outcome: "retry", Reason: "response was not valid UTF-8"},
+		{Backend: "ollama", Outcome: "success"},
+	}, trace.Decisions())
+}
+
+func TestGeneratorService_FAQ(t *testing.T) {
+	faq, err := NewFAQ([]FAQEntry{{Match: "What are your hours?", Answer: "9am to 5pm"}})
+	assert.NoError(t, err)
+	backend := &recordingLLM{}
+	service := &GeneratorService{llmService: backend, backend: "ollama", faq: faq}
+
+	t.Run("Hit bypasses the backend", func(t *testing.T) {
+		trace := &DecisionTrace{}
+		ctx := WithDecisionTrace(context.Background(), trace)
+		result, err := service.Generate(ctx, "What are your hours?", llm.Options{})
+		assert.NoError(t, err)
+		assert.Equal(t, "9am to 5pm", result.Response)
+		assert.Empty(t, backend.prompts)
+		assert.Equal(t, "faq", trace.Source())
+
+		writer := newMockWriter()
+		err = service.GenerateStream(ctx, "What are your hours?", llm.Options{}, writer)
+		assert.NoError(t, err)
+		assert.Contains(t, string(writer.written), "9am to 5pm")
+		assert.Empty(t, backend.prompts)
+	})
+
+	t.Run("Miss falls through", func(t *testing.T) {
+		trace := &DecisionTrace{}
+		ctx := WithDecisionTrace(context.Background(), trace)
+		result, err := service.Generate(ctx, "Tell me a joke", llm.Options{})
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", result.Response)
+		assert.Equal(t, []string{"Tell me a joke"}, backend.prompts)
+		assert.Equal(t, "ollama", trace.Source())
+	})
+}
+
+func TestGeneratorService_FewShot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fewshot.txt")
+	examples := "Q: 2+2?\nA: 4\n\nQ: 3+3?\nA: 6\n"
+	assert.NoError(t, os.WriteFile(path, []byte(examples), 0644))
+	os.Setenv("FEWSHOT_FILE", path)
+	defer os.Unsetenv("FEWSHOT_FILE")
+
+	service := NewGeneratorService("stub")
+	backend := &recordingLLM{}
+	service.llmService = backend
+
+	ctx := context.Background()
+	_, err := service.Generate(ctx, "Q: 4+4?", llm.Options{})
+	assert.NoError(t, err)
+	err = service.GenerateStream(ctx, "Q: 5+5?", llm.Options{}, newMockWriter())
+	assert.NoError(t, err)
+
+	// The backend sees the examples followed by the raw prompt
+	assert.Equal(t, "Q: 2+2?\nA: 4\n\nQ: 3+3?\nA: 6\n\nQ: 1+1?", service.EffectivePrompt("Q: 1+1?"))
+	assert.Equal(t, []string{
+		"Q: 2+2?\nA: 4\n\nQ: 3+3?\nA: 6\n\nQ: 4+4?",
+		"Q: 2+2?\nA: 4\n\nQ: 3+3?\nA: 6\n\nQ: 5+5?",
+	}, backend.prompts)
+}
+
 type mockWriter struct {
 	written []byte
 	header  http.Header
@@ -87,9 +458,20 @@ func TestGeneratorService_Generate(t *testing.T) {
 
 	// Test generation
 	ctx := context.Background()
-	response, err := service.Generate(ctx, "test prompt")
+	result, err := service.Generate(ctx, "test prompt", llm.Options{})
+	assert.NoError(t, err)
+	assert.Contains(t, result.Response, "test prompt") // Stub should include the prompt in response
+}
+
+func TestGeneratorService_StubUsage(t *testing.T) {
+	t.Setenv("TOKENIZER", "words")
+	service := NewGeneratorService("stub")
+
+	result, err := service.Generate(context.Background(), "test prompt", llm.Options{})
 	assert.NoError(t, err)
-	assert.Contains(t, response, "test prompt") // Stub should include the prompt in response
+	// The stub counts with the configured tokenizer
+	assert.Equal(t, &llm.Usage{PromptTokens: 2, CompletionTokens: countWords(result.Response)}, result.Usage)
+	assert.Greater(t, result.Usage.CompletionTokens, 0)
 }
 
 func TestGeneratorService_GenerateStream(t *testing.T) {
@@ -101,11 +483,33 @@ func TestGeneratorService_GenerateStream(t *testing.T) {
 
 	// Test streaming
 	ctx := context.Background()
-	err := service.GenerateStream(ctx, "test prompt", writer)
+	err := service.GenerateStream(ctx, "test prompt", llm.Options{}, writer)
 	assert.NoError(t, err)
 	assert.Contains(t, string(writer.written), "test prompt") // Stub should include the prompt in response
 }
 
+func TestGeneratorService_PartialOnTimeout(t *testing.T) {
+	backend := &llm.StubLLM{TokenDelay: 20 * time.Millisecond, Respond: func(string) string { return "one two three four five six" }}
+	service := &GeneratorService{llmService: backend, backend: "stub", partialOnTimeout: true}
+
+	// The deadline cuts the stream after the first few words
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result, err := service.Generate(ctx, "test prompt", llm.Options{})
+	assert.NoError(t, err)
+	assert.True(t, result.TimedOut)
+	assert.True(t, strings.HasPrefix(result.Response, "one two"), result.Response)
+	assert.NotContains(t, result.Response, "six")
+
+	// A stream that finishes in time is a complete response
+	backend.TokenDelay = 0
+	backend.Respond = nil
+	result, err = service.Generate(context.Background(), "test prompt", llm.Options{})
+	assert.NoError(t, err)
+	assert.False(t, result.TimedOut)
+	assert.Contains(t, result.Response, "test prompt")
+}
+
 func TestChunkedWriter(t *testing.T) {
 	var captured string
 	onWrite := func(text string) {
@@ -146,3 +550,319 @@ func TestChunkedWriter(t *testing.T) {
 		assert.Equal(t, testData[i], response.Token)
 	}
 }
+
+func TestSSEWriter(t *testing.T) {
+	var captured string
+	mockWriter := newMockWriter()
+	writer := NewSSEWriter(mockWriter, func(text string) { captured += text })
+	assert.Equal(t, "text/event-stream", mockWriter.Header().Get("Content-Type"))
+
+	_, err := writer.Write([]byte("Hi"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.WriteError("timeout", "Generation timed out"))
+	assert.NoError(t, writer.WriteDone())
+
+	// Event IDs increment, and the first event carries the retry hint
+	assert.Equal(t, "Hi", captured)
+	assert.Equal(t, "retry: 3000\nid: 1\ndata: {\"token\":\"Hi\"}\n\n"+
+		"id: 2\ndata: {\"error\":\"Generation timed out\",\"code\":\"timeout\"}\n\n"+
+		"id: 3\nevent: done\ndata: {\"done\":true}\n\n", string(mockWriter.written))
+
+	// JSON line streams have no done record
+	mockWriter = newMockWriter()
+	assert.NoError(t, NewChunkedWriter(mockWriter, nil).WriteDone())
+	assert.Empty(t, mockWriter.written)
+}
+
+func TestSSEWriter_ResumeAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		cache  string
+		want   string
+		wantCB string
+	}{
+		{
+			name:   "Cache replay skips delivered events",
+			cache:  CacheHit,
+			want:   "retry: 1000\nid: 3\ndata: {\"token\":\" time\"}\n\nid: 4\nevent: done\ndata: {\"done\":true}\n\n",
+			wantCB: " time",
+		},
+		{
+			name:  "Fresh generation is sent in full",
+			cache: CacheMiss,
+			want: "retry: 1000\nid: 1\ndata: {\"token\":\"Once\"}\n\nid: 2\ndata: {\"token\":\" upon\"}\n\n" +
+				"id: 3\ndata: {\"token\":\" time\"}\n\nid: 4\nevent: done\ndata: {\"done\":true}\n\n",
+			wantCB: "Once upon time",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var captured string
+			mockWriter := newMockWriter()
+			writer := NewSSEWriter(mockWriter, func(text string) { captured += text })
+			writer.SetRetry(time.Second)
+			trace := &DecisionTrace{}
+			writer.ResumeAfter(2, trace)
+
+			recordCache(WithDecisionTrace(context.Background(), trace), tt.cache)
+			for _, token := range []string{"Once", " upon", " time"} {
+				_, err := writer.Write([]byte(token))
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, writer.WriteDone())
+
+			assert.Equal(t, tt.want, string(mockWriter.written))
+			assert.Equal(t, tt.wantCB, captured)
+		})
+	}
+}
+
+// partialWriter accepts at most limit bytes per write, like a connection
+// that fails mid-record
+type partialWriter struct {
+	*mockWriter
+	limit   int
+	failErr error // returned with short writes; nil breaks the io.Writer contract
+}
+
+func (w *partialWriter) Write(p []byte) (int, error) {
+	if len(p) <= w.limit {
+		return w.mockWriter.Write(p)
+	}
+	w.mockWriter.Write(p[:w.limit])
+	return w.limit, w.failErr
+}
+
+func TestChunkedWriter_PartialWrite(t *testing.T) {
+	for _, failErr := range []error{errors.New("connection reset"), nil} {
+		t.Run(fmt.Sprint(failErr), func(t *testing.T) {
+			var captured string
+			mockWriter := &partialWriter{mockWriter: newMockWriter(), limit: 20, failErr: failErr}
+			writer := NewChunkedWriter(mockWriter, func(text string) { captured += text })
+
+			_, err := writer.Write([]byte("Hi"))
+			assert.NoError(t, err)
+			_, err = writer.Write([]byte("a token too long for one write"))
+			assert.Error(t, err)
+			if failErr == nil {
+				assert.ErrorIs(t, err, io.ErrShortWrite)
+			}
+
+			// The stream is aborted: nothing is appended to the fragment
+			_, err = writer.Write([]byte("more"))
+			assert.Error(t, err)
+			assert.Error(t, writer.WriteError("generation_failed", "Internal server error"))
+			assert.Equal(t, "Hi", captured) // only tokens sent whole are reported
+
+			// Every complete line is a whole record, and the fragment is unterminated
+			written := string(mockWriter.written)
+			lines := strings.Split(written, "\n")
+			assert.Len(t, lines, 2)
+			assert.JSONEq(t, `{"token":"Hi"}`, lines[0])
+			assert.Equal(t, `{"token":"a token to`, lines[1])
+		})
+	}
+}
+
+func TestChunkedWriter_WriteError(t *testing.T) {
+	mockWriter := newMockWriter()
+	writer := NewChunkedWriter(mockWriter, nil)
+
+	_, err := writer.Write([]byte("token"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.WriteError("generation_failed", "something failed"))
+
+	lines := strings.Split(strings.TrimSpace(string(mockWriter.written)), "\n")
+	assert.Len(t, lines, 2)
+	assert.JSONEq(t, `{"error":"something failed","code":"generation_failed"}`, lines[1])
+}
+
+func TestChunkedWriter_BufferUpTo(t *testing.T) {
+	t.Run("Short response gets Content-Length", func(t *testing.T) {
+		mockWriter := newMockWriter()
+		writer := NewChunkedWriter(mockWriter, nil)
+		writer.BufferUpTo(1024)
+
+		_, err := writer.Write([]byte("short"))
+		assert.NoError(t, err)
+		assert.Empty(t, mockWriter.written, "output is held back while buffering")
+
+		assert.NoError(t, writer.Finish())
+		assert.Equal(t, `{"token":"short"}`+"\n", string(mockWriter.written))
+		assert.Equal(t, strconv.Itoa(len(mockWriter.written)), mockWriter.header.Get("Content-Length"))
+	})
+
+	t.Run("Long response falls back to chunked", func(t *testing.T) {
+		mockWriter := newMockWriter()
+		writer := NewChunkedWriter(mockWriter, nil)
+		writer.BufferUpTo(32)
+
+		for i := 0; i < 5; i++ {
+			_, err := writer.Write([]byte("a longer token"))
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, writer.Finish())
+
+		lines := strings.Split(strings.TrimSpace(string(mockWriter.written)), "\n")
+		assert.Len(t, lines, 5)
+		assert.Empty(t, mockWriter.header.Get("Content-Length"))
+	})
+}
+
+func TestGeneratorService_RetryEmpty(t *testing.T) {
+	// Mock Ollama server that answers with whitespace first
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		response := "  \n"
+		if calls > 1 {
+			response = "Hello!"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"response": response, "done": true})
+	}))
+	defer server.Close()
+
+	backend, err := llm.NewLLM(llm.Config{Type: "ollama", URL: server.URL, Model: "test-model"})
+	assert.NoError(t, err)
+
+	t.Run("Empty then non-empty is retried", func(t *testing.T) {
+		calls = 0
+		service := &GeneratorService{llmService: backend, backend: "ollama", retryEmpty: 2}
+		trace := &DecisionTrace{}
+		result, err := service.Generate(WithDecisionTrace(context.Background(), trace), "test prompt", llm.Options{})
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello!", result.Response)
+		assert.False(t, result.EmptyResponse)
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, []Decision{
+			{Backend: "ollama", Outcome: "retry", Reason: "response was empty"},
+			{Backend: "ollama", Outcome: "success"},
+		}, trace.Decisions())
+	})
+
+	t.Run("Disabled by default", func(t *testing.T) {
+		calls = 0
+		service := &GeneratorService{llmService: backend, backend: "ollama"}
+		result, err := service.Generate(context.Background(), "test prompt", llm.Options{})
+		assert.NoError(t, err)
+		assert.Equal(t, "  \n", result.Response)
+		assert.False(t, result.EmptyResponse)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("Still empty is flagged", func(t *testing.T) {
+		service := &GeneratorService{llmService: &sequenceLLM{responses: []string{"", " ", ""}}, retryEmpty: 2}
+		result, err := service.Generate(context.Background(), "test prompt", llm.Options{})
+		assert.NoError(t, err)
+		assert.Equal(t, "", result.Response)
+		assert.True(t, result.EmptyResponse)
+	})
+
+	t.Run("Retries wait out the backoff", func(t *testing.T) {
+		service := &GeneratorService{
+			llmService:   &sequenceLLM{responses: []string{"", "", "Hello!"}},
+			retryEmpty:   2,
+			retryBackoff: Backoff{Base: 20 * time.Millisecond, Jitter: JitterNone},
+		}
+		start := time.Now()
+		result, err := service.Generate(context.Background(), "test prompt", llm.Options{})
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello!", result.Response)
+		assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond) // 20ms then 40ms
+	})
+}
+
+func TestGeneratorService_UnwrapJSON(t *testing.T) {
+	doubled := `"{\"answer\":42}"`
+
+	service := &GeneratorService{llmService: &sequenceLLM{responses: []string{doubled}}, unwrapJSON: true}
+	result, err := service.Generate(context.Background(), "test prompt", llm.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"answer":42}`, result.Response)
+	assert.Equal(t, doubled, result.RawResponse)
+	assert.True(t, result.JSONUnwrapped)
+
+	// Off by default
+	service = &GeneratorService{llmService: &sequenceLLM{responses: []string{doubled}}}
+	result, err = service.Generate(context.Background(), "test prompt", llm.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, doubled, result.Response)
+	assert.False(t, result.JSONUnwrapped)
+}
+
+func TestGeneratorService_DedupLines(t *testing.T) {
+	repeated := "The answer is 42.\nThe answer is 42.\nThe answer is 42.\nDone."
+
+	service := &GeneratorService{llmService: &sequenceLLM{responses: []string{repeated}}, dedupLines: true}
+	result, err := service.Generate(context.Background(), "test prompt", llm.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "The answer is 42.\nDone.", result.Response)
+	assert.Equal(t, repeated, result.RawResponse)
+	assert.Equal(t, 2, result.CollapsedLines)
+
+	// Off by default
+	service = &GeneratorService{llmService: &sequenceLLM{responses: []string{repeated}}}
+	result, err = service.Generate(context.Background(), "test prompt", llm.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, repeated, result.Response)
+	assert.Zero(t, result.CollapsedLines)
+}
+
+// nonFlushingWriter is a ResponseWriter without http.Flusher
+type nonFlushingWriter struct {
+	header  http.Header
+	written []byte
+}
+
+func (w *nonFlushingWriter) Header() http.Header { return w.header }
+func (w *nonFlushingWriter) Write(p []byte) (int, error) {
+	w.written = append(w.written, p...)
+	return len(p), nil
+}
+func (w *nonFlushingWriter) WriteHeader(int) {}
+
+func TestChunkedWriter_NonFlushingDowngrade(t *testing.T) {
+	w := &nonFlushingWriter{header: make(http.Header)}
+	writer := NewChunkedWriter(w, nil)
+	assert.True(t, writer.Downgraded())
+
+	// Nothing is sent until the stream finishes
+	writer.Write([]byte(strings.Repeat("a", 8192)))
+	writer.Write([]byte("b"))
+	assert.Empty(t, w.written)
+
+	assert.NoError(t, writer.Finish())
+	lines := strings.Split(strings.TrimSpace(string(w.written)), "\n")
+	assert.Len(t, lines, 2)
+	assert.Equal(t, `{"token":"b"}`, lines[1])
+	assert.Equal(t, strconv.Itoa(len(w.written)), w.header.Get("Content-Length"))
+
+	assert.False(t, NewChunkedWriter(newMockWriter(), nil).Downgraded())
+}
+
+func TestGeneratorService_ReloadLists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("banana\n"), 0644))
+	t.Setenv("STREAM_BLOCKLIST_FILE", path)
+	service := NewGeneratorService("stub")
+
+	stream := func(response string) error {
+		service.llmService = &sequenceLLM{responses: []string{response}}
+		return service.GenerateStream(context.Background(), "test prompt", llm.Options{}, io.Discard)
+	}
+	assert.ErrorIs(t, stream("a banana split"), ErrBlockedContent)
+	assert.NoError(t, stream("a cherry pie"))
+
+	// Updating the file takes effect on reload, without a restart
+	assert.NoError(t, os.WriteFile(path, []byte("cherry\n"), 0644))
+	assert.NoError(t, stream("a cherry pie"))
+	assert.NoError(t, service.ReloadLists())
+	assert.ErrorIs(t, stream("a cherry pie"), ErrBlockedContent)
+	assert.NoError(t, stream("a banana split"))
+
+	// A failed reload keeps the current list
+	assert.NoError(t, os.Remove(path))
+	assert.Error(t, service.ReloadLists())
+	assert.ErrorIs(t, stream("a cherry pie"), ErrBlockedContent)
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultHealthPollMaxInterval caps the health poller's backoff when
+// HEALTH_POLL_MAX_INTERVAL is unset
+const DefaultHealthPollMaxInterval = 5 * time.Minute
+
+// Pinger is anything whose reachability can be checked, such as a Generator
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthStatus is the outcome of the health poller's latest check
+type HealthStatus struct {
+	Checked             time.Time     // when the last check finished; zero before the first
+	Err                 error         // why the last check failed, nil if it passed
+	ConsecutiveFailures int           // failed checks since the last success
+	Interval            time.Duration // wait before the next check
+}
+
+// HealthPoller pings a backend in the background, every Interval while it
+// is healthy. Each consecutive failure doubles the wait, up to MaxInterval,
+// so a backend that is down isn't hammered; the first success resets it.
+type HealthPoller struct {
+	Interval    time.Duration
+	MaxInterval time.Duration // 0 means DefaultHealthPollMaxInterval
+
+	pinger Pinger
+	mu     sync.Mutex
+	status HealthStatus
+}
+
+// NewHealthPoller creates a poller checking pinger every interval
+func NewHealthPoller(pinger Pinger, interval, maxInterval time.Duration) *HealthPoller {
+	return &HealthPoller{Interval: interval, MaxInterval: maxInterval, pinger: pinger, status: HealthStatus{Interval: interval}}
+}
+
+// Run checks the backend straight away and then on each tick until ctx is
+// done
+func (p *HealthPoller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.check(ctx))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ticker.Reset(p.check(ctx))
+		}
+	}
+}
+
+// Status returns the latest check's outcome
+func (p *HealthPoller) Status() HealthStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// check pings the backend once, records the outcome and returns how long
+// to wait before the next check
+func (p *HealthPoller) check(ctx context.Context) time.Duration {
+	err := p.pinger.Ping(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status.Checked = time.Now()
+	p.status.Err = err
+	if err == nil {
+		p.status.ConsecutiveFailures = 0
+		p.status.Interval = p.Interval
+		return p.status.Interval
+	}
+
+	maxInterval := p.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = DefaultHealthPollMaxInterval
+	}
+	p.status.ConsecutiveFailures++
+	p.status.Interval = min(2*p.status.Interval, max(maxInterval, p.Interval))
+	return p.status.Interval
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scriptedPinger fails while down is set
+type scriptedPinger struct{ down bool }
+
+func (p *scriptedPinger) Ping(_ context.Context) error {
+	if p.down {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestHealthPoller_Backoff(t *testing.T) {
+	pinger := &scriptedPinger{}
+	poller := NewHealthPoller(pinger, time.Second, 5*time.Second)
+	ctx := context.Background()
+
+	assert.Equal(t, time.Second, poller.check(ctx))
+	assert.NoError(t, poller.Status().Err)
+
+	// The interval doubles on each consecutive failure, up to the cap
+	pinger.down = true
+	for _, want := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		assert.Equal(t, want, poller.check(ctx))
+	}
+	status := poller.Status()
+	assert.EqualError(t, status.Err, "connection refused")
+	assert.Equal(t, 4, status.ConsecutiveFailures)
+	assert.Equal(t, 5*time.Second, status.Interval)
+
+	// and resets once the backend recovers
+	pinger.down = false
+	assert.Equal(t, time.Second, poller.check(ctx))
+	status = poller.Status()
+	assert.NoError(t, status.Err)
+	assert.Zero(t, status.ConsecutiveFailures)
+	assert.False(t, status.Checked.IsZero())
+}
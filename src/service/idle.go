@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrStreamStalled is returned when a stream goes longer than the idle
+// timeout without producing a token
+var ErrStreamStalled = errors.New("stream stalled: no token within the idle timeout")
+
+// idleWriter cancels a stream whose backend stops producing tokens. The
+// timer starts at the first token, is paused while a token is being sent
+// to the client, and is only reset by non-empty writes, so keep-alive
+// writes that carry no text don't hide a stalled model.
+type idleWriter struct {
+	next    io.Writer
+	timeout time.Duration
+	cancel  context.CancelCauseFunc
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	stalled bool
+}
+
+// Write implements io.Writer
+func (w *idleWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return w.next.Write(p)
+	}
+
+	w.mu.Lock()
+	if w.stalled {
+		w.mu.Unlock()
+		return 0, ErrStreamStalled
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+
+	n, err := w.next.Write(p)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stalled {
+		if w.timer == nil {
+			w.timer = time.AfterFunc(w.timeout, w.stall)
+		} else {
+			w.timer.Reset(w.timeout)
+		}
+	}
+	return n, err
+}
+
+// stall marks the stream stalled and cancels its context
+func (w *idleWriter) stall() {
+	w.mu.Lock()
+	w.stalled = true
+	w.mu.Unlock()
+	w.cancel(ErrStreamStalled)
+}
+
+// stop releases the timer once the stream has ended
+func (w *idleWriter) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
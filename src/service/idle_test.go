@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"minivault/src/llm"
+	"minivault/src/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stallingLLM streams its tokens interval apart, then, if stall is set,
+// goes quiet apart from empty heartbeat writes until cancelled
+type stallingLLM struct {
+	tokens   []string
+	interval time.Duration
+	stall    bool
+}
+
+func (l *stallingLLM) Generate(_ context.Context, _ string, _ llm.Options) (*llm.Result, error) {
+	return &llm.Result{}, nil
+}
+
+func (l *stallingLLM) GenerateStream(ctx context.Context, _ string, _ llm.Options, writer io.Writer) error {
+	for _, token := range l.tokens {
+		if _, err := writer.Write([]byte(token)); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.interval):
+		}
+	}
+	if !l.stall {
+		return nil
+	}
+	for {
+		if _, err := writer.Write(nil); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func (l *stallingLLM) Chat(_ context.Context, _ []types.Message, _ llm.Options) (*llm.Result, error) {
+	return &llm.Result{}, nil
+}
+
+func (l *stallingLLM) Ping(_ context.Context) error {
+	return nil
+}
+
+func (l *stallingLLM) ListModels(_ context.Context) ([]llm.ModelInfo, error) {
+	return nil, nil
+}
+
+func TestGeneratorService_StreamIdleTimeout(t *testing.T) {
+	t.Run("Stall mid-stream is cut off", func(t *testing.T) {
+		service := &GeneratorService{
+			llmService: &stallingLLM{tokens: []string{"Hello", " world"}, interval: 10 * time.Millisecond, stall: true},
+			streamIdle: 50 * time.Millisecond,
+		}
+		writer := newMockWriter()
+		start := time.Now()
+		err := service.GenerateStream(context.Background(), "test prompt", llm.Options{}, writer)
+		assert.ErrorIs(t, err, ErrStreamStalled)
+		assert.Equal(t, "Hello world", string(writer.written))
+		// Heartbeats didn't keep the stream alive
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("Steady stream finishes", func(t *testing.T) {
+		service := &GeneratorService{
+			llmService: &stallingLLM{tokens: []string{"a", "b", "c", "d", "e"}, interval: 20 * time.Millisecond},
+			streamIdle: 80 * time.Millisecond,
+		}
+		writer := newMockWriter()
+		err := service.GenerateStream(context.Background(), "test prompt", llm.Options{}, writer)
+		assert.NoError(t, err)
+		assert.Equal(t, "abcde", string(writer.written)) // total time exceeds the idle window
+	})
+
+	t.Run("Disabled by default", func(t *testing.T) {
+		service := &GeneratorService{llmService: &stallingLLM{tokens: []string{"a"}, interval: 60 * time.Millisecond}}
+		assert.NoError(t, service.GenerateStream(context.Background(), "test prompt", llm.Options{}, newMockWriter()))
+	})
+}
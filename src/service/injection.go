@@ -0,0 +1,89 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Injection detection modes
+const (
+	InjectionModeReject = "reject" // refuse suspicious prompts with 403
+	InjectionModeTag    = "tag"    // serve them but flag the log entry
+)
+
+// DefaultInjectionPatterns are used when no patterns file is configured
+var DefaultInjectionPatterns = []string{
+	`(?i)ignore (all |any )?(the )?(previous|prior|above|earlier) (instructions|prompts|rules)`,
+	`(?i)disregard (all |any )?(the )?(previous|prior|above|earlier)`,
+	`(?i)forget (all |everything )?(you were told|your instructions)`,
+	`(?i)(reveal|print|show|repeat) (me )?(your|the) (system )?(prompt|instructions)`,
+	`(?i)you are now (in )?(developer|dan|jailbreak)`,
+	`(?i)act as (an? )?(unrestricted|unfiltered)`,
+}
+
+// InjectionDetector scores prompts by the number of suspicious instruction
+// patterns they match
+type InjectionDetector struct {
+	patterns  []*regexp.Regexp
+	threshold int
+}
+
+// NewInjectionDetector compiles the given patterns. A prompt is suspicious
+// when it matches at least threshold of them.
+func NewInjectionDetector(patterns []string, threshold int) (*InjectionDetector, error) {
+	if threshold < 1 {
+		threshold = 1
+	}
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid injection pattern %q: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return &InjectionDetector{patterns: compiled, threshold: threshold}, nil
+}
+
+// LoadInjectionPatterns reads one regular expression per line from path,
+// skipping blank lines and lines starting with #
+func LoadInjectionPatterns(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open injection patterns file: %v", err)
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read injection patterns file: %v", err)
+	}
+	return patterns, nil
+}
+
+// Score returns the number of patterns the prompt matches
+func (d *InjectionDetector) Score(prompt string) int {
+	score := 0
+	for _, re := range d.patterns {
+		if re.MatchString(prompt) {
+			score++
+		}
+	}
+	return score
+}
+
+// Suspicious reports whether the prompt's score reaches the threshold
+func (d *InjectionDetector) Suspicious(prompt string) bool {
+	return d.Score(prompt) >= d.threshold
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Log formats, selected with LOG_FORMAT
+const (
+	LogFormatJSONL = "jsonl" // one compact JSON object per line, for log shippers
+	LogFormatText  = "text"  // a colorized one-line summary, for reading locally
+)
+
+// DefaultTextPromptLength is how much of the prompt text lines show
+const DefaultTextPromptLength = 60
+
+// Formatter renders a log entry as one line, without the trailing newline
+type Formatter interface {
+	Format(entry LogEntry) ([]byte, error)
+}
+
+// NewFormatter returns the formatter for format. JSON lines keep only
+// fields when it is non-nil; text lines are colorized when color is set.
+func NewFormatter(format string, fields map[string]bool, color bool) (Formatter, error) {
+	switch format {
+	case "", LogFormatJSONL:
+		return JSONFormatter{Fields: fields}, nil
+	case LogFormatText:
+		return TextFormatter{Color: color, PromptLength: DefaultTextPromptLength}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (available: jsonl, text)", format)
+	}
+}
+
+// JSONFormatter writes entries as compact JSON
+type JSONFormatter struct {
+	// Fields is the allowlist of JSON fields written; nil writes all. The
+	// id and timestamp are always kept.
+	Fields map[string]bool
+}
+
+func (f JSONFormatter) Format(entry LogEntry) ([]byte, error) {
+	jsonData, err := json.Marshal(entry)
+	if err != nil || f.Fields == nil {
+		return jsonData, err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(jsonData, &all); err != nil {
+		return nil, err
+	}
+	for field := range all {
+		if !f.Fields[field] {
+			delete(all, field)
+		}
+	}
+	return json.Marshal(all)
+}
+
+// ANSI escapes used by TextFormatter
+const (
+	ansiReset = "\033[0m"
+	ansiDim   = "\033[2m"
+	ansiRed   = "\033[31m"
+	ansiGreen = "\033[32m"
+)
+
+// TextFormatter writes a one-line summary: the time, OK or ERR, the
+// duration, the token count, whether it streamed and the start of the
+// prompt, followed by the error for failed requests
+type TextFormatter struct {
+	Color        bool // color the status and dim the time
+	PromptLength int  // runes of the prompt shown; 0 shows all of it
+}
+
+func (f TextFormatter) Format(entry LogEntry) ([]byte, error) {
+	paint := func(color, text string) string {
+		if !f.Color {
+			return text
+		}
+		return color + text + ansiReset
+	}
+
+	var b strings.Builder
+	b.WriteString(paint(ansiDim, entry.Timestamp.Format(time.RFC3339)))
+	if entry.Success {
+		b.WriteString(" " + paint(ansiGreen, "OK "))
+	} else {
+		b.WriteString(" " + paint(ansiRed, "ERR"))
+	}
+	fmt.Fprintf(&b, " %6dms %5d tokens", entry.Duration, entry.TokenCount)
+	if entry.Streaming {
+		b.WriteString(" stream")
+	}
+	prompt := entry.Prompt
+	if f.PromptLength > 0 {
+		prompt = truncateRunes(prompt, f.PromptLength)
+	}
+	fmt.Fprintf(&b, " %q", prompt)
+	if !entry.Success {
+		b.WriteString(" " + paint(ansiRed, entry.ErrorMessage))
+	}
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextFormatter(t *testing.T) {
+	timestamp := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		entry LogEntry
+		color bool
+		want  string
+	}{
+		{
+			name:  "Success",
+			entry: LogEntry{Timestamp: timestamp, Success: true, Duration: 1234, TokenCount: 56, Prompt: "Tell me a joke"},
+			want:  `2024-05-01T12:00:00Z OK    1234ms    56 tokens "Tell me a joke"`,
+		},
+		{
+			name:  "Streamed error",
+			entry: LogEntry{Timestamp: timestamp, Duration: 7, Streaming: true, Prompt: "hi", ErrorMessage: "backend down"},
+			want:  `2024-05-01T12:00:00Z ERR      7ms     0 tokens stream "hi" backend down`,
+		},
+		{
+			name:  "Long prompt",
+			entry: LogEntry{Timestamp: timestamp, Success: true, Prompt: strings.Repeat("é", 100)},
+			want:  `2024-05-01T12:00:00Z OK       0ms     0 tokens "` + strings.Repeat("é", DefaultTextPromptLength) + `..."`,
+		},
+		{
+			name:  "Colorized",
+			entry: LogEntry{Timestamp: timestamp, Success: true, Prompt: "hi"},
+			color: true,
+			want:  "\033[2m2024-05-01T12:00:00Z\033[0m \033[32mOK \033[0m      0ms     0 tokens \"hi\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formatter, err := NewFormatter(LogFormatText, nil, tt.color)
+			assert.NoError(t, err)
+			line, err := formatter.Format(tt.entry)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(line))
+		})
+	}
+}
+
+func TestNewFormatter(t *testing.T) {
+	formatter, err := NewFormatter("", nil, false)
+	assert.NoError(t, err)
+	assert.IsType(t, JSONFormatter{}, formatter)
+
+	_, err = NewFormatter("xml", nil, false)
+	assert.EqualError(t, err, `unknown log format "xml" (available: jsonl, text)`)
+}
+
+func TestLoggingService_TextFormat(t *testing.T) {
+	t.Setenv("LOG_FORMAT", LogFormatText)
+	t.Setenv("NO_COLOR", "1")
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	logger, err := NewLoggingService(logPath, "stub")
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	assert.NoError(t, logger.LogInteraction("test prompt", "test response", false, LogDetails{Duration: 5 * time.Millisecond}))
+
+	data, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^\S+ OK       5ms     \d+ tokens "test prompt"\n$`, string(data))
+}
@@ -1,84 +1,351 @@
 package service
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"minivault/src/llm"
 )
 
 // Logger defines the interface for logging operations
 type Logger interface {
-	LogInteraction(prompt, response string, streaming bool) error
-	LogError(prompt string, err error, streaming bool) error
+	LogInteraction(prompt, response string, streaming bool, details LogDetails) error
+	LogError(prompt string, err error, streaming bool, details LogDetails) error
+	RecentErrors() []RecentError
+	ReadRecent(limit int, onlyErrors bool) ([]LogEntry, error)
+	Rotate() (string, error)
 	Close() error
 }
 
+// Finish reasons logged for generations that ended early
+const (
+	FinishReasonStall     = "stall"     // no token within STREAM_IDLE_TIMEOUT
+	FinishReasonCancelled = "cancelled" // the client disconnected mid-stream
+	FinishReasonTimeout   = "timeout"   // the deadline passed; the text so far was returned
+)
+
+// LogDetails carries optional request-scoped fields attached to a log entry
+type LogDetails struct {
+	Tags  map[string]string // caller supplied tags, e.g. from X-Log-Tag-* headers
+	Stop  []string          // effective stop sequences sent to the backend
+	Model string            // model serving the request, "stub" for the stub backend
+
+	RequestID  string // from X-Request-ID; a generated ID is used when empty
+	APIKeyHash string // hex SHA-256 of the caller's API key, when auth is enabled
+
+	Decisions    []Decision // backends attempted and why fallback/retry occurred
+	Source       string     // what produced the response, e.g. "ollama" or "faq"
+	Cache        string     // CacheHit or CacheMiss when the response cache is on
+	FinishReason string     // why generation ended early, e.g. FinishReasonStall
+
+	TTFT     time.Duration // time from request start to the first streamed token
+	Duration time.Duration // time spent generating, measured by the caller
+	Timeout  time.Duration // deadline the request was given, 0 for none
+
+	// Usage is what the backend reported, replacing the tokenizer's
+	// estimate in token_count; nil when it reported nothing
+	Usage *llm.Usage
+
+	// Bytes sent to and received from the backend, across retries
+	BackendRequestBytes  int64
+	BackendResponseBytes int64
+
+	InjectionSuspected bool // prompt matched the injection detector
+	BlockedMidstream   bool // stream was cut off by the content blocklist
+	StreamDowngraded   bool // stream was buffered because flushing is unsupported
+	CollapsedLines     int  // repeated response lines removed by DEDUP_LINES
+	TrimmedTurns       int  // oldest chat messages dropped to fit CHAT_HISTORY_TOKENS
+}
+
 // LogEntry represents a single log entry with enhanced details
 type LogEntry struct {
 	// Request details
-	ID        string    `json:"id"`          // Unique request ID
-	Timestamp time.Time `json:"timestamp"`   // ISO 8601 timestamp
-	Duration  int64     `json:"duration_ms"` // Request duration in milliseconds
+	ID        string    `json:"id"`                   // Unique request ID
+	Timestamp time.Time `json:"timestamp"`            // ISO 8601 timestamp
+	Duration  int64     `json:"duration_ms"`          // Generation duration in milliseconds
+	TTFT      float64   `json:"ttft_ms,omitempty"`    // Time to first streamed token in milliseconds
+	Timeout   int64     `json:"timeout_ms,omitempty"` // Deadline the request was given in milliseconds
+
+	// Deployment labels, from ENVIRONMENT and INSTANCE_ID
+	Environment string `json:"environment,omitempty"`
+	Instance    string `json:"instance,omitempty"`
 
 	// Input details
-	Prompt    string `json:"prompt"`
-	LLMType   string `json:"llm_type"`  // "ollama" or "stub"
-	LLMModel  string `json:"llm_model"` // Model name if using Ollama
-	Streaming bool   `json:"streaming"` // Whether streaming was used
+	Prompt    string   `json:"prompt"`
+	LLMType   string   `json:"llm_type"`       // "ollama" or "stub"
+	LLMModel  string   `json:"llm_model"`      // Model that served the request
+	Streaming bool     `json:"streaming"`      // Whether streaming was used
+	Stop      []string `json:"stop,omitempty"` // Effective stop sequences
+
+	InjectionSuspected bool `json:"injection_suspected,omitempty"` // Prompt looked like an injection attempt
+	BlockedMidstream   bool `json:"blocked_midstream,omitempty"`   // Stream was cut off by the content blocklist
+	StreamDowngraded   bool `json:"stream_downgraded,omitempty"`   // Stream was sent as one buffered response
+	CollapsedLines     int  `json:"collapsed_lines,omitempty"`     // Repeated response lines removed
+	TrimmedTurns       int  `json:"trimmed_turns,omitempty"`       // Oldest chat messages dropped
+
+	// Backend traffic
+	BackendRequestBytes  int64 `json:"backend_request_bytes,omitempty"`  // Bytes sent to the backend
+	BackendResponseBytes int64 `json:"backend_response_bytes,omitempty"` // Bytes received from the backend
 
 	// Response details
 	Response     string `json:"response"`
-	TokenCount   int    `json:"token_count"`   // Number of tokens in response
-	ResponseSize int    `json:"response_size"` // Size of response in bytes
+	Source       string `json:"source,omitempty"`        // What produced the response, e.g. "ollama" or "faq"
+	Cache        string `json:"cache,omitempty"`         // "hit" or "miss" when the response cache is on
+	FinishReason string `json:"finish_reason,omitempty"` // Why generation ended early, e.g. "stall"
+	TokenCount   int    `json:"token_count"`             // Number of tokens in response
+	ResponseSize int    `json:"response_size"`           // Size of response in bytes
+
+	// Backend-reported usage, when available; durations in milliseconds
+	PromptTokens       int     `json:"prompt_tokens,omitempty"`
+	TotalDuration      float64 `json:"total_duration_ms,omitempty"`
+	LoadDuration       float64 `json:"load_duration_ms,omitempty"`
+	PromptEvalDuration float64 `json:"prompt_eval_duration_ms,omitempty"`
+	EvalDuration       float64 `json:"eval_duration_ms,omitempty"`
 
 	// Status details
 	Success      bool   `json:"success"`         // Whether the request succeeded
 	ErrorMessage string `json:"error,omitempty"` // Error message if any
 
+	// Request context
+	Tags       map[string]string `json:"tags,omitempty"`         // Caller supplied log tags
+	APIKeyHash string            `json:"api_key_hash,omitempty"` // SHA-256 of the API key used
+	Decisions  []Decision        `json:"decisions,omitempty"`    // Ordered trace of backend attempts
+
 	// System details
 	GoVersion  string `json:"go_version"`   // Go runtime version
 	GoRoutines int    `json:"goroutines"`   // Number of active goroutines
 	MemoryUsed int64  `json:"memory_bytes"` // Memory used in bytes
 }
 
+// Log destinations, selected with LOG_OUTPUT
+const (
+	LogOutputFile   = "file"   // the log file only
+	LogOutputStdout = "stdout" // standard output only, never touching the filesystem
+	LogOutputStderr = "stderr" // standard error only
+	LogOutputBoth   = "both"   // the log file and standard output
+)
+
+// ErrNoLogFile is returned by ReadRecent and Rotate when entries aren't
+// written to a file
+var ErrNoLogFile = errors.New("logs are not written to a file")
+
 // LoggingService handles logging of interactions
 type LoggingService struct {
-	logFile *os.File
-	llmType string
+	// mu guards the output, the log file, its size and rotation
+	mu       sync.Mutex
+	out      io.Writer // where entries are written; nil once closed
+	logFile  *os.File  // the log file, nil when logging only to a stream
+	stream   io.Writer // stdout or stderr, nil when logging only to the file
+	logPath  string
+	size     int64 // bytes in the current log file
+	maxSize  int64 // rotate once the file grows past this; 0 disables rotation
+	maxFiles int   // rotated files kept
+
+	llmType      string
+	environment  string                   // deployment label stamped on every entry
+	instance     string                   // instance label stamped on every entry
+	recentErrors *ErrorRing               // last errors kept in memory for /admin/errors
+	fields       map[string]bool          // JSON fields written to the log; nil writes all
+	formatter    Formatter                // renders entries as the lines written, from LOG_FORMAT
+	redactor     *Redactor                // scrubs prompts and responses before writing; nil when disabled
+	logBodies    bool                     // write prompts and responses; false keeps only the metadata
+	otel         atomic.Pointer[OTelSink] // also emits entries as OTel log records; nil when disabled or closed
+	tokenizer    Tokenizer                // counts response tokens for token_count
 }
 
-// NewLoggingService creates a new logging service
+// NewLoggingService creates a new logging service writing to the
+// destination set by LOG_OUTPUT, the log file by default
 func NewLoggingService(logPath, llmType string) (*LoggingService, error) {
-	// Create logs directory if it doesn't exist
-	dir := "logs"
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create logs directory: %v", err)
+	output := os.Getenv("LOG_OUTPUT")
+	switch output {
+	case "", LogOutputFile, LogOutputStdout, LogOutputStderr, LogOutputBoth:
+	default:
+		log.Printf("Ignoring LOG_OUTPUT %q (available: file, stdout, stderr, both)", output)
+		output = LogOutputFile
 	}
+	return NewLoggingServiceTo(output, logPath, llmType)
+}
 
-	// Open log file
-	logFile, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// NewLoggingServiceTo creates a new logging service writing to output, one
+// of the LogOutput destinations. logPath is only opened when output
+// includes the file.
+func NewLoggingServiceTo(output, logPath, llmType string) (*LoggingService, error) {
+	redactor, err := RedactorFromEnv()
 	if err != nil {
-		return nil, fmt.Errorf("failed to open log file: %v", err)
+		return nil, err
 	}
 
-	return &LoggingService{
-		logFile: logFile,
-		llmType: llmType,
-	}, nil
+	s := &LoggingService{logPath: logPath, redactor: redactor}
+	switch output {
+	case "", LogOutputFile, LogOutputBoth:
+		// Create logs directory if it doesn't exist
+		dir := "logs"
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create logs directory: %v", err)
+		}
+
+		// Open log file
+		logFile, size, err := openLogFile(logPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %v", err)
+		}
+		s.logFile, s.size = logFile, size
+		if output == LogOutputBoth {
+			s.stream = os.Stdout
+		}
+	case LogOutputStdout:
+		s.stream = os.Stdout
+	case LogOutputStderr:
+		s.stream = os.Stderr
+	default:
+		return nil, fmt.Errorf("unknown log output %q (available: file, stdout, stderr, both)", output)
+	}
+	s.setOutput()
+
+	// Optional size-based rotation, e.g. LOG_MAX_SIZE=104857600
+	maxSize, _ := strconv.ParseInt(os.Getenv("LOG_MAX_SIZE"), 10, 64)
+	maxFiles := DefaultLogMaxFiles
+	if raw := os.Getenv("LOG_MAX_FILES"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			maxFiles = n
+		} else {
+			log.Printf("Ignoring LOG_MAX_FILES %q", raw)
+		}
+	}
+
+	recentErrors := DefaultRecentErrors
+	if raw := os.Getenv("RECENT_ERRORS"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			recentErrors = n
+		} else {
+			log.Printf("Ignoring RECENT_ERRORS %q", raw)
+		}
+	}
+
+	// Optional allowlist of log fields, e.g. "success,duration_ms,llm_type"
+	var fields map[string]bool
+	if raw := os.Getenv("LOG_FIELDS"); raw != "" {
+		fields = map[string]bool{"id": true, "timestamp": true}
+		for _, field := range strings.Split(raw, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				fields[field] = true
+			}
+		}
+	}
+
+	// Optional OTLP log export, enabled by the standard endpoint variables
+	if os.Getenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+		otel, err := NewOTLPSink(context.Background())
+		if err != nil {
+			log.Printf("Ignoring OTLP log export: %v", err)
+		}
+		s.otel.Store(otel)
+	}
+
+	s.maxSize = maxSize
+	s.maxFiles = maxFiles
+	s.llmType = llmType
+	s.environment = os.Getenv("ENVIRONMENT")
+	s.instance = os.Getenv("INSTANCE_ID")
+	s.recentErrors = NewErrorRing(recentErrors)
+	s.fields = fields
+	s.logBodies = true
+	if raw := os.Getenv("LOG_BODIES"); raw != "" {
+		if enabled, err := strconv.ParseBool(raw); err == nil {
+			s.logBodies = enabled
+		} else {
+			log.Printf("Ignoring LOG_BODIES %q", raw)
+		}
+	}
+	// Text lines are colorized unless NO_COLOR is set, see no-color.org
+	formatter, err := NewFormatter(os.Getenv("LOG_FORMAT"), fields, os.Getenv("NO_COLOR") == "")
+	if err != nil {
+		log.Printf("Ignoring LOG_FORMAT: %v", err)
+		formatter = JSONFormatter{Fields: fields}
+	}
+	s.formatter = formatter
+	s.tokenizer = TokenizerFromEnv()
+	return s, nil
 }
 
-// Close closes the log file
-func (s *LoggingService) Close() error {
-	if s.logFile == nil {
-		return nil
+// setOutput points the output at the log file and the stream, whichever
+// are set. The caller must hold s.mu once the service is in use.
+func (s *LoggingService) setOutput() {
+	switch {
+	case s.logFile != nil && s.stream != nil:
+		s.out = io.MultiWriter(s.logFile, s.stream)
+	case s.logFile != nil:
+		s.out = s.logFile
+	case s.stream != nil:
+		s.out = s.stream
+	default:
+		s.out = nil
+	}
+}
+
+// RecentErrors returns the most recently logged errors, newest first
+func (s *LoggingService) RecentErrors() []RecentError {
+	return s.recentErrors.Recent()
+}
+
+// scrub blanks the entry's prompt and response when LOG_BODIES is off, or
+// else redacts them when redaction is on, before it is written anywhere
+func (s *LoggingService) scrub(entry *LogEntry) {
+	switch {
+	case !s.logBodies:
+		entry.Prompt, entry.Response = "", ""
+	case s.redactor != nil:
+		entry.Prompt = s.redactor.Redact(entry.Prompt)
+		entry.Response = s.redactor.Redact(entry.Response)
 	}
-	err := s.logFile.Close()
+}
+
+// emitOTel forwards an entry to the OTel sink, if one is configured, as
+// JSON whatever LOG_FORMAT is. Export failures don't fail the request; the
+// file remains the record.
+func (s *LoggingService) emitOTel(entry LogEntry) {
+	otel := s.otel.Load()
+	if otel == nil {
+		return
+	}
+	jsonData, err := JSONFormatter{Fields: s.fields}.Format(entry)
 	if err == nil {
-		s.logFile = nil
+		err = otel.Emit(entry, jsonData)
+	}
+	if err != nil {
+		log.Printf("failed to emit OTel log record: %v", err)
+	}
+}
+
+// Close flushes the OTel sink, if any, and closes the log file. Standard
+// output and error are left open.
+func (s *LoggingService) Close() error {
+	// Swapping the sink out first means no new record reaches it; one
+	// already being emitted is dropped by the shut-down provider
+	if otel := s.otel.Swap(nil); otel != nil {
+		if err := otel.Close(); err != nil {
+			log.Printf("failed to flush OTel logs: %v", err)
+		}
 	}
-	return err
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.logFile != nil {
+		if err := s.logFile.Close(); err != nil {
+			return err
+		}
+	}
+	s.logFile, s.stream, s.out = nil, nil, nil
+	return nil
 }
 
 // generateRequestID creates a unique request ID
@@ -86,6 +353,14 @@ func generateRequestID() string {
 	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), os.Getpid())
 }
 
+// entryID returns the request's ID, or a generated one outside a request
+func entryID(details LogDetails) string {
+	if details.RequestID != "" {
+		return details.RequestID
+	}
+	return generateRequestID()
+}
+
 // getSystemStats returns current system statistics
 func getSystemStats() (int, int64) {
 	var memStats runtime.MemStats
@@ -93,87 +368,129 @@ func getSystemStats() (int, int64) {
 	return runtime.NumGoroutine(), int64(memStats.Alloc)
 }
 
-// countTokens returns a simple approximation of token count
-// In a real implementation, this would use a proper tokenizer
-func countTokens(text string) int {
-	// Simple word-based approximation
-	if text == "" {
-		return 0
-	}
-	words := 0
-	inWord := false
-	for _, r := range text {
-		if r == ' ' || r == '\n' || r == '\t' {
-			inWord = false
-		} else if !inWord {
-			words++
-			inWord = true
-		}
-	}
-	return words
-}
-
 // LogInteraction logs a prompt-response interaction with enhanced details
-func (s *LoggingService) LogInteraction(prompt, response string, streaming bool) error {
-	startTime := time.Now()
+func (s *LoggingService) LogInteraction(prompt, response string, streaming bool, details LogDetails) error {
+	timestamp := time.Now()
 	goroutines, memUsed := getSystemStats()
 
 	entry := LogEntry{
 		// Request details
-		ID:        generateRequestID(),
-		Timestamp: startTime,
-		Duration:  time.Since(startTime).Milliseconds(),
+		ID:        entryID(details),
+		Timestamp: timestamp,
+		Duration:  details.Duration.Milliseconds(),
+		TTFT:      float64(details.TTFT) / float64(time.Millisecond),
+		Timeout:   details.Timeout.Milliseconds(),
+
+		// Deployment labels
+		Environment: s.environment,
+		Instance:    s.instance,
 
 		// Input details
 		Prompt:    prompt,
 		LLMType:   s.llmType,
+		LLMModel:  details.Model,
 		Streaming: streaming,
+		Stop:      details.Stop,
+
+		InjectionSuspected: details.InjectionSuspected,
+		BlockedMidstream:   details.BlockedMidstream,
+		StreamDowngraded:   details.StreamDowngraded,
+		CollapsedLines:     details.CollapsedLines,
+		TrimmedTurns:       details.TrimmedTurns,
+
+		// Backend traffic
+		BackendRequestBytes:  details.BackendRequestBytes,
+		BackendResponseBytes: details.BackendResponseBytes,
 
 		// Response details
 		Response:     response,
-		TokenCount:   countTokens(response),
+		Source:       details.Source,
+		Cache:        details.Cache,
+		FinishReason: details.FinishReason,
+		TokenCount:   s.tokenizer.CountTokens(response),
 		ResponseSize: len(response),
 
 		// Status details
 		Success:      true, // Set to false if there was an error
 		ErrorMessage: "",   // Populated when there's an error
 
+		// Request context
+		Tags:       details.Tags,
+		APIKeyHash: details.APIKeyHash,
+		Decisions:  details.Decisions,
+
 		// System details
 		GoVersion:  runtime.Version(),
 		GoRoutines: goroutines,
 		MemoryUsed: memUsed,
 	}
 
-	jsonData, err := json.Marshal(entry)
+	if usage := details.Usage; usage != nil {
+		entry.TokenCount = usage.CompletionTokens
+		entry.PromptTokens = usage.PromptTokens
+		entry.TotalDuration = milliseconds(usage.TotalDuration)
+		entry.LoadDuration = milliseconds(usage.LoadDuration)
+		entry.PromptEvalDuration = milliseconds(usage.PromptEvalDuration)
+		entry.EvalDuration = milliseconds(usage.EvalDuration)
+	}
+
+	s.scrub(&entry)
+	line, err := s.formatter.Format(entry)
 	if err != nil {
 		return fmt.Errorf("failed to marshal log entry: %v", err)
 	}
 
-	if _, err := fmt.Fprintln(s.logFile, string(jsonData)); err != nil {
+	if err := s.writeLine(line); err != nil {
 		return fmt.Errorf("failed to write to log file: %v", err)
 	}
+	s.emitOTel(entry)
 
 	return nil
 }
 
+// milliseconds converts d to fractional milliseconds for the log
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
 // LogError logs an error with the interaction
-func (s *LoggingService) LogError(prompt string, err error, streaming bool) error {
-	startTime := time.Now()
+func (s *LoggingService) LogError(prompt string, err error, streaming bool, details LogDetails) error {
+	timestamp := time.Now()
 	goroutines, memUsed := getSystemStats()
 
 	entry := LogEntry{
 		// Request details
-		ID:        generateRequestID(),
-		Timestamp: startTime,
-		Duration:  time.Since(startTime).Milliseconds(),
+		ID:        entryID(details),
+		Timestamp: timestamp,
+		Duration:  details.Duration.Milliseconds(),
+		TTFT:      float64(details.TTFT) / float64(time.Millisecond),
+		Timeout:   details.Timeout.Milliseconds(),
+
+		// Deployment labels
+		Environment: s.environment,
+		Instance:    s.instance,
 
 		// Input details
 		Prompt:    prompt,
 		LLMType:   s.llmType,
+		LLMModel:  details.Model,
 		Streaming: streaming,
+		Stop:      details.Stop,
+
+		InjectionSuspected: details.InjectionSuspected,
+		BlockedMidstream:   details.BlockedMidstream,
+		StreamDowngraded:   details.StreamDowngraded,
+		CollapsedLines:     details.CollapsedLines,
+		TrimmedTurns:       details.TrimmedTurns,
+
+		// Backend traffic
+		BackendRequestBytes:  details.BackendRequestBytes,
+		BackendResponseBytes: details.BackendResponseBytes,
 
 		// Response details
 		Response:     "",
+		Cache:        details.Cache,
+		FinishReason: details.FinishReason,
 		TokenCount:   0,
 		ResponseSize: 0,
 
@@ -181,20 +498,30 @@ func (s *LoggingService) LogError(prompt string, err error, streaming bool) erro
 		Success:      false,
 		ErrorMessage: err.Error(),
 
+		// Request context
+		Tags:       details.Tags,
+		APIKeyHash: details.APIKeyHash,
+		Decisions:  details.Decisions,
+
 		// System details
 		GoVersion:  runtime.Version(),
 		GoRoutines: goroutines,
 		MemoryUsed: memUsed,
 	}
 
-	jsonData, err := json.Marshal(entry)
+	// Hash the prompt as received, so errors still correlate when the
+	// logged copy is blanked or redacted
+	s.recentErrors.Add(entry)
+	s.scrub(&entry)
+	line, err := s.formatter.Format(entry)
 	if err != nil {
 		return fmt.Errorf("failed to marshal error log entry: %v", err)
 	}
 
-	if _, err := fmt.Fprintln(s.logFile, string(jsonData)); err != nil {
+	if err := s.writeLine(line); err != nil {
 		return fmt.Errorf("failed to write error log entry: %v", err)
 	}
+	s.emitOTel(entry)
 
 	return nil
 }
@@ -3,9 +3,15 @@ package service
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"minivault/src/llm"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -65,7 +71,7 @@ func TestLoggingService_LogInteraction(t *testing.T) {
 	response := "test response"
 	streaming := false
 
-	err = logger.LogInteraction(prompt, response, streaming)
+	err = logger.LogInteraction(prompt, response, streaming, LogDetails{})
 	assert.NoError(t, err)
 
 	// Read log file and verify content
@@ -98,7 +104,7 @@ func TestLoggingService_LogError(t *testing.T) {
 	testErr := errors.New("test error")
 	streaming := false
 
-	err = logger.LogError(prompt, testErr, streaming)
+	err = logger.LogError(prompt, testErr, streaming, LogDetails{})
 	assert.NoError(t, err)
 
 	// Read log file and verify content
@@ -116,6 +122,136 @@ func TestLoggingService_LogError(t *testing.T) {
 	assert.False(t, entry.Success)
 }
 
+func TestLoggingService_LogDetails(t *testing.T) {
+	// Create temporary directory for test logs
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "test.log")
+
+	// Create logger
+	logger, err := NewLoggingService(logPath, "stub")
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	// Test logging with tags and a decision trace
+	tags := map[string]string{"team": "payments"}
+	decisions := []Decision{{Backend: "stub", Outcome: "success"}}
+	details := LogDetails{
+		RequestID:            "client-42",
+		Cache:                CacheMiss,
+		Tags:                 tags,
+		Decisions:            decisions,
+		TTFT:                 1500 * time.Microsecond,
+		Duration:             2 * time.Second,
+		Model:                "llama3",
+		BackendRequestBytes:  120,
+		BackendResponseBytes: 340,
+	}
+	err = logger.LogInteraction("test prompt", "test response", true, details)
+	assert.NoError(t, err)
+
+	// Read log file and verify content
+	logData, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+
+	var entry LogEntry
+	err = json.Unmarshal(logData, &entry)
+	assert.NoError(t, err)
+	assert.Equal(t, "client-42", entry.ID)
+	assert.Equal(t, "miss", entry.Cache)
+	assert.Equal(t, tags, entry.Tags)
+	assert.Equal(t, decisions, entry.Decisions)
+	assert.Equal(t, 1.5, entry.TTFT)
+	assert.Equal(t, int64(2000), entry.Duration)
+	assert.Equal(t, "llama3", entry.LLMModel)
+	assert.Equal(t, int64(120), entry.BackendRequestBytes)
+	assert.Equal(t, int64(340), entry.BackendResponseBytes)
+	assert.Zero(t, entry.PromptTokens) // no usage was reported
+}
+
+func TestLoggingService_BackendUsage(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	logger, err := NewLoggingService(logPath, "ollama")
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	// Reported counts replace the tokenizer's estimate of "test response"
+	details := LogDetails{Usage: &llm.Usage{
+		PromptTokens:       26,
+		CompletionTokens:   9,
+		TotalDuration:      1200 * time.Millisecond,
+		LoadDuration:       250 * time.Microsecond,
+		PromptEvalDuration: 80 * time.Millisecond,
+		EvalDuration:       900 * time.Millisecond,
+	}}
+	assert.NoError(t, logger.LogInteraction("test prompt", "test response", false, details))
+
+	logData, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	var entry LogEntry
+	assert.NoError(t, json.Unmarshal(logData, &entry))
+	assert.Equal(t, 9, entry.TokenCount)
+	assert.Equal(t, 26, entry.PromptTokens)
+	assert.Equal(t, 1200.0, entry.TotalDuration)
+	assert.Equal(t, 0.25, entry.LoadDuration)
+	assert.Equal(t, 80.0, entry.PromptEvalDuration)
+	assert.Equal(t, 900.0, entry.EvalDuration)
+}
+
+func TestLoggingService_FieldAllowlist(t *testing.T) {
+	os.Setenv("LOG_FIELDS", "success, llm_type")
+	defer os.Unsetenv("LOG_FIELDS")
+
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	logger, err := NewLoggingService(logPath, "stub")
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	assert.NoError(t, logger.LogInteraction("secret prompt", "secret response", false, LogDetails{}))
+	assert.NoError(t, logger.LogError("secret prompt", errors.New("boom"), false, LogDetails{}))
+
+	logData, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(logData)), "\n")
+	assert.Len(t, lines, 2)
+
+	// Only the allowed fields, plus id and timestamp, are written
+	for _, line := range lines {
+		var fields map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &fields))
+		assert.ElementsMatch(t, []string{"id", "timestamp", "success", "llm_type"}, keys(fields))
+		assert.NotContains(t, line, "secret")
+	}
+}
+
+// keys returns the keys of a decoded JSON object
+func keys(m map[string]interface{}) []string {
+	var result []string
+	for key := range m {
+		result = append(result, key)
+	}
+	return result
+}
+
+func TestLoggingService_RecentErrors(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	logger, err := NewLoggingService(logPath, "stub")
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	// Interactions aren't errors and stay out of the buffer
+	assert.NoError(t, logger.LogInteraction("ok prompt", "ok", false, LogDetails{}))
+	assert.NoError(t, logger.LogError("first prompt", errors.New("first failure"), false, LogDetails{}))
+	assert.NoError(t, logger.LogError("second prompt", errors.New("second failure"), true, LogDetails{}))
+
+	recent := logger.RecentErrors()
+	assert.Len(t, recent, 2)
+	assert.Equal(t, "second failure", recent[0].Error)
+	assert.True(t, recent[0].Streaming)
+	assert.Equal(t, "first failure", recent[1].Error)
+	assert.Equal(t, hashPrompt("first prompt"), recent[1].PromptHash)
+	assert.False(t, recent[1].Timestamp.IsZero())
+}
+
 func TestLoggingService_Close(t *testing.T) {
 	// Create temporary directory for test logs
 	tmpDir := t.TempDir()
@@ -131,3 +267,157 @@ func TestLoggingService_Close(t *testing.T) {
 	// Test double close (should not error)
 	assert.NoError(t, logger.Close())
 }
+
+func TestLoggingService_ConcurrentWrites(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	logger, err := NewLoggingService(logPath, "stub")
+	assert.NoError(t, err)
+
+	// Large responses make torn writes likely without the lock
+	response := strings.Repeat("lorem ipsum ", 2000)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			prompt := fmt.Sprintf("prompt %d", i)
+			if i%2 == 0 {
+				logger.LogInteraction(prompt, response, false, LogDetails{})
+			} else {
+				logger.LogError(prompt, errors.New("boom"), true, LogDetails{})
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.NoError(t, logger.Close())
+
+	logData, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(logData), "\n"), "\n")
+	assert.Len(t, lines, 50)
+	for _, line := range lines {
+		var entry LogEntry
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry), "line is not valid JSON")
+	}
+}
+
+func TestLoggingService_DeploymentLabels(t *testing.T) {
+	t.Setenv("ENVIRONMENT", "staging")
+	t.Setenv("INSTANCE_ID", "vault-2")
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	logger, err := NewLoggingService(logPath, "stub")
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	assert.NoError(t, logger.LogInteraction("test prompt", "test response", false, LogDetails{}))
+	assert.NoError(t, logger.LogError("test prompt", errors.New("boom"), false, LogDetails{}))
+
+	logData, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(logData)), "\n")
+	assert.Len(t, lines, 2)
+	for _, line := range lines {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		assert.Equal(t, "staging", entry["environment"])
+		assert.Equal(t, "vault-2", entry["instance"])
+	}
+}
+
+func TestLoggingService_Output(t *testing.T) {
+	tests := []struct {
+		output     string
+		wantFile   bool
+		wantStdout bool
+		wantStderr bool
+	}{
+		{output: LogOutputFile, wantFile: true},
+		{output: LogOutputStdout, wantStdout: true},
+		{output: LogOutputStderr, wantStderr: true},
+		{output: LogOutputBoth, wantFile: true, wantStdout: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			logPath := filepath.Join(tmpDir, "test.log")
+
+			// Capture the standard streams in files
+			stdout, err := os.Create(filepath.Join(tmpDir, "stdout"))
+			assert.NoError(t, err)
+			stderr, err := os.Create(filepath.Join(tmpDir, "stderr"))
+			assert.NoError(t, err)
+			originalStdout, originalStderr := os.Stdout, os.Stderr
+			os.Stdout, os.Stderr = stdout, stderr
+			defer func() { os.Stdout, os.Stderr = originalStdout, originalStderr }()
+
+			t.Setenv("LOG_OUTPUT", tt.output)
+			logger, err := NewLoggingService(logPath, "stub")
+			assert.NoError(t, err)
+			assert.NoError(t, logger.LogInteraction("test prompt", "test response", false, LogDetails{}))
+
+			_, err = logger.ReadRecent(10, false)
+			if tt.wantFile {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrNoLogFile)
+			}
+
+			assert.NoError(t, logger.Close())
+			_, err = stdout.WriteString("") // Close leaves the streams open
+			assert.NoError(t, err)
+
+			for path, want := range map[string]bool{logPath: tt.wantFile, stdout.Name(): tt.wantStdout, stderr.Name(): tt.wantStderr} {
+				data, _ := os.ReadFile(path)
+				if want {
+					assert.Contains(t, string(data), `"prompt":"test prompt"`, path)
+				} else {
+					assert.Empty(t, data, path)
+				}
+			}
+			if !tt.wantFile {
+				assert.NoFileExists(t, logPath)
+			}
+		})
+	}
+}
+
+func TestLoggingService_LogBodiesOff(t *testing.T) {
+	t.Setenv("LOG_BODIES", "false")
+	t.Setenv("LOG_REDACT", "true") // independent: bodies stay blank either way
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	logger, err := NewLoggingService(logPath, "stub")
+	assert.NoError(t, err)
+
+	before := time.Now().Add(-time.Second)
+	assert.NoError(t, logger.LogInteraction("secret prompt", "secret response here", true, LogDetails{
+		Duration: 42 * time.Millisecond,
+		Usage:    &llm.Usage{PromptTokens: 3, CompletionTokens: 7},
+	}))
+	assert.NoError(t, logger.LogError("secret prompt", errors.New("backend down"), false, LogDetails{}))
+	assert.NoError(t, logger.Close())
+
+	data, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 2)
+	var entry, errorEntry LogEntry
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &errorEntry))
+
+	assert.Empty(t, entry.Prompt)
+	assert.Empty(t, entry.Response)
+	assert.Equal(t, 7, entry.TokenCount)
+	assert.Equal(t, 3, entry.PromptTokens)
+	assert.Equal(t, len("secret response here"), entry.ResponseSize)
+	assert.Equal(t, int64(42), entry.Duration)
+	assert.True(t, entry.Timestamp.After(before))
+	assert.True(t, entry.Success)
+	assert.True(t, entry.Streaming)
+
+	assert.Empty(t, errorEntry.Prompt)
+	assert.Equal(t, "backend down", errorEntry.ErrorMessage)
+	assert.False(t, errorEntry.Timestamp.IsZero())
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// tailChunkSize is how much of the log file is read at a time, from the end
+const tailChunkSize = 64 << 10
+
+// ReadRecent returns up to limit entries from the current log file, newest
+// first, reading backwards from the end so only the tail is scanned. With
+// onlyErrors set just failed requests are returned. Rotated files aren't
+// read, and lines that don't parse are skipped.
+func (s *LoggingService) ReadRecent(limit int, onlyErrors bool) ([]LogEntry, error) {
+	// Snapshot the file under the lock: entries are written whole, so the
+	// size is a line boundary, and a later rotation doesn't affect our handle
+	s.mu.Lock()
+	if s.out == nil {
+		s.mu.Unlock()
+		return nil, os.ErrClosed
+	}
+	if s.logFile == nil {
+		s.mu.Unlock()
+		return nil, ErrNoLogFile
+	}
+	file, err := os.Open(s.logPath)
+	size := s.size
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []LogEntry{}
+	if limit <= 0 {
+		return entries, nil
+	}
+	err = readLinesBackward(file, size, func(line []byte) bool {
+		var entry LogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return true
+		}
+		if onlyErrors && entry.Success {
+			return true
+		}
+		entries = append(entries, entry)
+		return len(entries) < limit
+	})
+	return entries, err
+}
+
+// readLinesBackward calls fn with each non-empty line in the first size
+// bytes of r, last line first, until fn returns false
+func readLinesBackward(r io.ReaderAt, size int64, fn func(line []byte) bool) error {
+	buf := make([]byte, tailChunkSize)
+	var rest []byte // end of a line whose start is in an earlier chunk
+	for offset := size; offset > 0; {
+		n := min(tailChunkSize, offset)
+		offset -= n
+		if _, err := r.ReadAt(buf[:n], offset); err != nil {
+			return err
+		}
+
+		data := append(buf[:n:n], rest...)
+		for {
+			i := bytes.LastIndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			if line := data[i+1:]; len(line) > 0 && !fn(line) {
+				return nil
+			}
+			data = data[:i]
+		}
+		rest = append(rest[:0:0], data...)
+	}
+	if len(rest) > 0 {
+		fn(rest)
+	}
+	return nil
+}
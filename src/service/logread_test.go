@@ -0,0 +1,82 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggingService_ReadRecent(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "log.jsonl")
+	logger, err := NewLoggingService(logPath, "stub")
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	for i := 0; i < 10; i++ {
+		if i%3 == 0 {
+			assert.NoError(t, logger.LogError(fmt.Sprintf("prompt %d", i), errors.New("boom"), false, LogDetails{}))
+		} else {
+			assert.NoError(t, logger.LogInteraction(fmt.Sprintf("prompt %d", i), "response", false, LogDetails{}))
+		}
+	}
+
+	// Newest first, up to the limit
+	entries, err := logger.ReadRecent(3, false)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, "prompt 9", entries[0].Prompt)
+		assert.Equal(t, "prompt 7", entries[2].Prompt)
+	}
+
+	// Only failed requests
+	entries, err = logger.ReadRecent(10, true)
+	assert.NoError(t, err)
+	var prompts []string
+	for _, entry := range entries {
+		assert.False(t, entry.Success)
+		prompts = append(prompts, entry.Prompt)
+	}
+	assert.Equal(t, []string{"prompt 9", "prompt 6", "prompt 3", "prompt 0"}, prompts)
+
+	// A limit beyond the file returns everything
+	entries, err = logger.ReadRecent(100, false)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 10)
+}
+
+func TestLoggingService_ReadRecentClosed(t *testing.T) {
+	logger, err := NewLoggingService(filepath.Join(t.TempDir(), "log.jsonl"), "stub")
+	assert.NoError(t, err)
+	logger.Close()
+
+	_, err = logger.ReadRecent(10, false)
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestReadLinesBackward(t *testing.T) {
+	// Lines longer than a chunk and blank lines are handled
+	long := strings.Repeat("x", tailChunkSize+100)
+	content := "first\n" + long + "\n\nlast\n"
+
+	var lines []string
+	err := readLinesBackward(strings.NewReader(content), int64(len(content)), func(line []byte) bool {
+		lines = append(lines, string(line))
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"last", long, "first"}, lines)
+
+	// Stops as soon as fn returns false
+	lines = nil
+	err = readLinesBackward(strings.NewReader(content), int64(len(content)), func(line []byte) bool {
+		lines = append(lines, string(line))
+		return false
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"last"}, lines)
+}
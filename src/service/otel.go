@@ -0,0 +1,112 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+)
+
+// OTelScope is the instrumentation scope of emitted log records
+const OTelScope = "minivault"
+
+// OTelSink emits log entries as OpenTelemetry log records, alongside the
+// log file
+type OTelSink struct {
+	provider *sdklog.LoggerProvider
+	logger   otellog.Logger
+}
+
+// NewOTelSink creates a sink that batches records to exporter
+func NewOTelSink(exporter sdklog.Exporter) *OTelSink {
+	provider := sdklog.NewLoggerProvider(sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter)))
+	return &OTelSink{provider: provider, logger: provider.Logger(OTelScope)}
+}
+
+// NewOTLPSink creates a sink exporting over OTLP/HTTP. The endpoint,
+// headers and TLS settings come from the standard OTEL_EXPORTER_OTLP_*
+// environment variables.
+func NewOTLPSink(ctx context.Context) (*OTelSink, error) {
+	exporter, err := otlploghttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewOTelSink(exporter), nil
+}
+
+// Emit sends one log entry. Attributes are taken from jsonData, the entry
+// as written to the log file, so they use the same names and honour
+// LOG_FIELDS.
+func (s *OTelSink) Emit(entry LogEntry, jsonData []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return err
+	}
+
+	var record otellog.Record
+	record.SetTimestamp(entry.Timestamp)
+	if entry.Success {
+		record.SetSeverity(otellog.SeverityInfo)
+		record.SetBody(otellog.StringValue("interaction"))
+	} else {
+		record.SetSeverity(otellog.SeverityError)
+		record.SetBody(otellog.StringValue(entry.ErrorMessage))
+	}
+	record.AddAttributes(toKeyValues(fields)...)
+
+	s.logger.Emit(context.Background(), record)
+	return nil
+}
+
+// Close flushes pending records and stops the exporter
+func (s *OTelSink) Close() error {
+	return s.provider.Shutdown(context.Background())
+}
+
+// toKeyValues converts decoded JSON fields to attributes in key order
+func toKeyValues(fields map[string]interface{}) []otellog.KeyValue {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]otellog.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, otellog.KeyValue{Key: key, Value: toLogValue(fields[key])})
+	}
+	return attrs
+}
+
+// toLogValue converts a decoded JSON value to an OTel log value, keeping
+// integers as integers
+func toLogValue(v interface{}) otellog.Value {
+	switch v := v.(type) {
+	case string:
+		return otellog.StringValue(v)
+	case bool:
+		return otellog.BoolValue(v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return otellog.Int64Value(n)
+		}
+		f, _ := v.Float64()
+		return otellog.Float64Value(f)
+	case []interface{}:
+		values := make([]otellog.Value, len(v))
+		for i, item := range v {
+			values[i] = toLogValue(item)
+		}
+		return otellog.SliceValue(values...)
+	case map[string]interface{}:
+		return otellog.MapValue(toKeyValues(v)...)
+	default:
+		return otellog.Value{}
+	}
+}
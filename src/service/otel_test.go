@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+)
+
+// memoryExporter keeps exported records in memory
+type memoryExporter struct {
+	mu      sync.Mutex
+	records []sdklog.Record
+}
+
+func (e *memoryExporter) Export(_ context.Context, records []sdklog.Record) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, record := range records {
+		e.records = append(e.records, record.Clone())
+	}
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(context.Context) error   { return nil }
+func (e *memoryExporter) ForceFlush(context.Context) error { return nil }
+
+// attributes collects a record's attributes by key
+func attributes(record sdklog.Record) map[string]otellog.Value {
+	attrs := make(map[string]otellog.Value)
+	record.WalkAttributes(func(kv otellog.KeyValue) bool {
+		attrs[kv.Key] = kv.Value
+		return true
+	})
+	return attrs
+}
+
+func TestLoggingService_OTelSink(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	logger, err := NewLoggingService(logPath, "stub")
+	assert.NoError(t, err)
+	exporter := &memoryExporter{}
+	logger.otel.Store(NewOTelSink(exporter))
+
+	details := LogDetails{Tags: map[string]string{"team": "payments"}, Source: "stub"}
+	assert.NoError(t, logger.LogInteraction("test prompt", "test response", true, details))
+	assert.NoError(t, logger.LogError("bad prompt", errors.New("boom"), false, LogDetails{}))
+	assert.NoError(t, logger.Close()) // flushes the batch
+
+	logData, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(logData)), "\n")
+	assert.Len(t, lines, 2) // the file still gets every entry
+	var entry LogEntry
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+
+	assert.Len(t, exporter.records, 2)
+	record := exporter.records[0]
+	assert.Equal(t, otellog.SeverityInfo, record.Severity())
+	assert.True(t, entry.Timestamp.Equal(record.Timestamp()))
+
+	attrs := attributes(record)
+	assert.Equal(t, entry.ID, attrs["id"].AsString())
+	assert.Equal(t, entry.Prompt, attrs["prompt"].AsString())
+	assert.Equal(t, entry.Response, attrs["response"].AsString())
+	assert.Equal(t, entry.LLMType, attrs["llm_type"].AsString())
+	assert.Equal(t, entry.Source, attrs["source"].AsString())
+	assert.Equal(t, entry.Streaming, attrs["streaming"].AsBool())
+	assert.Equal(t, entry.Success, attrs["success"].AsBool())
+	assert.Equal(t, int64(entry.TokenCount), attrs["token_count"].AsInt64())
+	assert.Equal(t, int64(entry.ResponseSize), attrs["response_size"].AsInt64())
+	assert.Equal(t, otellog.KindMap, attrs["tags"].Kind())
+	assert.Equal(t, []otellog.KeyValue{otellog.String("team", "payments")}, attrs["tags"].AsMap())
+
+	// Errors are emitted at error severity with the message as the body
+	record = exporter.records[1]
+	assert.Equal(t, otellog.SeverityError, record.Severity())
+	assert.Equal(t, "boom", record.Body().AsString())
+	assert.Equal(t, "boom", attributes(record)["error"].AsString())
+}
+
+func TestLoggingService_CloseWhileEmitting(t *testing.T) {
+	logger, err := NewLoggingService(filepath.Join(t.TempDir(), "test.log"), "stub")
+	assert.NoError(t, err)
+	logger.otel.Store(NewOTelSink(&memoryExporter{}))
+
+	// Run with -race: Close must not race entries still being logged
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_ = logger.LogInteraction("test prompt", "test response", true, LogDetails{})
+			}
+		}()
+	}
+	assert.NoError(t, logger.Close())
+	wg.Wait()
+	assert.Nil(t, logger.otel.Load())
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrPromptHostNotAllowed is returned for prompt URLs outside the allowlist
+var ErrPromptHostNotAllowed = errors.New("prompt_url host is not allowed")
+
+// PromptFetcher downloads prompts from URLs on an allowlist of hosts
+type PromptFetcher struct {
+	hosts    map[string]bool
+	maxBytes int64
+	client   *http.Client
+}
+
+// NewPromptFetcher creates a fetcher for the given hosts that gives up after
+// timeout and rejects prompts larger than maxBytes
+func NewPromptFetcher(hosts []string, maxBytes int64, timeout time.Duration) *PromptFetcher {
+	f := &PromptFetcher{
+		hosts:    make(map[string]bool),
+		maxBytes: maxBytes,
+	}
+	for _, host := range hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			f.hosts[host] = true
+		}
+	}
+	f.client = &http.Client{
+		Timeout: timeout,
+		// Redirects must stay on the allowlist too
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !f.Allowed(req.URL) {
+				return ErrPromptHostNotAllowed
+			}
+			if len(via) >= 5 {
+				return fmt.Errorf("too many redirects")
+			}
+			return nil
+		},
+	}
+	return f
+}
+
+// Allowed reports whether u is an http(s) URL on an allowed host
+func (f *PromptFetcher) Allowed(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	return f.hosts[strings.ToLower(u.Hostname())]
+}
+
+// Fetch downloads the prompt at rawURL
+func (f *PromptFetcher) Fetch(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid prompt_url: %v", err)
+	}
+	if !f.Allowed(u) {
+		return "", ErrPromptHostNotAllowed
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrPromptHostNotAllowed) {
+			return "", ErrPromptHostNotAllowed
+		}
+		return "", fmt.Errorf("failed to fetch prompt_url: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code fetching prompt_url: %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read prompt_url: %v", err)
+	}
+	if int64(len(data)) > f.maxBytes {
+		return "", fmt.Errorf("prompt_url content exceeds %d bytes", f.maxBytes)
+	}
+	return string(data), nil
+}
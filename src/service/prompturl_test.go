@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromptFetcher_Fetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/prompt.txt":
+			w.Write([]byte("Summarize this document"))
+		case "/large.txt":
+			w.Write([]byte(strings.Repeat("a", 100)))
+		case "/redirect":
+			http.Redirect(w, r, "http://example.com/prompt.txt", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	fetcher := NewPromptFetcher([]string{"127.0.0.1"}, 50, time.Second)
+	ctx := context.Background()
+
+	t.Run("Allowed host", func(t *testing.T) {
+		prompt, err := fetcher.Fetch(ctx, server.URL+"/prompt.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, "Summarize this document", prompt)
+	})
+
+	t.Run("Disallowed host", func(t *testing.T) {
+		_, err := fetcher.Fetch(ctx, "http://example.com/prompt.txt")
+		assert.ErrorIs(t, err, ErrPromptHostNotAllowed)
+	})
+
+	t.Run("Redirect off the allowlist", func(t *testing.T) {
+		_, err := fetcher.Fetch(ctx, server.URL+"/redirect")
+		assert.ErrorIs(t, err, ErrPromptHostNotAllowed)
+	})
+
+	t.Run("Non-http scheme", func(t *testing.T) {
+		_, err := fetcher.Fetch(ctx, "file://127.0.0.1/etc/passwd")
+		assert.ErrorIs(t, err, ErrPromptHostNotAllowed)
+	})
+
+	t.Run("Too large", func(t *testing.T) {
+		_, err := fetcher.Fetch(ctx, server.URL+"/large.txt")
+		assert.ErrorContains(t, err, "exceeds 50 bytes")
+	})
+
+	t.Run("Error status", func(t *testing.T) {
+		_, err := fetcher.Fetch(ctx, server.URL+"/missing.txt")
+		assert.ErrorContains(t, err, "404")
+	})
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultNATSSubject is used when NATS_SUBJECT is unset
+const DefaultNATSSubject = "minivault.interactions"
+
+// Interaction is a completed prompt/response pair published to a broker
+type Interaction struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Prompt    string            `json:"prompt"`
+	Response  string            `json:"response"`
+	Streaming bool              `json:"streaming"`
+	Model     string            `json:"model"`
+	Source    string            `json:"source,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// Publisher sends completed interactions to a message broker
+type Publisher interface {
+	Publish(interaction Interaction) error
+	Close() error
+}
+
+// NATSPublisher publishes interactions as JSON to a NATS subject
+type NATSPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNATSPublisher connects to the NATS server at url. The client keeps
+// reconnecting in the background if the server goes away later.
+func NewNATSPublisher(url, subject string) (*NATSPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name("minivault"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %v", err)
+	}
+	return &NATSPublisher{conn: conn, subject: subject}, nil
+}
+
+// Publish sends an interaction. NATS buffers outgoing messages, so this
+// does not wait for the server.
+func (p *NATSPublisher) Publish(interaction Interaction) error {
+	data, err := json.Marshal(interaction)
+	if err != nil {
+		return fmt.Errorf("failed to marshal interaction: %v", err)
+	}
+	if err := p.conn.Publish(p.subject, data); err != nil {
+		return fmt.Errorf("failed to publish interaction: %v", err)
+	}
+	return nil
+}
+
+// Close flushes pending messages and closes the connection
+func (p *NATSPublisher) Close() error {
+	err := p.conn.Drain()
+	if err != nil {
+		p.conn.Close()
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// DefaultRecentErrors is how many errors are kept when RECENT_ERRORS is unset
+const DefaultRecentErrors = 50
+
+// RecentError summarizes a logged error for quick debugging. The prompt is
+// hashed so the buffer can be exposed without leaking request content.
+type RecentError struct {
+	ID         string    `json:"id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Error      string    `json:"error"`
+	PromptHash string    `json:"prompt_hash"`
+	Streaming  bool      `json:"streaming"`
+}
+
+// ErrorRing is a bounded buffer of the most recent errors
+type ErrorRing struct {
+	mu      sync.Mutex
+	entries []RecentError
+	next    int  // slot the next error is written to
+	full    bool // whether the buffer has wrapped
+}
+
+// NewErrorRing creates a ring holding up to size errors
+func NewErrorRing(size int) *ErrorRing {
+	return &ErrorRing{entries: make([]RecentError, size)}
+}
+
+// Add records an error log entry, evicting the oldest once full
+func (r *ErrorRing) Add(entry LogEntry) {
+	if len(r.entries) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[r.next] = RecentError{
+		ID:         entry.ID,
+		Timestamp:  entry.Timestamp,
+		Error:      entry.ErrorMessage,
+		PromptHash: hashPrompt(entry.Prompt),
+		Streaming:  entry.Streaming,
+	}
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Recent returns the buffered errors, newest first
+func (r *ErrorRing) Recent() []RecentError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := r.next
+	if r.full {
+		count = len(r.entries)
+	}
+	recent := make([]RecentError, 0, count)
+	for i := 1; i <= count; i++ {
+		recent = append(recent, r.entries[(r.next-i+len(r.entries))%len(r.entries)])
+	}
+	return recent
+}
+
+// hashPrompt returns the hex SHA-256 of a prompt
+func hashPrompt(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])
+}
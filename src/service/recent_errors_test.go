@@ -0,0 +1,50 @@
+package service
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorRing(t *testing.T) {
+	ring := NewErrorRing(3)
+	assert.Empty(t, ring.Recent())
+
+	for _, message := range []string{"first", "second", "third", "fourth"} {
+		ring.Add(LogEntry{ErrorMessage: message, Prompt: "prompt " + message})
+	}
+
+	// The oldest error is evicted and the rest are newest first
+	recent := ring.Recent()
+	assert.Len(t, recent, 3)
+	assert.Equal(t, "fourth", recent[0].Error)
+	assert.Equal(t, "third", recent[1].Error)
+	assert.Equal(t, "second", recent[2].Error)
+	assert.Equal(t, hashPrompt("prompt fourth"), recent[0].PromptHash)
+	assert.Len(t, recent[0].PromptHash, 64)
+}
+
+func TestErrorRing_Disabled(t *testing.T) {
+	ring := NewErrorRing(0)
+	ring.Add(LogEntry{ErrorMessage: "ignored"})
+	assert.Empty(t, ring.Recent())
+}
+
+func TestLoggingService_RecentErrorsHashUnscrubbedPrompt(t *testing.T) {
+	t.Setenv("LOG_BODIES", "false")
+	logger, err := NewLoggingService(filepath.Join(t.TempDir(), "log.jsonl"), "stub")
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	assert.NoError(t, logger.LogError("first prompt", errors.New("failed"), false, LogDetails{}))
+	assert.NoError(t, logger.LogError("second prompt", errors.New("failed"), false, LogDetails{}))
+
+	// The log file has no prompts, but the hashes still tell them apart
+	recent := logger.RecentErrors()
+	if assert.Len(t, recent, 2) {
+		assert.Equal(t, hashPrompt("second prompt"), recent[0].PromptHash)
+		assert.Equal(t, hashPrompt("first prompt"), recent[1].PromptHash)
+	}
+}
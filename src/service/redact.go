@@ -0,0 +1,73 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// RedactedText replaces redacted matches in logged prompts and responses
+const RedactedText = "[REDACTED]"
+
+// DefaultRedactPatterns match email addresses and card-like numbers of 13
+// to 19 digits, optionally grouped by spaces or dashes
+var DefaultRedactPatterns = []string{
+	`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	`\b\d(?:[ -]?\d){12,18}\b`,
+}
+
+// Redactor replaces matches of its patterns with RedactedText
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor compiles patterns, regular expressions matched anywhere in
+// the text
+func NewRedactor(patterns []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %v", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Redact returns text with every match replaced
+func (r *Redactor) Redact(text string) string {
+	for _, re := range r.patterns {
+		text = re.ReplaceAllString(text, RedactedText)
+	}
+	return text
+}
+
+// RedactorFromEnv builds the redactor LOG_REDACT enables, from the default
+// patterns plus the JSON array of LOG_REDACT_PATTERNS. It returns nil when
+// redaction is off. Bad settings are errors rather than ignored, since
+// carrying on would log what was meant to be redacted.
+func RedactorFromEnv() (*Redactor, error) {
+	raw := os.Getenv("LOG_REDACT")
+	if raw == "" {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOG_REDACT %q: %v", raw, err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+	patterns := append([]string{}, DefaultRedactPatterns...)
+	if rawPatterns := os.Getenv("LOG_REDACT_PATTERNS"); rawPatterns != "" {
+		var custom []string
+		if err := json.Unmarshal([]byte(rawPatterns), &custom); err != nil {
+			return nil, fmt.Errorf("LOG_REDACT_PATTERNS must be a JSON array of regular expressions: %v", err)
+		}
+		patterns = append(patterns, custom...)
+	}
+	return NewRedactor(patterns)
+}
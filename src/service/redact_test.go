@@ -0,0 +1,73 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor(t *testing.T) {
+	redactor, err := NewRedactor(append(DefaultRedactPatterns, `(?i)\bacct-\d+\b`))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "Email", text: "Mail jane.doe+test@example.co.uk today", want: "Mail [REDACTED] today"},
+		{name: "Card", text: "Card 4111 1111 1111 1111 expires soon", want: "Card [REDACTED] expires soon"},
+		{name: "Dashed card", text: "4111-1111-1111-1111", want: "[REDACTED]"},
+		{name: "Short number kept", text: "Order 12345 shipped", want: "Order 12345 shipped"},
+		{name: "Custom pattern", text: "Account ACCT-9921 is overdue", want: "Account [REDACTED] is overdue"},
+		{name: "Nothing to redact", text: "Tell me a joke", want: "Tell me a joke"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, redactor.Redact(tt.text))
+		})
+	}
+}
+
+func TestRedactorFromEnv(t *testing.T) {
+	redactor, err := RedactorFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, redactor, "off by default")
+
+	t.Setenv("LOG_REDACT", "true")
+	t.Setenv("LOG_REDACT_PATTERNS", `["secret-\\w+"]`)
+	redactor, err = RedactorFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, "[REDACTED] and [REDACTED]", redactor.Redact("a@b.io and secret-sauce"))
+
+	t.Setenv("LOG_REDACT_PATTERNS", `["("]`)
+	_, err = RedactorFromEnv()
+	assert.ErrorContains(t, err, `invalid redact pattern "("`)
+
+	t.Setenv("LOG_REDACT", "maybe")
+	_, err = RedactorFromEnv()
+	assert.ErrorContains(t, err, `invalid LOG_REDACT "maybe"`)
+}
+
+func TestLoggingService_Redaction(t *testing.T) {
+	t.Setenv("LOG_REDACT", "true")
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	logger, err := NewLoggingService(logPath, "stub")
+	assert.NoError(t, err)
+
+	email := "jane.doe@example.com"
+	assert.NoError(t, logger.LogInteraction("Write to "+email, "Sure, I'll write to "+email, false, LogDetails{}))
+	assert.NoError(t, logger.LogError("Email "+email, errors.New("backend down"), false, LogDetails{}))
+	assert.NoError(t, logger.Close())
+
+	data, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), email)
+	assert.Contains(t, string(data), `"prompt":"Write to [REDACTED]"`)
+	assert.Contains(t, string(data), `"response":"Sure, I'll write to [REDACTED]"`)
+	assert.Contains(t, string(data), `"prompt":"Email [REDACTED]"`)
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// DefaultLogMaxFiles is the number of rotated log files kept
+const DefaultLogMaxFiles = 5
+
+// rotatedSuffixFormat timestamps rotated files so they sort oldest first
+const rotatedSuffixFormat = "20060102T150405.000000000"
+
+// openLogFile opens path for appending and returns its current size
+func openLogFile(path string) (*os.File, int64, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// writeLine appends one JSON entry to the output, rotating the log file
+// once it grows past maxSize. The lock keeps concurrent entries from
+// interleaving and from racing a rotation.
+func (s *LoggingService) writeLine(jsonData []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.out == nil {
+		return os.ErrClosed
+	}
+	line := append(jsonData, '\n')
+	n, err := s.out.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if s.logFile != nil && s.maxSize > 0 && s.size > s.maxSize {
+		if _, err := s.rotate(); err != nil {
+			// Keep logging to the current file rather than losing entries
+			log.Printf("failed to rotate log file: %v", err)
+		}
+	}
+	return nil
+}
+
+// Rotate rotates the log file now, whatever its size, and returns the path
+// the old file was renamed to
+func (s *LoggingService) Rotate() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.out == nil {
+		return "", os.ErrClosed
+	}
+	if s.logFile == nil {
+		return "", ErrNoLogFile
+	}
+	return s.rotate()
+}
+
+// rotate renames the full log file with a timestamp suffix, opens a fresh
+// one and deletes the oldest rotated files beyond maxFiles, returning the
+// renamed file's path. The caller must hold s.mu.
+func (s *LoggingService) rotate() (string, error) {
+	if err := s.logFile.Close(); err != nil {
+		return "", err
+	}
+	rotated := s.logPath + "." + time.Now().UTC().Format(rotatedSuffixFormat)
+	renameErr := os.Rename(s.logPath, rotated)
+
+	// Reopen even if the rename failed so logging can continue
+	logFile, size, err := openLogFile(s.logPath)
+	if err != nil {
+		s.logFile = nil
+		s.setOutput() // keep writing to the stream, if there is one
+		return "", fmt.Errorf("failed to reopen log file: %v", err)
+	}
+	s.logFile, s.size = logFile, size
+	s.setOutput()
+	if renameErr != nil {
+		return "", renameErr
+	}
+	return rotated, s.pruneRotated()
+}
+
+// pruneRotated deletes the oldest rotated log files beyond maxFiles
+func (s *LoggingService) pruneRotated() error {
+	rotated, err := filepath.Glob(s.logPath + ".*")
+	if err != nil {
+		return err
+	}
+	if len(rotated) <= s.maxFiles {
+		return nil
+	}
+	sort.Strings(rotated)
+	for _, path := range rotated[:len(rotated)-s.maxFiles] {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
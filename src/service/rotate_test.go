@@ -0,0 +1,117 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readEntries parses every line of the given log files
+func readEntries(t *testing.T, paths []string) []LogEntry {
+	var entries []LogEntry
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var entry LogEntry
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry), "line in %s: %s", path, scanner.Text())
+			entries = append(entries, entry)
+		}
+		file.Close()
+	}
+	return entries
+}
+
+func TestLoggingService_Rotation(t *testing.T) {
+	t.Setenv("LOG_MAX_SIZE", "2048")
+	t.Setenv("LOG_MAX_FILES", "2")
+	logPath := filepath.Join(t.TempDir(), "log.jsonl")
+	logger, err := NewLoggingService(logPath, "stub")
+	assert.NoError(t, err)
+	defer logger.Close()
+
+	// Each entry is several hundred bytes, so this rotates many times
+	for i := 0; i < 30; i++ {
+		assert.NoError(t, logger.LogInteraction(fmt.Sprintf("prompt %d", i), "response", false, LogDetails{}))
+	}
+
+	rotated, err := filepath.Glob(logPath + ".*")
+	assert.NoError(t, err)
+	assert.Len(t, rotated, 2) // the oldest were deleted
+
+	info, err := os.Stat(logPath)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(2048))
+
+	// The newest entry is in the fresh file and older ones were rotated out
+	entries := readEntries(t, append(rotated, logPath))
+	assert.Equal(t, "prompt 29", entries[len(entries)-1].Prompt)
+	assert.Less(t, len(entries), 30)
+}
+
+func TestLoggingService_Rotate(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "log.jsonl")
+	logger, err := NewLoggingService(logPath, "stub")
+	assert.NoError(t, err)
+	defer logger.Close()
+	assert.NoError(t, logger.LogInteraction("before", "response", false, LogDetails{}))
+
+	// Rotation doesn't wait for LOG_MAX_SIZE
+	rotated, err := logger.Rotate()
+	assert.NoError(t, err)
+	assert.NoError(t, logger.LogInteraction("after", "response", false, LogDetails{}))
+	assert.Equal(t, []string{"before"}, promptsOf(readEntries(t, []string{rotated})))
+	assert.Equal(t, []string{"after"}, promptsOf(readEntries(t, []string{logPath})))
+
+	stdout, err := NewLoggingServiceTo(LogOutputStdout, logPath, "stub")
+	assert.NoError(t, err)
+	defer stdout.Close()
+	_, err = stdout.Rotate()
+	assert.ErrorIs(t, err, ErrNoLogFile)
+}
+
+// promptsOf lists the prompts of entries in order
+func promptsOf(entries []LogEntry) []string {
+	var prompts []string
+	for _, entry := range entries {
+		prompts = append(prompts, entry.Prompt)
+	}
+	return prompts
+}
+
+func TestLoggingService_RotationConcurrent(t *testing.T) {
+	t.Setenv("LOG_MAX_SIZE", "4096")
+	t.Setenv("LOG_MAX_FILES", "1000")
+	logPath := filepath.Join(t.TempDir(), "log.jsonl")
+	logger, err := NewLoggingService(logPath, "stub")
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				logger.LogInteraction(fmt.Sprintf("prompt %d-%d", i, j), "response", false, LogDetails{})
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.NoError(t, logger.Close())
+
+	// No entry was lost or torn across a rotation
+	rotated, err := filepath.Glob(logPath + ".*")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, rotated)
+	assert.Len(t, readEntries(t, append(rotated, logPath)), 200)
+}
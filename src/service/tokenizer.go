@@ -0,0 +1,122 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultTokenizer is the tokenizer used when TOKENIZER is unset
+const DefaultTokenizer = "approx"
+
+// Tokenizer counts the tokens in a response for the log's token_count
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// TokenizerFunc adapts an ordinary function to Tokenizer
+type TokenizerFunc func(text string) int
+
+// CountTokens calls f(text)
+func (f TokenizerFunc) CountTokens(text string) int {
+	return f(text)
+}
+
+// tokenizers holds the available tokenizers by name. Build-tagged files
+// register more, e.g. "tiktoken".
+var tokenizers = map[string]func() (Tokenizer, error){
+	"approx": func() (Tokenizer, error) { return TokenizerFunc(approxTokens), nil },
+	"words":  func() (Tokenizer, error) { return TokenizerFunc(countWords), nil },
+}
+
+// NewTokenizer returns the named tokenizer
+func NewTokenizer(name string) (Tokenizer, error) {
+	newTokenizer, ok := tokenizers[name]
+	if !ok {
+		names := make([]string, 0, len(tokenizers))
+		for name := range tokenizers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown tokenizer %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return newTokenizer()
+}
+
+// TokenizerFromEnv returns the tokenizer named by TOKENIZER, falling back to
+// DefaultTokenizer when it's unset or unknown
+func TokenizerFromEnv() Tokenizer {
+	tokenizer, _ := NewTokenizer(DefaultTokenizer)
+	if name := os.Getenv("TOKENIZER"); name != "" {
+		if t, err := NewTokenizer(name); err == nil {
+			tokenizer = t
+		} else {
+			log.Printf("Ignoring TOKENIZER: %v", err)
+		}
+	}
+	return tokenizer
+}
+
+// approxTokens estimates BPE token counts without a vocabulary. Runs of
+// letters and digits cost a token per five characters, the usual four
+// characters per token counting the space BPE vocabularies fold into each
+// word, and runs of ASCII punctuation a token per two.
+// CJK characters are rarely merged and cost a token each, while other
+// multi-byte symbols such as emoji are split into byte-level tokens.
+func approxTokens(text string) int {
+	tokens := 0
+	word, punct := 0, 0 // lengths of the current letter/digit and punctuation runs
+	flush := func() {
+		tokens += (word+4)/5 + (punct+1)/2
+		word, punct = 0, 0
+	}
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case isCJK(r):
+			flush()
+			tokens++
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if punct > 0 {
+				flush()
+			}
+			word++
+		case r < utf8.RuneSelf:
+			if word > 0 {
+				flush()
+			}
+			punct++
+		default:
+			flush()
+			tokens += utf8.RuneLen(r) - 1
+		}
+	}
+	flush()
+	return tokens
+}
+
+// isCJK reports whether r is a Chinese, Japanese or Korean character
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// countWords counts whitespace-separated words, the original estimate.
+// It badly undercounts code, CJK text and emoji.
+func countWords(text string) int {
+	words := 0
+	inWord := false
+	for _, r := range text {
+		if r == ' ' || r == '\n' || r == '\t' {
+			inWord = false
+		} else if !inWord {
+			words++
+			inWord = true
+		}
+	}
+	return words
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApproxTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		wantWords int
+		want      int
+	}{
+		{name: "Empty", text: "", wantWords: 0, want: 0},
+		{name: "English", text: "The quick brown fox", wantWords: 4, want: 4},
+		{name: "CJK has no spaces", text: "你好世界，今天天气很好", wantWords: 1, want: 12},
+		{name: "Code is mostly punctuation", text: `fmt.Println("hi")`, wantWords: 1, want: 7},
+		{name: "Emoji", text: "🎉🎉🎉", wantWords: 1, want: 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantWords, countWords(tt.text))
+			assert.Equal(t, tt.want, approxTokens(tt.text))
+		})
+	}
+}
+
+func TestNewTokenizer(t *testing.T) {
+	tokenizer, err := NewTokenizer(DefaultTokenizer)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, tokenizer.CountTokens(`fmt.Println("hi")`))
+
+	tokenizer, err = NewTokenizer("words")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, tokenizer.CountTokens(`fmt.Println("hi")`))
+
+	_, err = NewTokenizer("unknown")
+	assert.ErrorContains(t, err, `unknown tokenizer "unknown"`)
+}
+
+func TestLoggingService_Tokenizer(t *testing.T) {
+	code := `fmt.Println("hi")`
+	for name, want := range map[string]int{"": 7, "approx": 7, "words": 1} {
+		t.Run("TOKENIZER="+name, func(t *testing.T) {
+			t.Setenv("TOKENIZER", name)
+			logPath := filepath.Join(t.TempDir(), "test.log")
+			logger, err := NewLoggingService(logPath, "stub")
+			assert.NoError(t, err)
+			defer logger.Close()
+
+			assert.NoError(t, logger.LogInteraction("prompt", code, false, LogDetails{}))
+			logData, err := os.ReadFile(logPath)
+			assert.NoError(t, err)
+			var entry LogEntry
+			assert.NoError(t, json.Unmarshal(logData, &entry))
+			assert.Equal(t, want, entry.TokenCount)
+		})
+	}
+}
@@ -0,0 +1,28 @@
+//go:build tiktoken
+
+package service
+
+import (
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// TiktokenEncoding is the BPE vocabulary used by the "tiktoken" tokenizer
+const TiktokenEncoding = "cl100k_base"
+
+func init() {
+	tokenizers["tiktoken"] = newTiktokenTokenizer
+}
+
+// newTiktokenTokenizer counts tokens exactly with OpenAI's cl100k_base
+// vocabulary, which is embedded in the binary so no download is needed
+func newTiktokenTokenizer() (Tokenizer, error) {
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+	encoding, err := tiktoken.GetEncoding(TiktokenEncoding)
+	if err != nil {
+		return nil, err
+	}
+	return TokenizerFunc(func(text string) int {
+		return len(encoding.Encode(text, nil, nil))
+	}), nil
+}
@@ -0,0 +1,31 @@
+//go:build tiktoken
+
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTiktokenTokenizer(t *testing.T) {
+	tokenizer, err := NewTokenizer("tiktoken")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{name: "English", text: "The quick brown fox", want: 4},
+		{name: "CJK", text: "你好世界，今天天气很好", want: 14},
+		{name: "Code", text: `fmt.Println("hi")`, want: 5},
+		{name: "Emoji", text: "🎉🎉🎉", want: 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tokenizer.CountTokens(tt.text))
+		})
+	}
+}
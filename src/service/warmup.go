@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"minivault/src/llm"
+)
+
+// DefaultWarmupTimeout bounds the warmup generation
+const DefaultWarmupTimeout = 30 * time.Second
+
+// ErrWarmupMismatch is returned when the warmup answer lacks the expected text
+var ErrWarmupMismatch = errors.New("warmup response did not contain the expected text")
+
+// WarmupProbe sends a fixed prompt at startup and checks the answer contains
+// an expected substring, catching deployments serving the wrong model
+type WarmupProbe struct {
+	Prompt  string
+	Expect  string // case-insensitive substring the response must contain
+	Timeout time.Duration
+}
+
+// Run generates a response to the probe prompt. It returns an error wrapping
+// ErrWarmupMismatch when the response doesn't contain Expect, or the
+// generation error if the backend failed.
+func (p WarmupProbe) Run(ctx context.Context, generator Generator) error {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultWarmupTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result, err := generator.Generate(ctx, p.Prompt, llm.Options{})
+	if err != nil {
+		return fmt.Errorf("warmup generation failed: %v", err)
+	}
+	if !strings.Contains(strings.ToLower(result.Response), strings.ToLower(p.Expect)) {
+		return fmt.Errorf("%w: expected %q in %q", ErrWarmupMismatch, p.Expect, truncateRunes(result.Response, 200))
+	}
+	return nil
+}
+
+// truncateRunes shortens s to at most n runes for error messages
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarmupProbe(t *testing.T) {
+	generator := NewGeneratorService("stub")
+
+	tests := []struct {
+		name    string
+		probe   WarmupProbe
+		wantErr bool
+	}{
+		{
+			name:  "Response contains the expected text",
+			probe: WarmupProbe{Prompt: "What is the capital of France?", Expect: "STUBBED response"},
+		},
+		{
+			name:    "Response lacks the expected text",
+			probe:   WarmupProbe{Prompt: "What is the capital of France?", Expect: "Paris is the capital"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.probe.Run(context.Background(), generator)
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrWarmupMismatch)
+			assert.ErrorContains(t, err, "Paris is the capital")
+		})
+	}
+}
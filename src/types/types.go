@@ -1,11 +1,35 @@
 package types
 
+import "encoding/json"
+
 // Request represents the input prompt structure
 // @Description Request payload for text generation
 type Request struct {
 	// The prompt text to generate from
 	// @Example "Tell me a joke"
-	Prompt string `json:"prompt" binding:"required" example:"Tell me a joke"`
+	Prompt string `json:"prompt" binding:"required_without=PromptURL" example:"Tell me a joke"`
+	// Optional URL to fetch the prompt from instead, when enabled for its host
+	PromptURL string `json:"prompt_url,omitempty" example:"https://prompts.internal/summary.txt"`
+	// Optional model to use instead of the server default; must be allowlisted
+	Model string `json:"model,omitempty" example:"llama2"`
+	// Optional system prompt replacing the server's SYSTEM_PROMPT; an empty
+	// string sends no system prompt
+	System *string `json:"system,omitempty" example:"You are a terse assistant."`
+	// Optional function/tool schemas the model may call
+	Tools []Tool `json:"tools,omitempty"`
+	// Optional sequences that end generation, merged with the model's defaults
+	Stop []string `json:"stop,omitempty" example:"\n\n"`
+	// Optional sampling temperature
+	Temperature *float64 `json:"temperature,omitempty" example:"0.7"`
+	// Optional nucleus sampling probability mass
+	TopP *float64 `json:"top_p,omitempty" example:"0.9"`
+	// Optional limit on the number of generated tokens
+	MaxTokens *int `json:"max_tokens,omitempty" example:"256"`
+	// Optional format to convert a JSON response to: "json", "yaml" or "csv"
+	OutputFormat string `json:"output_format,omitempty" example:"yaml"`
+	// Whether to also return the model's output as received, before any
+	// server-side post-processing
+	IncludeRaw bool `json:"include_raw,omitempty"`
 }
 
 // Response represents the output response structure
@@ -14,6 +38,168 @@ type Response struct {
 	// The generated response text
 	// @Example "Why did the chicken cross the road? To get to the other side!"
 	Response string `json:"response" example:"Why did the chicken cross the road? To get to the other side!"`
+	// Tool calls requested by the model, if any
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// Whether the response contained invalid UTF-8 that was sanitized
+	EncodingIssue bool `json:"encoding_issue,omitempty"`
+	// Whether the response was still empty after the configured retries
+	EmptyResponse bool `json:"empty_response,omitempty"`
+	// Whether output_format was requested but the response couldn't be
+	// converted, in which case it is returned as generated
+	FormatIssue bool `json:"format_issue,omitempty"`
+	// Whether a double-escaped JSON response was unwrapped one level
+	JSONUnwrapped bool `json:"json_unwrapped,omitempty"`
+	// Why generation ended early: "timeout" when the deadline passed and,
+	// with PARTIAL_ON_TIMEOUT, the text generated so far is returned
+	FinishReason string `json:"finish_reason,omitempty" example:"timeout"`
+	// The unprocessed model output, when include_raw was requested
+	RawResponse *string `json:"raw_response,omitempty"`
+	// Tokens in the prompt as sent, the response and both together.
+	// Counted by the backend when it reports usage, otherwise with TOKENIZER.
+	PromptTokens   int `json:"prompt_tokens,omitempty" example:"12"`
+	ResponseTokens int `json:"response_tokens,omitempty" example:"18"`
+	TotalTokens    int `json:"total_tokens,omitempty" example:"30"`
+}
+
+// Tool represents a function the model is allowed to call
+// @Description Function/tool schema forwarded to the backend
+type Tool struct {
+	// Tool type, currently always "function"
+	Type string `json:"type" example:"function"`
+	// Function definition
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction describes a callable function and its JSON schema parameters
+type ToolFunction struct {
+	// Function name
+	Name string `json:"name" example:"get_weather"`
+	// Human readable description of what the function does
+	Description string `json:"description,omitempty" example:"Get the current weather for a city"`
+	// JSON schema describing the function parameters
+	Parameters json.RawMessage `json:"parameters,omitempty" swaggertype:"object"`
+}
+
+// ToolCall represents a function call requested by the model
+// @Description Function call returned by the model
+type ToolCall struct {
+	// The function to call
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction holds the name and arguments of a requested function call
+type ToolCallFunction struct {
+	// Function name
+	Name string `json:"name" example:"get_weather"`
+	// Arguments as a JSON object
+	Arguments json.RawMessage `json:"arguments" swaggertype:"object"`
+}
+
+// Message is one turn of a chat conversation
+// @Description Chat message with its author's role
+type Message struct {
+	// Who wrote the message: "system", "user" or "assistant"
+	Role string `json:"role" binding:"required" example:"user"`
+	// The message text
+	Content string `json:"content" example:"What's the capital of France?"`
+}
+
+// ChatRequest represents the input of a multi-turn chat
+// @Description Request payload for chat completion
+type ChatRequest struct {
+	// The conversation so far, oldest first, ending with a user message
+	Messages []Message `json:"messages" binding:"required,dive"`
+	// Optional model to use instead of the server default; must be allowlisted
+	Model string `json:"model,omitempty" example:"llama2"`
+	// Optional sequences that end generation, merged with the model's defaults
+	Stop []string `json:"stop,omitempty" example:"\n\n"`
+	// Optional sampling temperature
+	Temperature *float64 `json:"temperature,omitempty" example:"0.7"`
+	// Optional nucleus sampling probability mass
+	TopP *float64 `json:"top_p,omitempty" example:"0.9"`
+	// Optional limit on the number of generated tokens
+	MaxTokens *int `json:"max_tokens,omitempty" example:"256"`
+	// Optional system prompt replacing the server's SYSTEM_PROMPT; an empty
+	// string sends no system prompt
+	System *string `json:"system,omitempty" example:"You are a terse assistant."`
+}
+
+// ChatResponse represents the output of a chat
+// @Description Response payload containing the assistant's reply
+type ChatResponse struct {
+	// The assistant's reply
+	Message Message `json:"message"`
+}
+
+// BatchRequest represents several prompts generated with the same settings
+// @Description Request payload for batch text generation
+type BatchRequest struct {
+	// The prompts to generate from
+	Prompts []string `json:"prompts" binding:"required" example:"Tell me a joke,Tell me a fact"`
+	// Optional model to use instead of the server default; must be allowlisted
+	Model string `json:"model,omitempty" example:"llama2"`
+	// Optional system prompt replacing the server's SYSTEM_PROMPT
+	System *string `json:"system,omitempty" example:"You are a terse assistant."`
+	// Optional sequences that end generation, merged with the model's defaults
+	Stop []string `json:"stop,omitempty" example:"\n\n"`
+	// Optional sampling temperature
+	Temperature *float64 `json:"temperature,omitempty" example:"0.7"`
+	// Optional nucleus sampling probability mass
+	TopP *float64 `json:"top_p,omitempty" example:"0.9"`
+	// Optional limit on the number of generated tokens
+	MaxTokens *int `json:"max_tokens,omitempty" example:"256"`
+	// Optional base seed: prompt i is generated with seed+i, so rerunning
+	// the batch reproduces each result on backends that support seeds
+	Seed *int `json:"seed,omitempty" example:"42"`
+}
+
+// BatchResult is the outcome of one prompt of a batch
+type BatchResult struct {
+	// The generated response text, when the prompt succeeded
+	Response string `json:"response,omitempty" example:"Why did the chicken cross the road?"`
+	// Why the prompt failed, when it did, and the matching error code
+	Error string `json:"error,omitempty" example:"Failed to generate response"`
+	Code  string `json:"code,omitempty" example:"generation_failed"`
+	// "timeout" when the response is the text generated before the deadline
+	FinishReason string `json:"finish_reason,omitempty" example:"timeout"`
+	// Tokens in the prompt as sent, the response and both together
+	PromptTokens   int `json:"prompt_tokens,omitempty" example:"12"`
+	ResponseTokens int `json:"response_tokens,omitempty" example:"18"`
+	TotalTokens    int `json:"total_tokens,omitempty" example:"30"`
+}
+
+// BatchResponse represents the results of a batch
+// @Description Response payload containing one result per prompt
+type BatchResponse struct {
+	// One result per prompt, in prompt order
+	Results []BatchResult `json:"results"`
+}
+
+// ErrorResponse represents a failed request
+// @Description Error payload with a machine-readable code
+type ErrorResponse struct {
+	// Human-readable description of the error
+	Error string `json:"error" example:"Failed to generate response"`
+	// Stable error code to branch on; each code has a fixed HTTP status
+	Code string `json:"code" example:"generation_failed"`
+	// Optional specifics, such as the context window size in "limit"
+	Details map[string]any `json:"details,omitempty" swaggertype:"object"`
+}
+
+// EmbeddingsRequest represents the texts to embed
+// @Description Request payload for embeddings
+type EmbeddingsRequest struct {
+	// The texts to embed, in one batch
+	Input []string `json:"input" binding:"required" example:"first text,second text"`
+}
+
+// EmbeddingsResponse represents the embedding vectors
+// @Description Response payload containing one vector per input
+type EmbeddingsResponse struct {
+	// One vector per input, in input order
+	Embeddings [][]float64 `json:"embeddings"`
+	// The model that produced the vectors
+	Model string `json:"model" example:"nomic-embed-text"`
 }
 
 // LogEntry represents a single log entry